@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+var (
+	heatLevels  = []rune(" ░▒▓█")
+	sparkLevels = []rune("▁▂▃▄▅▆▇█")
+)
+
+// stringList collects a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// level scales n against max onto len(levels) steps, reserving the first
+// step for zero.
+func level(levels []rune, n, max int) rune {
+	if n == 0 || max == 0 {
+		return levels[0]
+	}
+	i := 1 + (n-1)*(len(levels)-1)/max
+	if i >= len(levels) {
+		i = len(levels) - 1
+	}
+	return levels[i]
+}
+
+// startOfWeek truncates t to the preceding Sunday in local time.
+func startOfWeek(t time.Time) time.Time {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	return day.AddDate(0, 0, -int(day.Weekday()))
+}
+
+// renderHeatmap draws one row per weekday and one column per week, ending
+// with the current week.
+func renderHeatmap(events []*nostr.Event, weeks int, now time.Time) string {
+	first := startOfWeek(now).AddDate(0, 0, -7*(weeks-1))
+	counts := make([][7]int, weeks)
+	max := 0
+	for _, ev := range events {
+		t := ev.CreatedAt.Time().Local()
+		w := int(startOfWeek(t).Sub(first).Hours()+12) / (24 * 7)
+		if w < 0 || w >= weeks {
+			continue
+		}
+		counts[w][t.Weekday()]++
+		if counts[w][t.Weekday()] > max {
+			max = counts[w][t.Weekday()]
+		}
+	}
+
+	var b strings.Builder
+	for day := 0; day < 7; day++ {
+		fmt.Fprintf(&b, "%s ", time.Weekday(day).String()[:3])
+		for w := 0; w < weeks; w++ {
+			b.WriteRune(level(heatLevels, counts[w][day], max))
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "    %s … %s\n", first.Format("2006-01-02"), now.Format("2006-01-02"))
+	return b.String()
+}
+
+// sparkline summarises events per week over the same window as the heatmap.
+func sparkline(events []*nostr.Event, weeks int, now time.Time) string {
+	first := startOfWeek(now).AddDate(0, 0, -7*(weeks-1))
+	counts := make([]int, weeks)
+	max := 0
+	for _, ev := range events {
+		w := int(startOfWeek(ev.CreatedAt.Time().Local()).Sub(first).Hours()+12) / (24 * 7)
+		if w < 0 || w >= weeks {
+			continue
+		}
+		counts[w]++
+		if counts[w] > max {
+			max = counts[w]
+		}
+	}
+	var b strings.Builder
+	for _, n := range counts {
+		if n == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		b.WriteRune(level(sparkLevels, n, max))
+	}
+	return b.String()
+}
+
+// breakdown prints the keys of counts by descending count.
+func breakdown(title string, counts map[string]int, limit int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s:\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "  %5d  %s\n", counts[k], k)
+	}
+	return b.String()
+}
+
+func cmdActivity(args []string) error {
+	fs := flag.NewFlagSet("activity", flag.ContinueOnError)
+	var authors stringList
+	fs.Var(&authors, "author", "npub to include (repeatable, default: your own key)")
+	weeks := fs.Int("weeks", 26, "number of weeks to show")
+	top := fs.Int("top", 10, "number of files to list")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if *weeks < 1 {
+		return fmt.Errorf("--weeks must be at least 1")
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	var pubkeys []string
+	for _, a := range authors {
+		pk, err := parsePubkey(a)
+		if err != nil {
+			return err
+		}
+		pubkeys = append(pubkeys, pk)
+	}
+	if len(pubkeys) == 0 {
+		pubkeys = []string{client.pk}
+	}
+
+	now := time.Now()
+	since := nostr.Timestamp(startOfWeek(now).AddDate(0, 0, -7*(*weeks-1)).Unix())
+	var events []*nostr.Event
+	perAuthor := make(map[string][]*nostr.Event)
+	perFile := make(map[string]int)
+	for _, pk := range pubkeys {
+		history := client.History(pk, since)
+		npub, _ := nip19.EncodePublicKey(pk)
+		perAuthor[npub] = history
+		events = append(events, history...)
+		for _, ev := range history {
+			perFile[eventPath(ev)]++
+		}
+	}
+
+	fmt.Printf("%d publishes in the last %d weeks\n\n", len(events), *weeks)
+	fmt.Print(renderHeatmap(events, *weeks, now))
+	fmt.Println()
+	if len(perAuthor) > 1 {
+		fmt.Println("By author:")
+		npubs := make([]string, 0, len(perAuthor))
+		for npub := range perAuthor {
+			npubs = append(npubs, npub)
+		}
+		sort.Strings(npubs)
+		for _, npub := range npubs {
+			evs := perAuthor[npub]
+			fmt.Printf("  %s %s %d\n", npub, sparkline(evs, *weeks, now), len(evs))
+		}
+		fmt.Println()
+	} else {
+		fmt.Printf("Weekly: %s\n\n", sparkline(events, *weeks, now))
+	}
+	fmt.Print(breakdown("Most changed files", perFile, *top))
+	return nil
+}
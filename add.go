@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// isPattern reports whether arg is a glob rather than a path, as when the
+// shell was told not to expand it ('*.md').
+func isPattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?")
+}
+
+// expandAdd resolves the arguments of orbi add to repo-relative paths.
+// Directories are added recursively and patterns are matched like
+// .orbiignore patterns against every file in the repository; neither picks
+// up ignored files. Naming an ignored file directly needs force.
+func (r *Repo) expandAdd(args []string, force bool) ([]string, error) {
+	patterns, err := r.ignorePatterns()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var paths []string
+	add := func(rel string) {
+		if !seen[rel] {
+			seen[rel] = true
+			paths = append(paths, rel)
+		}
+	}
+	for _, arg := range args {
+		if isPattern(arg) {
+			matched := 0
+			err := r.walkFiles(r.root, func(rel string) error {
+				if matchGlob(arg, rel) && !ignored(patterns, rel) {
+					add(rel)
+					matched++
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			if matched == 0 {
+				return nil, fmt.Errorf("pattern %q matched no files: %w", arg, ErrNotFound)
+			}
+			continue
+		}
+
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			if err := r.checkInside(arg); err != nil {
+				return nil, err
+			}
+			err := r.walkFiles(arg, func(rel string) error {
+				if checkRel(rel) == nil && !ignored(patterns, rel) {
+					add(rel)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rel, err := r.Rel(arg)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRel(rel); err != nil {
+			return nil, err
+		}
+		if !force && ignored(patterns, rel) {
+			return nil, fmt.Errorf("%s is ignored by %s; use --force to add it anyway", rel, ignoreFileName)
+		}
+		add(rel)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func cmdAdd(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	force := fs.Bool("force", false, "add files even if they are ignored")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: orbi add [--force] <file|dir|pattern>...")
+	}
+	repo := openRepo(".")
+	paths, err := repo.expandAdd(positional, *force)
+	if err != nil {
+		return err
+	}
+	staged, err := repo.Stage(paths)
+	if err != nil {
+		return err
+	}
+	for _, rel := range staged {
+		fmt.Printf("  staged %s\n", rel)
+	}
+	fmt.Printf("Staged %d file(s); record them with orbi commit\n", len(staged))
+	return nil
+}
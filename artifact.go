@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// eventKindFileMetadata is the NIP-94 file metadata kind used to describe
+// release artifacts.
+const eventKindFileMetadata = 1063
+
+// artifact is a release artifact as described by its metadata event.
+type artifact struct {
+	Name     string
+	Platform string
+	URL      string
+	SHA256   string
+	Size     int64
+}
+
+func parseArtifact(ev *nostr.Event) artifact {
+	a := artifact{}
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "name":
+			a.Name = tag[1]
+		case "platform":
+			a.Platform = tag[1]
+		case "url":
+			a.URL = tag[1]
+		case "x":
+			a.SHA256 = tag[1]
+		case "size":
+			a.Size, _ = strconv.ParseInt(tag[1], 10, 64)
+		}
+	}
+	return a
+}
+
+// attachArtifact uploads the file at path to server and publishes a metadata
+// event linking it to release.
+func (c *Client) attachArtifact(release *nostr.Event, server, path, platform string) (*nostr.Event, error) {
+	data, err := readFileObserved(c.Observer, path)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	mimeType := orbi.DetectMIME(name, data)
+	desc, err := c.blossomUpload(server, data, mimeType, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindFileMetadata,
+		Content:   fmt.Sprintf("%s %s", tagName(release), name),
+		Tags: nostr.Tags{
+			{"ver", eventFormatVersion},
+			{"e", release.ID, "", "release"},
+			{"name", name},
+			{"url", desc.URL},
+			{"m", mimeType},
+			{"x", desc.SHA256},
+			{"size", strconv.Itoa(len(data))},
+		},
+	}
+	if platform != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"platform", platform})
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// releaseArtifacts returns the artifacts published for release by its author.
+func (c *Client) releaseArtifacts(release *nostr.Event) []artifact {
+	events := c.query(nostr.Filter{
+		Kinds:   []int{eventKindFileMetadata},
+		Authors: []string{release.PubKey},
+		Tags:    nostr.TagMap{"e": []string{release.ID}},
+	})
+	var artifacts []artifact
+	for _, ev := range events {
+		artifacts = append(artifacts, parseArtifact(ev))
+	}
+	return artifacts
+}
+
+func cmdReleaseAttach(args []string) error {
+	fs := flag.NewFlagSet("release attach", flag.ContinueOnError)
+	platform := fs.String("platform", "", "platform the artifact is built for, e.g. linux/amd64")
+	server := fs.String("server", "", "Blossom server to upload to (default: first of blossom_servers in config)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 2 {
+		return fmt.Errorf("usage: orbi release attach <version> <file>...")
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	if *server == "" {
+		cfg, err := client.Repo.Config()
+		if err != nil {
+			return err
+		}
+		if len(cfg.BlossomServers) == 0 {
+			return fmt.Errorf("no Blossom server configured; pass --server or set blossom_servers in .orbi/config")
+		}
+		*server = cfg.BlossomServers[0]
+	}
+	release, err := client.findRelease("", positional[0])
+	if err != nil {
+		return err
+	}
+	for _, path := range positional[1:] {
+		ev, err := client.attachArtifact(release, *server, path, *platform)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Printf("Attached %s to %s\nEvent ID: %s\n", filepath.Base(path), positional[0], ev.ID)
+	}
+	return nil
+}
+
+func cmdReleaseDownload(args []string) error {
+	fs := flag.NewFlagSet("release download", flag.ContinueOnError)
+	author := fs.String("author", "", "npub of the release author (default: your own key)")
+	outDir := fs.String("o", ".", "directory to write artifacts to")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 {
+		return fmt.Errorf("usage: orbi release download <version> [name]...")
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	var authorPK string
+	if *author != "" {
+		if authorPK, err = parsePubkey(*author); err != nil {
+			return err
+		}
+	}
+	release, err := client.findRelease(authorPK, positional[0])
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range positional[1:] {
+		wanted[name] = true
+	}
+	artifacts := client.releaseArtifacts(release)
+	if len(artifacts) == 0 {
+		return fmt.Errorf("release %s has no artifacts: %w", positional[0], ErrNotFound)
+	}
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+	for _, a := range artifacts {
+		if len(wanted) > 0 && !wanted[a.Name] {
+			continue
+		}
+		data, err := fetchBlob(a.URL, a.SHA256, a.Name, client.Observer)
+		if err != nil {
+			return fmt.Errorf("%s: %w", a.Name, err)
+		}
+		if a.Size > 0 && int64(len(data)) != a.Size {
+			return fmt.Errorf("%s: size mismatch: got %d bytes, expected %d", a.Name, len(data), a.Size)
+		}
+		out := filepath.Join(*outDir, filepath.Base(a.Name))
+		if err := ioutil.WriteFile(out, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Downloaded %s (%d bytes, sha256 %s verified)\n", out, len(data), a.SHA256)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// eventKindBenchProbe is an ephemeral kind, so relays forward probes without
+// storing them.
+const eventKindBenchProbe = 24444
+
+// benchSizes are the event sizes probed, smallest first.
+var benchSizes = []int{16 << 10, 64 << 10, 128 << 10, 256 << 10, 512 << 10, 1 << 20}
+
+// benchResult holds the measurements for one relay.
+type benchResult struct {
+	url                     string
+	connect, publish, query time.Duration
+	maxSize                 int
+	err                     error
+}
+
+// probe builds a signed ephemeral event with content of roughly size bytes.
+func probe(sk string, size int) nostr.Event {
+	pk, _ := nostr.GetPublicKey(sk)
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindBenchProbe,
+		Tags:      nostr.Tags{{"t", "orbi-bench"}},
+		Content:   strings.Repeat("x", size),
+	}
+	ev.Sign(sk)
+	return ev
+}
+
+// benchRelay measures one relay using a throwaway key so probes can't be
+// attributed to the user.
+func benchRelay(t orbi.WebsocketTransport, url string, sizes bool) benchResult {
+	res := benchResult{url: url}
+	sk := nostr.GeneratePrivateKey()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRelayTimeout)
+	defer cancel()
+	start := time.Now()
+	relay, err := t.Connect(ctx, url)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer relay.Close()
+	res.connect = time.Since(start)
+
+	start = time.Now()
+	if err := relay.Publish(ctx, probe(sk, 0)); err != nil {
+		res.err = fmt.Errorf("publish: %w", orbi.ParseRejection(err.Error()))
+		return res
+	}
+	res.publish = time.Since(start)
+
+	start = time.Now()
+	if _, err := relay.QuerySync(ctx, nostr.Filter{Kinds: []int{eventKindFile}, Limit: 50}); err != nil {
+		res.err = fmt.Errorf("query: %w", err)
+		return res
+	}
+	res.query = time.Since(start)
+
+	if sizes {
+		for _, size := range benchSizes {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultRelayTimeout)
+			err := relay.Publish(ctx, probe(sk, size))
+			cancel()
+			if err != nil || !relay.IsConnected() {
+				break
+			}
+			res.maxSize = size
+		}
+	}
+	return res
+}
+
+func formatSize(n int) string {
+	switch {
+	case n == 0:
+		return "-"
+	case n >= 1<<20:
+		return fmt.Sprintf(">=%dMiB", n>>20)
+	default:
+		return fmt.Sprintf(">=%dKiB", n>>10)
+	}
+}
+
+func cmdBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	noSizes := fs.Bool("no-size", false, "skip probing the maximum accepted event size")
+	relays, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	if cfg, err = withGlobalRelays(cfg); err != nil {
+		return err
+	}
+	tlsConfigs, err := relayTLSConfigs(cfg)
+	if err != nil {
+		return err
+	}
+	if len(relays) == 0 {
+		relays = configuredRelays(cfg)
+	}
+
+	t := orbi.WebsocketTransport{TLS: tlsConfigs, Proxied: proxyURL != nil}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RELAY\tCONNECT\tPUBLISH\tQUERY\tMAX SIZE\t")
+	for _, url := range relays {
+		res := benchRelay(t, url, !*noSizes)
+		if res.err != nil {
+			fmt.Fprintf(w, "%s\terror: %v\t\t\t\t\n", url, res.err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", url,
+			res.connect.Round(time.Millisecond), res.publish.Round(time.Millisecond),
+			res.query.Round(time.Millisecond), formatSize(res.maxSize))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const (
+	eventKindBlossomAuth = 24242
+	blossomAuthLifetime  = 5 * time.Minute
+	httpTimeout          = 5 * time.Minute
+)
+
+// blobDescriptor is a Blossom server's description of a stored blob.
+type blobDescriptor struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Type   string `json:"type"`
+}
+
+// blossomAuth builds the Authorization header for a Blossom request of the
+// given verb ("upload", "get", "delete") on the blob with hash.
+func (c *Client) blossomAuth(verb, hash, description string) (string, error) {
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindBlossomAuth,
+		Content:   description,
+		Tags: nostr.Tags{
+			{"t", verb},
+			{"x", hash},
+			{"expiration", strconv.FormatInt(time.Now().Add(blossomAuthLifetime).Unix(), 10)},
+		},
+	}
+	if err := c.sign(&ev); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// blossomUpload stores data on a Blossom server and checks that the server
+// addressed it by the hash we computed.
+func (c *Client) blossomUpload(server string, data []byte, mimeType, name string) (*blobDescriptor, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	auth, err := c.blossomAuth("upload", hash, "Upload "+name)
+	if err != nil {
+		return nil, err
+	}
+
+	body := &progressReader{r: bytes.NewReader(data), obs: c.Observer, path: name, total: int64(len(data))}
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(server, "/")+"/upload", body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", mimeType)
+	resp, err := (&http.Client{Timeout: httpTimeout}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		reason := resp.Header.Get("X-Reason")
+		if reason == "" {
+			reason = resp.Status
+		}
+		return nil, fmt.Errorf("%w: %s: %s", ErrRelayRejected, server, reason)
+	}
+
+	var desc blobDescriptor
+	if err := json.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("%s: invalid blob descriptor: %w", server, err)
+	}
+	if desc.SHA256 != hash {
+		return nil, fmt.Errorf("%s stored the blob as %s, expected %s", server, desc.SHA256, hash)
+	}
+	return &desc, nil
+}
+
+// fetchBlob downloads url and verifies it against the expected SHA-256,
+// reporting progress to obs under name.
+func fetchBlob(url, hash, name string, obs Observer) ([]byte, error) {
+	resp, err := (&http.Client{Timeout: httpTimeout}).Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", url, ErrNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(&progressReader{r: resp.Body, obs: obs, path: name, total: resp.ContentLength})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != hash {
+		return nil, fmt.Errorf("checksum mismatch for %s: got %s, expected %s", url, got, hash)
+	}
+	return data, nil
+}
+
+// uploadFileBlob stores data on every server, succeeding if at least one
+// of them accepted it.
+func (c *Client) uploadFileBlob(servers []string, data []byte, name string) error {
+	mimeType := orbi.DetectMIME(name, data)
+	var failures []error
+	for _, s := range servers {
+		if _, err := c.blossomUpload(s, data, mimeType, name); err != nil {
+			slog.Warn("Failed to upload blob", "file", name, "server", s, "err", err)
+			failures = append(failures, err)
+		}
+	}
+	if len(failures) == len(servers) {
+		return fmt.Errorf("no Blossom server accepted %s: %w", name, errors.Join(failures...))
+	}
+	return nil
+}
+
+// fetchEventBlob downloads the blob a file event points to from its URL or
+// the first of its Blossom servers that has it, verifying its hash.
+func fetchEventBlob(ev *nostr.Event, obs Observer) ([]byte, error) {
+	tag := ev.Tags.Find("x")
+	if tag == nil {
+		return nil, fmt.Errorf("event %s points to a blob without its hash", ev.ID)
+	}
+	hash := tag[1]
+	var urls []string
+	if u := ev.Tags.Find("url"); u != nil {
+		urls = append(urls, u[1])
+	}
+	for server := range ev.Tags.FindAll("blossom") {
+		urls = append(urls, strings.TrimSuffix(server[1], "/")+"/"+hash)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("event %s does not say where its blob is stored", ev.ID)
+	}
+	var failures []error
+	for _, url := range urls {
+		data, err := fetchBlob(url, hash, eventPath(ev), obs)
+		if err == nil {
+			return data, nil
+		}
+		failures = append(failures, err)
+	}
+	return nil, fmt.Errorf("blob %s of event %s: %w", hash, ev.ID, errors.Join(failures...))
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const (
+	// branchFileName holds the name of the current branch.
+	branchFileName = "branch"
+	// branchesDirName holds the index of every other branch, so switching
+	// back restores the versions it was at.
+	branchesDirName = "branches"
+)
+
+func validBranchName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "-") || strings.ContainsAny(name, "/\\ \t\n") {
+		return fmt.Errorf("invalid branch name %q", name)
+	}
+	return nil
+}
+
+// Branch returns the current branch.
+func (r *Repo) Branch() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.branch()
+}
+
+func (r *Repo) branch() (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(r.dir(), branchFileName))
+	if os.IsNotExist(err) {
+		return orbi.DefaultBranch, nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+func (r *Repo) branchIndexPath(name string) string {
+	return filepath.Join(r.dir(), branchesDirName, name+".json")
+}
+
+// Branches returns every branch, sorted.
+func (r *Repo) Branches() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, err := r.branch()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{current}
+	entries, err := ioutil.ReadDir(filepath.Join(r.dir(), branchesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if name := strings.TrimSuffix(e.Name(), ".json"); name != e.Name() && name != current {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// hasBranch reports whether name is the current branch or a saved one.
+func (r *Repo) hasBranch(name string) (bool, error) {
+	branches, err := r.Branches()
+	if err != nil {
+		return false, err
+	}
+	return contains(branches, name), nil
+}
+
+// CreateBranch starts a branch at the versions currently checked out,
+// without switching to it.
+func (r *Repo) CreateBranch(name string) error {
+	if err := validBranchName(name); err != nil {
+		return err
+	}
+	if ok, err := r.hasBranch(name); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%w: branch %s already exists", ErrConflict, name)
+	}
+	idx, err := r.Index()
+	if err != nil {
+		return err
+	}
+	return r.saveBranchIndex(name, &orbi.Index{Files: idx.Files})
+}
+
+func (r *Repo) saveBranchIndex(name string, idx *orbi.Index) error {
+	content, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(r.dir(), branchesDirName), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.branchIndexPath(name), append(content, '\n'), 0644)
+}
+
+// DeleteBranch forgets a branch other than the current one. Its events stay
+// on the relays.
+func (r *Repo) DeleteBranch(name string) error {
+	current, err := r.Branch()
+	if err != nil {
+		return err
+	}
+	if name == current {
+		return fmt.Errorf("cannot delete the current branch %s", name)
+	}
+	if err := os.Remove(r.branchIndexPath(name)); os.IsNotExist(err) {
+		return fmt.Errorf("branch %s: %w", name, ErrNotFound)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// swapBranch saves the index of the current branch and makes name's index
+// current. It returns the index that was current before.
+func (r *Repo) swapBranch(name string) (*orbi.Index, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, err := r.branch()
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(r.branchIndexPath(name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("branch %s: %w", name, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	next := &orbi.Index{}
+	if err := json.Unmarshal(content, next); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", r.branchIndexPath(name), err)
+	}
+	if next.Files == nil {
+		next.Files = make(map[string]*orbi.IndexEntry)
+	}
+	prev, err := r.index()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.saveBranchIndex(current, &orbi.Index{Files: prev.Files}); err != nil {
+		return nil, err
+	}
+	next.Staged = nil
+	if err := r.writeIndex(next); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(r.branchIndexPath(name)); err != nil {
+		return nil, err
+	}
+	return prev, ioutil.WriteFile(filepath.Join(r.dir(), branchFileName), []byte(name+"\n"), 0644)
+}
+
+// onBranch reports whether ev was committed on the client's branch.
+func (c *Client) onBranch(ev *nostr.Event) bool {
+	branch := c.branch
+	if branch == "" {
+		branch = orbi.DefaultBranch
+	}
+	return orbi.EventBranch(ev) == branch
+}
+
+// switchBranch makes name the current branch and brings the working copy to
+// the versions it was at. It refuses while local changes would be lost.
+func (c *Client) switchBranch(name string) error {
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return err
+	}
+	if len(idx.Staged) > 0 {
+		return fmt.Errorf("%w: files are staged; commit them or unstage them first", ErrConflict)
+	}
+	for _, entry := range idx.Files {
+		if modified, err := c.modifiedLocally(entry); err != nil {
+			return err
+		} else if modified {
+			return fmt.Errorf("%w: %s has local changes; publish or revert them first", ErrConflict, entry.Path)
+		}
+	}
+	prev, err := c.Repo.swapBranch(name)
+	if err != nil {
+		return err
+	}
+	c.branch = name
+
+	next, err := c.Repo.Index()
+	if err != nil {
+		return err
+	}
+	for _, rel := range next.Paths() {
+		entry := next.Files[rel]
+		if old, ok := prev.Files[rel]; ok && old.EventID == entry.EventID {
+			continue
+		}
+		ev, err := c.eventByID(entry.EventID)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if _, err := c.restoreVersion(rel, ev); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+	}
+	for rel := range prev.Files {
+		if _, ok := next.Files[rel]; !ok {
+			if err := os.Remove(c.Repo.Abs(rel)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func cmdBranch(args []string) error {
+	usage := fmt.Errorf("usage: orbi branch [create <name> | delete <name>]")
+	repo := openRepo(".")
+	if len(args) == 0 {
+		current, err := repo.Branch()
+		if err != nil {
+			return err
+		}
+		branches, err := repo.Branches()
+		if err != nil {
+			return err
+		}
+		for _, name := range branches {
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return usage
+	}
+	switch args[0] {
+	case "create":
+		if err := repo.CreateBranch(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Created branch %s\n", args[1])
+	case "delete":
+		if err := repo.DeleteBranch(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted branch %s\n", args[1])
+	default:
+		return usage
+	}
+	return nil
+}
+
+func cmdSwitch(args []string) error {
+	fs := flag.NewFlagSet("switch", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: orbi switch <branch>")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	if client.branch == positional[0] {
+		fmt.Printf("Already on %s\n", positional[0])
+		return nil
+	}
+	if err := client.switchBranch(positional[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Switched to branch %s\n", positional[0])
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const (
+	bridgeDirName = "bridge"
+	// orbiTrailer marks forge commits that mirror an orbi event, so they
+	// aren't imported back.
+	orbiTrailer = "Orbi-Event: "
+)
+
+// bridgeState is what a bridge remembers between runs, in
+// .orbi/bridge/<forge>-<owner>-<name>.json.
+type bridgeState struct {
+	// LastCommit is the newest forge commit already imported.
+	LastCommit string `json:"last_commit,omitempty"`
+	// Mirrored maps each path to the event whose content the forge has.
+	Mirrored map[string]string `json:"mirrored"`
+	// Issues maps imported forge issues and comments to their events.
+	Issues map[string]string `json:"issues,omitempty"`
+}
+
+func (r *Repo) bridgeStatePath(forge, repo string) string {
+	return filepath.Join(r.dir(), bridgeDirName, forge+"-"+strings.Replace(repo, "/", "-", -1)+".json")
+}
+
+func (r *Repo) loadBridgeState(forge, repo string) (*bridgeState, error) {
+	st := &bridgeState{}
+	content, err := ioutil.ReadFile(r.bridgeStatePath(forge, repo))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if err := json.Unmarshal(content, st); err != nil {
+			return nil, fmt.Errorf("invalid bridge state: %w", err)
+		}
+	}
+	if st.Mirrored == nil {
+		st.Mirrored = make(map[string]string)
+	}
+	return st, nil
+}
+
+func (r *Repo) saveBridgeState(forge, repo string, st *bridgeState) error {
+	path := r.bridgeStatePath(forge, repo)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// githubBridge mirrors one orbi repository to and from a GitHub branch.
+type githubBridge struct {
+	c      *Client
+	gh     *githubClient
+	branch string
+	state  *bridgeState
+}
+
+func (b *githubBridge) save() error {
+	return b.c.Repo.saveBridgeState("github", b.gh.repo, b.state)
+}
+
+func contentsPath(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return "/contents/" + strings.Join(parts, "/")
+}
+
+// importCommits publishes the files changed by GitHub commits made since the
+// last run, oldest first. On the first run it only records where to start.
+func (b *githubBridge) importCommits() (int, error) {
+	var commits []githubCommit
+	if err := b.gh.do("GET", "/commits?per_page=100&sha="+url.QueryEscape(b.branch), nil, &commits); err != nil {
+		return 0, err
+	}
+	if len(commits) == 0 {
+		return 0, nil
+	}
+	if b.state.LastCommit == "" {
+		b.state.LastCommit = commits[0].SHA
+		slog.Info("First sync: earlier history is not imported", "from", short(commits[0].SHA))
+		return 0, b.save()
+	}
+	var fresh []githubCommit
+	found := false
+	for _, cm := range commits {
+		if cm.SHA == b.state.LastCommit {
+			found = true
+			break
+		}
+		fresh = append(fresh, cm)
+	}
+	if !found {
+		slog.Warn("Too many commits since the last sync; only the newest are imported", "imported", len(commits))
+	}
+
+	imported := 0
+	for i := len(fresh) - 1; i >= 0; i-- {
+		cm := fresh[i]
+		if !strings.Contains(cm.Commit.Message, orbiTrailer) {
+			n, err := b.importCommit(cm.SHA)
+			if err != nil {
+				return imported, fmt.Errorf("commit %s: %w", short(cm.SHA), err)
+			}
+			imported += n
+		}
+		b.state.LastCommit = cm.SHA
+		if err := b.save(); err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+func (b *githubBridge) importCommit(sha string) (int, error) {
+	var cm githubCommit
+	if err := b.gh.do("GET", "/commits/"+sha, nil, &cm); err != nil {
+		return 0, err
+	}
+	var opts []orbi.EventOption
+	if t, err := time.Parse(time.RFC3339, cm.Commit.Author.Date); err == nil {
+		opts = append(opts, orbi.WithCreatedAt(t))
+	}
+	opts = append(opts, orbi.WithExtraTags(
+		nostr.Tag{"author", cm.Commit.Author.Name, cm.Commit.Author.Email},
+		nostr.Tag{"r", "https://github.com/" + b.gh.repo + "/commit/" + sha},
+	))
+
+	n := 0
+	for _, f := range cm.Files {
+		if f.Status == "removed" {
+			slog.Info("Skipping file removed on GitHub", "file", f.Filename)
+			continue
+		}
+		if err := checkRel(f.Filename); err != nil {
+			slog.Warn("Skipping file", "file", f.Filename, "err", err)
+			continue
+		}
+		var content githubContent
+		if err := b.gh.do("GET", contentsPath(f.Filename)+"?ref="+sha, nil, &content); err != nil {
+			return n, err
+		}
+		if content.Encoding != "base64" {
+			return n, fmt.Errorf("%s: %w: GitHub does not return its content inline", f.Filename, ErrTooLarge)
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.Replace(content.Content, "\n", "", -1))
+		if err != nil {
+			return n, err
+		}
+		if err := b.c.Repo.WriteFile(f.Filename, data, 0644); err != nil {
+			return n, err
+		}
+		ev, err := b.c.PublishFile(b.c.Repo.Abs(f.Filename), strings.TrimSpace(cm.Commit.Message), opts...)
+		if err != nil {
+			return n, err
+		}
+		b.state.Mirrored[f.Filename] = ev.ID
+		n++
+	}
+	return n, nil
+}
+
+// exportFiles commits every tracked file whose published version GitHub
+// doesn't have yet. Encrypted files are never mirrored.
+func (b *githubBridge) exportFiles() (int, error) {
+	idx, err := b.c.Repo.Index()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rel := range idx.Paths() {
+		entry := idx.Files[rel]
+		if entry.EventID == "" || b.state.Mirrored[rel] == entry.EventID {
+			continue
+		}
+		if entry.Encrypted {
+			slog.Info("Skipping encrypted file; these are not mirrored", "file", rel)
+			continue
+		}
+		events := b.c.query(nostr.Filter{IDs: []string{entry.EventID}})
+		if len(events) == 0 {
+			return n, fmt.Errorf("event %s for %s: %w", entry.EventID, rel, ErrNotFound)
+		}
+		data, err := b.c.eventContent(events[0])
+		if err != nil {
+			return n, err
+		}
+
+		var existing githubContent
+		if err := b.gh.do("GET", contentsPath(rel)+"?ref="+url.QueryEscape(b.branch), nil, &existing); err != nil && !errors.Is(err, ErrNotFound) {
+			return n, err
+		}
+		message := eventMessage(events[0])
+		if message == "" {
+			message = "Update " + rel
+		}
+		body := map[string]string{
+			"message": message + "\n\n" + orbiTrailer + entry.EventID,
+			"content": base64.StdEncoding.EncodeToString(data),
+			"branch":  b.branch,
+		}
+		if existing.SHA != "" {
+			body["sha"] = existing.SHA
+		}
+		if err := b.gh.do("PUT", contentsPath(rel), body, nil); err != nil {
+			return n, err
+		}
+		b.state.Mirrored[rel] = entry.EventID
+		if err := b.save(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func cmdBridge(args []string) error {
+	if len(args) == 0 || args[0] != "github" {
+		return fmt.Errorf("usage: orbi bridge github --repo <owner/name> [--branch <name>] [--no-import] [--no-export] [--issues]")
+	}
+	fs := flag.NewFlagSet("bridge github", flag.ContinueOnError)
+	repo := fs.String("repo", "", "GitHub repository as owner/name")
+	branch := fs.String("branch", "main", "branch to mirror")
+	api := fs.String("api", defaultGitHubAPI, "GitHub API base URL, for GitHub Enterprise")
+	noImport := fs.Bool("no-import", false, "don't publish commits made on GitHub")
+	noExport := fs.Bool("no-export", false, "don't commit orbi changes to GitHub")
+	issues := fs.Bool("issues", false, "also import issues and comments as NIP-34 issue events")
+	if _, err := parseArgs(fs, args[1:]); err != nil {
+		return err
+	}
+	gh, err := newGitHubClient(*api, *repo)
+	if err != nil {
+		return err
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	st, err := client.Repo.loadBridgeState("github", *repo)
+	if err != nil {
+		return err
+	}
+	b := &githubBridge{c: client, gh: gh, branch: *branch, state: st}
+
+	if !*noImport {
+		n, err := b.importCommits()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d file(s) from GitHub\n", n)
+	}
+	if !*noExport {
+		n, err := b.exportFiles()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Mirrored %d file(s) to GitHub\n", n)
+	}
+	if *issues {
+		n, err := b.importIssues()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d issue event(s) from GitHub\n", n)
+	}
+	return nil
+}
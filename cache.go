@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// objectsDirName caches every event orbi has published or fetched, one JSON
+// file per event under a directory named after the first two hex digits of
+// its id, so known events can be read without asking the relays.
+const objectsDirName = "objects"
+
+func (r *Repo) objectPath(id string) string {
+	return filepath.Join(r.dir(), objectsDirName, id[:2], id[2:]+".json")
+}
+
+// cacheEvent stores ev in the object cache if it is authentic. Nothing is
+// cached outside a repository.
+func (r *Repo) cacheEvent(ev *nostr.Event) {
+	if !nostr.IsValid32ByteHex(ev.ID) {
+		return
+	}
+	if _, err := os.Stat(r.dir()); err != nil {
+		return
+	}
+	path := r.objectPath(ev.ID)
+	if _, err := os.Stat(path); err == nil {
+		return
+	}
+	if !ev.CheckID() || !validSignature(ev) {
+		return
+	}
+	content, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		slog.Debug("Failed to cache event", "id", ev.ID, "err", err)
+		return
+	}
+	// Write through a temporary file so concurrent readers never see a
+	// partial event.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		slog.Debug("Failed to cache event", "id", ev.ID, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		slog.Debug("Failed to cache event", "id", ev.ID, "err", err)
+	}
+}
+
+// cachedEvent returns the event with the given id from the object cache.
+func (r *Repo) cachedEvent(id string) (*nostr.Event, bool) {
+	if !nostr.IsValid32ByteHex(id) {
+		return nil, false
+	}
+	content, err := ioutil.ReadFile(r.objectPath(id))
+	if err != nil {
+		return nil, false
+	}
+	ev := &nostr.Event{}
+	if err := json.Unmarshal(content, ev); err != nil || ev.ID != id {
+		return nil, false
+	}
+	return ev, true
+}
+
+// cachedEvents returns every cached event matching filter.
+func (r *Repo) cachedEvents(filter nostr.Filter) []*nostr.Event {
+	var result []*nostr.Event
+	root := filepath.Join(r.dir(), objectsDirName)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		id := filepath.Base(filepath.Dir(path)) + strings.TrimSuffix(info.Name(), ".json")
+		if ev, ok := r.cachedEvent(id); ok && filter.Matches(ev) {
+			result = append(result, ev)
+		}
+		return nil
+	})
+	return result
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const eventKindArticle = 30023
+
+var conventionalCommitRe = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+// conventionalSections orders the changelog sections for conventional commit
+// types; anything else lands in "Other Changes".
+var conventionalSections = []struct{ kind, title string }{
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance"},
+	{"refactor", "Refactoring"},
+	{"docs", "Documentation"},
+}
+
+type changelogEntry struct {
+	summary  string
+	kind     string
+	scope    string
+	breaking bool
+	paths    []string
+}
+
+// changelogEntries turns file events into entries, merging events that share
+// a commit message into a single entry listing every path it touched.
+func changelogEntries(events []*nostr.Event, conventional bool) []*changelogEntry {
+	var entries []*changelogEntry
+	byMessage := make(map[string]*changelogEntry)
+	for _, ev := range events {
+		path := eventPath(ev)
+		message := eventMessage(ev)
+		if message == "" {
+			message = "Update " + path
+		}
+		if e, ok := byMessage[message]; ok {
+			e.paths = appendUnique(e.paths, path)
+			continue
+		}
+		summary := strings.SplitN(message, "\n", 2)[0]
+		e := &changelogEntry{summary: summary, paths: []string{path}}
+		if conventional {
+			if m := conventionalCommitRe.FindStringSubmatch(summary); m != nil {
+				e.kind, e.scope, e.breaking, e.summary = strings.ToLower(m[1]), m[2], m[3] == "!", m[4]
+			}
+			if strings.Contains(message, "BREAKING CHANGE:") {
+				e.breaking = true
+			}
+		}
+		byMessage[message] = e
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+func (e *changelogEntry) line() string {
+	var b strings.Builder
+	b.WriteString("- ")
+	if e.scope != "" {
+		fmt.Fprintf(&b, "**%s:** ", e.scope)
+	}
+	b.WriteString(e.summary)
+	fmt.Fprintf(&b, " (%s)", strings.Join(e.paths, ", "))
+	return b.String()
+}
+
+// renderChangelog formats entries as Markdown under title.
+func renderChangelog(title string, entries []*changelogEntry, conventional bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", title)
+	if len(entries) == 0 {
+		b.WriteString("\nNo changes.\n")
+		return b.String()
+	}
+	section := func(heading string, match func(*changelogEntry) bool) {
+		var lines []string
+		for _, e := range entries {
+			if match(e) {
+				lines = append(lines, e.line())
+			}
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(&b, "\n## %s\n\n%s\n", heading, strings.Join(lines, "\n"))
+		}
+	}
+	if !conventional {
+		section("Changes", func(*changelogEntry) bool { return true })
+		return b.String()
+	}
+	section("Breaking Changes", func(e *changelogEntry) bool { return e.breaking })
+	known := make(map[string]bool)
+	for _, s := range conventionalSections {
+		kind := s.kind
+		known[kind] = true
+		section(s.title, func(e *changelogEntry) bool { return e.kind == kind })
+	}
+	section("Other Changes", func(e *changelogEntry) bool { return !known[e.kind] })
+	return b.String()
+}
+
+// resolveSince turns a --since argument into a timestamp. It accepts an event
+// ID, whose own changes are excluded, or anything parseTime understands.
+func (c *Client) resolveSince(since string) (nostr.Timestamp, error) {
+	if since == "" {
+		return 0, nil
+	}
+	if nostr.IsValid32ByteHex(since) {
+		events := c.query(nostr.Filter{IDs: []string{since}})
+		if len(events) == 0 {
+			return 0, fmt.Errorf("event %s: %w", since, ErrNotFound)
+		}
+		return events[0].CreatedAt + 1, nil
+	}
+	t, err := parseTime(since)
+	if err != nil {
+		return 0, err
+	}
+	return nostr.Timestamp(t.Unix()), nil
+}
+
+// publishArticle publishes markdown as a NIP-23 long-form article.
+func (c *Client) publishArticle(slug, title, markdown string) (*nostr.Event, error) {
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindArticle,
+		Content:   markdown,
+		Tags: nostr.Tags{
+			{"ver", eventFormatVersion},
+			{"d", slug},
+			{"title", title},
+			{"published_at", fmt.Sprint(nostr.Now())},
+			{"t", "changelog"},
+		},
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func cmdChangelog(args []string) error {
+	fs := flag.NewFlagSet("changelog", flag.ContinueOnError)
+	since := fs.String("since", "", "only include changes after this event ID or time")
+	title := fs.String("title", "", "changelog heading")
+	conventional := fs.Bool("conventional", false, "group conventional-commit messages by type")
+	publish := fs.Bool("publish", false, "publish the changelog as a NIP-23 article")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	sinceTS, err := client.resolveSince(*since)
+	if err != nil {
+		return err
+	}
+	if *title == "" {
+		*title = "Changelog " + time.Now().Format("2006-01-02")
+	}
+
+	markdown := renderChangelog(*title, changelogEntries(client.History("", sinceTS), *conventional), *conventional)
+	fmt.Print(markdown)
+	if !*publish {
+		return nil
+	}
+	slug := "changelog-" + strings.ToLower(strings.Join(strings.Fields(*title), "-"))
+	ev, err := client.publishArticle(slug, *title, markdown)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nPublished changelog\nEvent ID: %s\n", ev.ID)
+	return nil
+}
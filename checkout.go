@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// resolveVersion finds the version named by spec: an event id, or a file
+// with an optional relative index ("notes.txt@-2" is two versions before the
+// newest; a bare path is the newest).
+func (c *Client) resolveVersion(spec string, authors []string) (string, *nostr.Event, error) {
+	if nostr.IsValid32ByteHex(spec) {
+		events := c.query(nostr.Filter{IDs: []string{spec}})
+		if len(events) == 0 {
+			return "", nil, fmt.Errorf("event %s: %w", spec, ErrNotFound)
+		}
+		ev := events[0]
+		if !c.signsFor(ev.PubKey, authors) {
+			return "", nil, fmt.Errorf("%w: event %s was not published by this repository", ErrUntrusted, spec)
+		}
+		rel := c.filePath(ev)
+		if err := checkRel(rel); err != nil {
+			return "", nil, fmt.Errorf("event %s: %w", spec, err)
+		}
+		return rel, ev, nil
+	}
+
+	file, n := spec, 0
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		var err error
+		if n, err = strconv.Atoi(spec[i+1:]); err != nil || n > 0 {
+			return "", nil, fmt.Errorf("invalid version %q: use <file>@-<n>", spec)
+		}
+		file = spec[:i]
+	}
+	rel, err := c.Repo.Rel(file)
+	if err != nil {
+		return "", nil, err
+	}
+	versions := c.versions(rel, authors)
+	sort.Slice(versions, func(i, j int) bool { return before(versions[j], versions[i]) })
+	if -n >= len(versions) {
+		return "", nil, fmt.Errorf("%s: %d version(s) published, %s: %w", rel, len(versions), spec, ErrNotFound)
+	}
+	return rel, versions[-n], nil
+}
+
+func cmdCheckout(args []string) error {
+	fs := flag.NewFlagSet("checkout", flag.ContinueOnError)
+	stdout := fs.Bool("stdout", false, "print the version instead of writing it")
+	force := fs.Bool("force", false, "overwrite local changes")
+	tagFlag := fs.String("tag", "", "restore every file to the versions pinned by this tag")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *tagFlag != "" && (len(rest) != 0 || *stdout) || *tagFlag == "" && len(rest) != 1 {
+		return fmt.Errorf("usage: orbi checkout [--stdout] [--force] <event-id|file[@-n]> | --tag <name> [--force]")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	if *tagFlag != "" {
+		authors := client.pullAuthors(cfg)
+		tag, err := client.findTag(*tagFlag, authors)
+		if err != nil {
+			return err
+		}
+		n, err := client.checkoutTag(tag, authors, *force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %d file(s) to tag %s\n", n, *tagFlag)
+		return nil
+	}
+	rel, ev, err := client.resolveVersion(rest[0], client.pullAuthors(cfg))
+	if err != nil {
+		return err
+	}
+
+	if *stdout {
+		if ok, err := ev.CheckSignature(); err != nil || !ok {
+			return fmt.Errorf("%w: event %s has an invalid signature", ErrUntrusted, ev.ID)
+		}
+		content, err := client.eventContent(ev)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+
+	idx, err := client.Repo.Index()
+	if err != nil {
+		return err
+	}
+	if entry, ok := idx.Files[rel]; ok && !*force {
+		if modified, err := client.modifiedLocally(entry); err != nil {
+			return err
+		} else if modified {
+			return fmt.Errorf("%w: %s has local changes; use --force to discard them", ErrConflict, rel)
+		}
+	}
+	previous, err := client.restoreVersion(rel, ev)
+	if err != nil {
+		return err
+	}
+	if err := client.Repo.LogHead("checkout", rel, previous, ev.ID, client.fileMessage(ev)); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s to %s\n", rel, ev.ID)
+	return nil
+}
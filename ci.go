@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventKindCIStatus reports a CI result against a commit event.
+const eventKindCIStatus = 4446
+
+// CI states.
+const (
+	ciPending = "pending"
+	ciSuccess = "success"
+	ciFailure = "failure"
+)
+
+// ciStatus is the parsed form of a CI status event.
+type ciStatus struct {
+	Commit      string
+	State       string
+	Context     string
+	URL         string
+	Description string
+	Author      string
+	At          nostr.Timestamp
+}
+
+func parseCIStatus(ev *nostr.Event) ciStatus {
+	s := ciStatus{Description: ev.Content, Author: ev.PubKey, At: ev.CreatedAt, Context: "ci"}
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "e":
+			s.Commit = tag[1]
+		case "status":
+			s.State = tag[1]
+		case "context":
+			s.Context = tag[1]
+		case "url":
+			s.URL = tag[1]
+		}
+	}
+	return s
+}
+
+// publishCIStatus records a CI result for the commit event commitID.
+func (c *Client) publishCIStatus(commitID, state, context, url, description string) (*nostr.Event, error) {
+	switch state {
+	case ciPending, ciSuccess, ciFailure:
+	default:
+		return nil, fmt.Errorf("invalid state %q: must be pending, success or failure", state)
+	}
+	if !nostr.IsValid32ByteHex(commitID) {
+		return nil, fmt.Errorf("invalid event id %q", commitID)
+	}
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      c.kinds("").CIStatus,
+		Content:   description,
+		Tags: nostr.Tags{
+			{"ver", eventFormatVersion},
+			{"e", commitID, "", "commit"},
+			{"status", state},
+			{"context", context},
+		},
+	}
+	if url != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"url", url})
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// ciStatuses returns the latest status per context for each commit in ids,
+// only counting statuses signed by one of trusted or their subkeys.
+func (c *Client) ciStatuses(ids []string, trusted []string) map[string][]ciStatus {
+	result := make(map[string][]ciStatus)
+	if len(ids) == 0 || len(trusted) == 0 {
+		return result
+	}
+	var kinds []int
+	for _, pk := range trusted {
+		if k := c.kinds(pk).CIStatus; !containsInt(kinds, k) {
+			kinds = append(kinds, k)
+		}
+	}
+	events := c.query(nostr.Filter{
+		Kinds:   kinds,
+		Authors: c.withSubkeys(trusted),
+		Tags:    nostr.TagMap{"e": ids},
+	})
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt > events[j].CreatedAt })
+	seen := make(map[string]bool)
+	for _, ev := range events {
+		// Relays can return anything; anyone may publish a status.
+		if !validSignature(ev) || !c.signsFor(ev.PubKey, trusted) {
+			continue
+		}
+		s := parseCIStatus(ev)
+		key := s.Commit + "\x00" + s.Context
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result[s.Commit] = append(result[s.Commit], s)
+	}
+	return result
+}
+
+// ciTrusted returns the keys whose statuses count: the configured CI
+// signers and the repository's authors, who are the client's own keys, the
+// origin and the collaborators.
+func (c *Client) ciTrusted(cfg *Config) []string {
+	var trusted []string
+	for _, pk := range c.pullAuthors(cfg) {
+		if pk != "" {
+			trusted = append(trusted, pk)
+		}
+	}
+	for _, s := range cfg.CISigners {
+		if pk, err := parsePubkey(s); err == nil && !contains(trusted, pk) {
+			trusted = append(trusted, pk)
+		}
+	}
+	return trusted
+}
+
+// combinedState reduces several contexts to one: any failure fails, any
+// pending is pending, otherwise success.
+func combinedState(statuses []ciStatus) string {
+	if len(statuses) == 0 {
+		return ""
+	}
+	state := ciSuccess
+	for _, s := range statuses {
+		if s.State == ciFailure {
+			return ciFailure
+		}
+		if s.State == ciPending {
+			state = ciPending
+		}
+	}
+	return state
+}
+
+func cmdCIStatus(args []string) error {
+	fs := flag.NewFlagSet("ci-status", flag.ContinueOnError)
+	url := fs.String("url", "", "link to the CI logs")
+	context := fs.String("context", "ci", "name of the check, e.g. build or test")
+	getMessage := messageFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: orbi ci-status <event-id> pending|success|failure [--url <logs>] [--context <name>]")
+	}
+	description, err := getMessage()
+	if err != nil {
+		return err
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	ev, err := client.publishCIStatus(positional[0], positional[1], *context, *url, description)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Recorded %s %s for %s\nEvent ID: %s\n", *context, positional[1], positional[0], ev.ID)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestCIStatusesTrust(t *testing.T) {
+	c, transport := newTestClient(t)
+	collaborator, _ := newTestClient(t)
+	runner, _ := newTestClient(t)
+	stranger, _ := newTestClient(t)
+	for _, o := range []*Client{collaborator, runner, stranger} {
+		o.Transport = transport
+	}
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	npub, _ := nip19.EncodePublicKey(collaborator.pk)
+	cfg.Collaborators = []string{npub}
+	npub, _ = nip19.EncodePublicKey(runner.pk)
+	cfg.CISigners = []string{npub}
+
+	commit := testEvent(t, c, "a.txt", "one\n", 1700000000)
+	foreign := testEvent(t, stranger, "a.txt", "spam\n", 1700000000)
+	for _, ev := range []*nostr.Event{commit, foreign} {
+		if err := transport.Publish(context.Background(), testRelayURL, *ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ev := range []struct {
+		by      *Client
+		state   string
+		context string
+	}{
+		{runner, ciSuccess, "build"},
+		{collaborator, ciSuccess, "lint"},
+		{stranger, ciFailure, "spam"},
+	} {
+		for _, id := range []string{commit.ID, foreign.ID} {
+			if _, err := ev.by.publishCIStatus(id, ev.state, ev.context, "", ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	statuses := c.ciStatuses([]string{commit.ID}, c.ciTrusted(cfg))[commit.ID]
+	var contexts []string
+	for _, s := range statuses {
+		contexts = append(contexts, s.Context)
+	}
+	sort.Strings(contexts)
+	if strings.Join(contexts, ",") != "build,lint" {
+		t.Errorf("counted statuses for %v, want build and lint", contexts)
+	}
+
+	for _, ev := range []struct {
+		id     string
+		status int
+		state  string
+	}{
+		{commit.ID, http.StatusOK, ciSuccess},
+		{foreign.ID, http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		c.handleBadge(cfg)(rec, httptest.NewRequest("GET", "/badge/"+ev.id+".svg", nil))
+		if rec.Code != ev.status {
+			t.Errorf("badge for %s: status %d, want %d", short(ev.id), rec.Code, ev.status)
+		}
+		if ev.state != "" && !strings.Contains(rec.Body.String(), ev.state) {
+			t.Errorf("badge for %s doesn't say %s: %s", short(ev.id), ev.state, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// Client ties an identity, a relay set and a local repository together. All
+// methods are safe to call from multiple goroutines; the repository does its
+// own locking and the relay set is guarded by mu.
+type Client struct {
+	Transport orbi.Transport
+	Observer  Observer
+	Repo      *Repo
+
+	// Confirm, when set, is shown every event before it is published and
+	// must approve it.
+	Confirm func(PublishSummary) (bool, error)
+
+	// Force publishes even when the relays have a newer version of a file
+	// than the one recorded locally.
+	Force bool
+
+	// Quiet keeps PublishFile from printing its progress to stdout.
+	Quiet bool
+
+	// DryRun builds and signs events but sends nothing: PublishFile prints
+	// what it would publish instead, and blobs are not uploaded.
+	DryRun bool
+
+	// MaxEventSize caps the serialized size of published events. Zero means
+	// defaultMaxEventSize; relays advertising a lower limit take precedence.
+	MaxEventSize int
+
+	// Timeout bounds each operation on a single relay. Zero means
+	// defaultRelayTimeout.
+	Timeout time.Duration
+
+	// MinRelays is how many relays must accept an event for publishing to
+	// succeed. Zero means one.
+	MinRelays int
+
+	// Retry controls retries after connection failures and timeouts. Nil
+	// uses the defaults.
+	Retry *RetryConfig
+
+	// PoW is the NIP-13 difficulty every signed event is mined to. RelayPoW
+	// holds the difficulty individual relays require, keyed by normalized
+	// URL; the highest that applies wins.
+	PoW      int
+	RelayPoW map[string]int
+
+	// branch is the branch events are committed on and read from. Empty
+	// means orbi.DefaultBranch.
+	branch string
+
+	sk, pk string
+	// signer, when set, signs instead of sk, which is then empty.
+	signer Signer
+	// identity is the main key that authorized pk as a signing subkey, or
+	// empty when pk is the identity itself.
+	identity string
+	// previousKeys are keys rotated out in favour of pk whose history is
+	// still the client's own.
+	previousKeys []string
+
+	mu          sync.RWMutex
+	relays      []string
+	readRelays  []string
+	groups      []RelayGroup
+	kindCache   map[string]KindMap
+	subkeyCache map[string][]string
+	limitCache  map[string]int
+
+	// useRelayList replaces the relays with the identity's NIP-65 write
+	// relays, and outboxAuthors have their write relays queried too. Both
+	// are resolved once, on first use.
+	useRelayList  bool
+	outboxAuthors []string
+	discoverOnce  sync.Once
+}
+
+func newClient(repo *Repo, sk, pk string) *Client {
+	return &Client{
+		Transport: orbi.WebsocketTransport{},
+		Observer:  newProgressObserver(),
+		Repo:      repo,
+		sk:        sk,
+		pk:        pk,
+		relays:    defaultRelays,
+	}
+}
+
+// Relays returns a copy of the relays the client talks to.
+func (c *Client) Relays() []string {
+	c.discoverRelays()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.relays...)
+}
+
+// SetRelays replaces the relays the client talks to with a single group.
+func (c *Client) SetRelays(relays []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.relays = append([]string(nil), relays...)
+	c.groups = nil
+	c.useRelayList = false
+}
+
+// PublishFile signs the contents of filePath as a file event, sends it to
+// every relay and records the file as tracked. The event's parent is the
+// locally recorded version, and publishing fails with ErrConflict if the
+// relays have moved past it unless Force is set. opts are applied after the
+// path, message and parent.
+func (c *Client) PublishFile(filePath, message string, opts ...orbi.EventOption) (*nostr.Event, error) {
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	fc, err := c.commitFile(cfg, filePath, message, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if c.DryRun {
+		c.describeEvent(fc.ev, fc.raw)
+		return fc.ev, nil
+	}
+
+	var claim *headClaim
+	if cfg.MergeQueue != nil {
+		if claim, err = c.claimHead(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if !c.Quiet {
+		fmt.Println("Publishing file to relays...")
+	}
+	if err := c.publish(fc.ev); errors.Is(err, ErrOffline) {
+		if err := c.Repo.Defer(fc.ev); err != nil {
+			return nil, err
+		}
+		slog.Warn("No relay is reachable; the event was queued and will be published by the next command or orbi flush", "id", fc.ev.ID)
+		c.recordCommit(fc, message)
+		return fc.ev, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if claim != nil {
+		if err := c.confirmHead(cfg, claim, fc.ev.ID); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+	c.recordCommit(fc, message)
+
+	if !c.Quiet {
+		fmt.Printf("\nSuccessfully published file %s\nEvent ID: %s\n", fc.rel, fc.ev.ID)
+	}
+	return fc.ev, nil
+}
+
+// fileCommit is a signed version of a file that has not been published yet.
+type fileCommit struct {
+	ev       *nostr.Event
+	filePath string
+	rel      string
+	raw      []byte
+	parent   string
+}
+
+// commitFile builds and signs the next version of filePath after asking
+// c.Confirm, if set, but publishes nothing. Blobs are uploaded here, since
+// the event has to say where they are.
+func (c *Client) commitFile(cfg *Config, filePath, message string, opts ...orbi.EventOption) (*fileCommit, error) {
+	rel, err := c.Repo.Rel(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// A dry run changes nothing, so it doesn't run hooks that might.
+	if !c.DryRun {
+		if err := c.runHook(hookPrePublish, rel, "", message); err != nil {
+			return nil, err
+		}
+	}
+	content, err := readFileObserved(c.Observer, filePath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", filePath, ErrNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	raw := content
+	sum := sha256.Sum256(raw)
+	if err := c.checkOwnership(cfg, rel, hex.EncodeToString(sum[:]), c.Identity()); err != nil {
+		return nil, err
+	}
+	if wouldNormalize(content, cfg.EOL) {
+		slog.Info("Normalizing line endings to LF", "file", rel)
+		content = normalizeEOL(content, cfg.EOL)
+	}
+
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return nil, err
+	}
+	var parent string
+	var private []orbi.EventOption
+	if entry, ok := idx.Files[rel]; ok {
+		if entry.Conflict && !c.Force && bytes.Contains(content, []byte(conflictMarker)) {
+			return nil, fmt.Errorf("%w: %s still has conflict markers; resolve them or use --force", ErrConflict, rel)
+		}
+		parent = entry.EventID
+		if entry.Encrypted {
+			if private, err = c.privateOptions(rel, entry); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The relays can't know about versions still in the outbox.
+	queued, err := c.Repo.outboxIDs()
+	if err != nil {
+		return nil, err
+	}
+	if !c.Force && !queued[parent] {
+		if err := c.checkHead(cfg, rel, parent); err != nil {
+			return nil, err
+		}
+	}
+
+	base := []orbi.EventOption{
+		orbi.WithPath(rel),
+		orbi.WithMessage(message),
+		orbi.WithFileInfo(info.Mode(), info.ModTime()),
+	}
+	if c.branch != "" {
+		base = append(base, orbi.WithBranch(c.branch))
+	}
+	if parent != "" {
+		base = append(base, orbi.WithParent(parent))
+	}
+	if storage := cfg.storage(); storage != orbi.StorageInline && len(content) > cfg.blobThreshold() {
+		sum := sha256.Sum256(content)
+		base = append(base, orbi.WithBlob(storage, hex.EncodeToString(sum[:]), cfg.BlossomServers))
+	} else if cfg.Delta != nil && parent != "" && !cfg.addressable(rel) {
+		if opt, err := c.deltaOption(cfg.Delta, rel, parent, content); err != nil {
+			slog.Warn("Publishing in full instead of as a delta", "file", rel, "err", err)
+		} else if opt != nil {
+			base = append(base, opt)
+		}
+	}
+	if cfg.Compression != "" {
+		base = append(base, orbi.WithCompression(cfg.Compression))
+	}
+	// --private given this time replaces the stored recipients.
+	base = append(base, private...)
+	opts = append(base, opts...)
+	kind := c.kinds("").File
+	if cfg.addressable(rel) {
+		kind = c.kinds("").Latest
+	}
+	build := func(extra ...orbi.EventOption) (nostr.Event, error) {
+		return orbi.BuildEvent(c.pk, kind, content, append(opts, extra...)...)
+	}
+	ev, err := build()
+	if err != nil {
+		return nil, err
+	}
+	// Only text that fails to decode is worth a warning; content with NUL
+	// bytes is binary on purpose.
+	if ev.Tags.Find("charset") == nil && ev.Tags.Find("storage") == nil && !utf8.Valid(content) && !bytes.Contains(content, []byte{0}) {
+		slog.Warn("Not valid UTF-8; publishing as binary (use --charset to convert it)", "file", rel)
+	}
+	if err := c.checkSize(&ev, rel); err != nil {
+		return nil, err
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+
+	if c.DryRun {
+		return &fileCommit{ev: &ev, filePath: filePath, rel: rel, raw: raw, parent: parent}, nil
+	}
+	if c.Confirm != nil {
+		ok, err := c.Confirm(newPublishSummary(rel, raw, &ev, c.Relays()))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("publish of %s cancelled", rel)
+		}
+	}
+
+	if ev.Tags.Find("storage") != nil {
+		if ev, err = c.storeBlob(cfg, rel, content, ev, build); err != nil {
+			return nil, err
+		}
+	}
+	return &fileCommit{ev: &ev, filePath: filePath, rel: rel, raw: raw, parent: parent}, nil
+}
+
+// recordCommit moves the file's head in the index and reflog to a version
+// that was published or queued.
+func (c *Client) recordCommit(fc *fileCommit, message string) {
+	if err := c.recordPublish(fc.filePath, fc.rel, fc.raw, fc.ev); err != nil {
+		slog.Warn("Failed to track file locally", "err", err)
+	}
+	op := "commit"
+	if len(eventParents(fc.ev)) > 1 {
+		op = "merge"
+	}
+	if err := c.Repo.LogHead(op, fc.rel, fc.parent, fc.ev.ID, message); err != nil {
+		slog.Warn("Failed to update the reflog", "err", err)
+	}
+	if err := c.Repo.register(); err != nil {
+		slog.Warn("Failed to add repository to the workspace registry", "err", err)
+	}
+	c.runPostHook(hookPostPublish, fc.rel, fc.ev)
+}
+
+// recordPublish updates the index entry for rel after ev was published from
+// the file contents raw.
+func (c *Client) recordPublish(filePath, rel string, raw []byte, ev *nostr.Event) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(raw)
+	return c.Repo.UpdateIndex(func(idx *orbi.Index) error {
+		idx.Files[rel] = &orbi.IndexEntry{
+			Path:      rel,
+			EventID:   ev.ID,
+			Hash:      hex.EncodeToString(sum[:]),
+			Size:      info.Size(),
+			ModTime:   info.ModTime().Unix(),
+			Encrypted: ev.Tags.Find("encrypted") != nil,
+			PathTag:   indexPathTag(rel, ev),
+		}
+		return nil
+	})
+}
+
+// RelayResult is the outcome of publishing an event to one relay.
+type RelayResult struct {
+	URL     string
+	Err     error
+	Elapsed time.Duration
+}
+
+// indexPathTag returns what orbi.IndexEntry.PathTag records for ev published as
+// rel.
+func indexPathTag(rel string, ev *nostr.Event) string {
+	if tag := eventPath(ev); tag != rel {
+		return tag
+	}
+	return ""
+}
+
+// publish sends a signed event to every relay of the first relay group at
+// once, falling back to later groups while too few relays have accepted it.
+// It fails only if no relay accepted it.
+func (c *Client) publish(ev *nostr.Event) error {
+	c.Observer.OnPublishStart(ev, c.Relays())
+	var failures []error
+	var results []RelayResult
+	accepted, required := 0, c.minRelays()
+	for i, g := range c.relayGroups() {
+		if i > 0 {
+			slog.Warn("Too few relays accepted the event; falling back", "accepted", accepted, "group", g.label(i))
+		}
+		for _, r := range c.publishAll(c.byHealth(g.Relays), ev) {
+			if r.Err != nil {
+				failures = append(failures, &RelayError{URL: r.URL, Err: r.Err})
+			} else {
+				accepted++
+			}
+			results = append(results, r)
+		}
+		if accepted >= g.min() && accepted >= required {
+			break
+		}
+	}
+	c.Observer.OnPublishDone(ev, results)
+	if accepted > 0 {
+		c.Repo.cacheEvent(ev)
+	}
+	if accepted == 0 && len(results) > 0 && unreachable(results) {
+		return fmt.Errorf("%w:\n%w", ErrOffline, errors.Join(failures...))
+	}
+	if accepted == 0 {
+		return fmt.Errorf("%w: no relay accepted the event:\n%w", ErrRelayRejected, errors.Join(failures...))
+	}
+	if accepted < required {
+		return fmt.Errorf("%w: only %d of the %d required relays accepted the event:\n%w",
+			ErrRelayRejected, accepted, required, errors.Join(failures...))
+	}
+	return nil
+}
+
+// unreachable reports whether every relay failed without answering, as
+// opposed to refusing the event.
+func unreachable(results []RelayResult) bool {
+	for _, r := range results {
+		var rej *orbi.Rejection
+		if r.Err == nil || errors.As(r.Err, &rej) {
+			return false
+		}
+	}
+	return true
+}
+
+// minRelays returns how many relays must accept an event.
+func (c *Client) minRelays() int {
+	if c.MinRelays > 0 {
+		return c.MinRelays
+	}
+	return 1
+}
+
+// publishAll sends ev to every one of relays concurrently and returns their
+// results in the same order.
+func (c *Client) publishAll(relays []string, ev *nostr.Event) []RelayResult {
+	results := make([]RelayResult, len(relays))
+	var wg sync.WaitGroup
+	for i, r := range relays {
+		wg.Add(1)
+		go func(i int, r string) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.publishTo(r, ev)
+			c.Observer.OnRelayResult(ev, r, err)
+			results[i] = RelayResult{URL: r, Err: err, Elapsed: time.Since(start)}
+			c.Repo.recordRelayResult(r, err, results[i].Elapsed)
+		}(i, r)
+	}
+	wg.Wait()
+	return results
+}
+
+// publishTo sends ev to one relay, retrying with backoff after connection
+// failures and timeouts, and waiting longer when the relay says it is rate
+// limited. A relay that already has the event counts as success.
+func (c *Client) publishTo(url string, ev *nostr.Event) error {
+	for attempt := 0; ; attempt++ {
+		err := c.withRetry(url, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+			defer cancel()
+			return c.Transport.Publish(ctx, url, *ev)
+		})
+		switch rejectionPrefix(err) {
+		case "duplicate":
+			return nil
+		case "rate-limited":
+			if attempt < len(rateLimitBackoff) {
+				wait := rateLimitBackoff[attempt]
+				slog.Info("Rate limited; retrying", "relay", url, "wait", wait)
+				time.Sleep(wait)
+				continue
+			}
+		}
+		return err
+	}
+}
+
+// query fetches filter from every read relay, or every relay of the first
+// relay group, and merges the results, dropping duplicates. Relays that fail
+// are logged and skipped; later groups are queried while too few relays have
+// answered. Events asked for by id are read from the object cache when
+// known, and the cache answers instead when no relay does.
+func (c *Client) query(filter nostr.Filter) []*nostr.Event {
+	var cached []*nostr.Event
+	if len(filter.IDs) > 0 {
+		var missing []string
+		for _, id := range filter.IDs {
+			if ev, ok := c.Repo.cachedEvent(id); ok && filter.Matches(ev) {
+				cached = append(cached, ev)
+			} else {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) == 0 {
+			return cached
+		}
+		filter.IDs = missing
+	}
+	result, answered := c.queryRelays(filter)
+	if answered == 0 {
+		slog.Debug("No relay answered; reading the object cache")
+		result = c.Repo.cachedEvents(filter)
+	}
+	return append(cached, result...)
+}
+
+// queryRelays is query without the object cache. It also returns how many
+// relays answered.
+func (c *Client) queryRelays(filter nostr.Filter) ([]*nostr.Event, int) {
+	seen := make(map[string]bool)
+	var result []*nostr.Event
+	answered := 0
+	for i, g := range c.readGroups() {
+		if i > 0 {
+			slog.Warn("Too few relays answered; falling back", "answered", answered, "group", g.label(i))
+		}
+		for _, r := range c.byHealth(g.Relays) {
+			if answered >= g.min() && c.unhealthyRelay(r) {
+				slog.Debug("Skipping relay that has been failing", "relay", r)
+				continue
+			}
+			var events []*nostr.Event
+			start := time.Now()
+			err := c.withRetry(r, func() (err error) {
+				ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+				defer cancel()
+				events, err = c.Transport.Fetch(ctx, r, filter)
+				return err
+			})
+			c.Repo.recordRelayResult(r, err, time.Since(start))
+			if err != nil {
+				slog.Warn("Failed to query relay", "relay", r, "err", err)
+				continue
+			}
+			answered++
+			slog.Debug("Queried relay", "relay", r, "events", len(events))
+			for _, ev := range events {
+				c.Observer.OnFetch(r, ev)
+				if !seen[ev.ID] {
+					seen[ev.ID] = true
+					c.Repo.cacheEvent(ev)
+					result = append(result, ev)
+				}
+			}
+		}
+		if answered >= g.min() {
+			break
+		}
+	}
+	return result, answered
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// testRelayURL is the only relay of clients made by newTestClient.
+const testRelayURL = "wss://relay.test"
+
+// newTestClient returns a client for a fresh repository that talks to an
+// in-memory relay.
+func newTestClient(t *testing.T) (*Client, *orbi.MemoryTransport) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, localOrbiDirName), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := orbi.NewMemoryTransport()
+	c := newClient(openRepo(dir), sk, pk)
+	c.Transport = transport
+	c.Observer = nopObserver{}
+	c.Quiet = true
+	c.SetRelays([]string{testRelayURL})
+	return c, transport
+}
+
+// testEvent builds and signs a version of path with c's key.
+func testEvent(t *testing.T, c *Client, path, content string, at int64, opts ...orbi.EventOption) *nostr.Event {
+	t.Helper()
+	opts = append([]orbi.EventOption{orbi.WithPath(path), orbi.WithCreatedAt(time.Unix(at, 0))}, opts...)
+	ev, err := orbi.BuildEvent(c.pk, defaultKinds.File, []byte(content), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.sign(&ev); err != nil {
+		t.Fatal(err)
+	}
+	return &ev
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// cloneCheckpoint names the resumable fetch used by clone.
+const cloneCheckpoint = "clone"
+
+// clone writes the newest version of every file published by author or the
+// collaborators author declares into the repository and tracks them. Events
+// failing policy, when one is given, are skipped. It returns how many files
+// were written and skipped.
+func (c *Client) clone(author string, collaborators []string, policy *Policy, resume bool) (int, int, error) {
+	authors := append([]string{author}, collaborators...)
+	events, err := c.fetchCheckpointed(cloneCheckpoint, nostr.Filter{
+		Kinds:   c.fileKinds(authors),
+		Authors: c.withSubkeys(authors),
+	}, resume)
+	if err != nil {
+		return 0, 0, err
+	}
+	var valid []*nostr.Event
+	for _, ev := range events {
+		// Relays can return anything; only keep what the authors or their
+		// subkeys signed.
+		if ok, _ := ev.CheckSignature(); ok && c.signsFor(ev.PubKey, authors) && c.onBranch(ev) {
+			valid = append(valid, ev)
+		}
+	}
+	latest := latestBy(valid, c.filePath)
+	paths := make([]string, 0, len(latest))
+	for p := range latest {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	written, skipped := 0, 0
+	for i, p := range paths {
+		fileDone(c.Observer, p, i, len(paths))
+		ev := latest[p]
+		if err := checkRel(p); err != nil {
+			slog.Warn("Skipping file", "file", p, "err", err)
+			skipped++
+			continue
+		}
+		if policy != nil {
+			if err := policy.Check(c, ev); err != nil {
+				slog.Warn("Skipping file", "file", p, "err", err)
+				skipped++
+				continue
+			}
+		}
+		if _, err := c.restoreVersion(p, ev); err != nil {
+			slog.Warn("Skipping file", "file", p, "err", err)
+			skipped++
+			continue
+		}
+		if err := c.Repo.LogHead("clone", p, "", ev.ID, c.fileMessage(ev)); err != nil {
+			return written, skipped, err
+		}
+		fmt.Printf("  %s\n", p)
+		written++
+	}
+	fileDone(c.Observer, "", len(paths), len(paths))
+	return written, skipped, c.Repo.clearCheckpoint(cloneCheckpoint)
+}
+
+func cmdClone(args []string) error {
+	fs := flag.NewFlagSet("clone", flag.ContinueOnError)
+	resume := fs.Bool("continue", false, "resume an interrupted clone")
+	policyPath := fs.String("policy", "", "only accept files that satisfy this verification policy")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		return fmt.Errorf("usage: orbi clone [--continue] [--policy <file>] <npub> [<dir>]")
+	}
+	author, err := parsePubkey(positional[0])
+	if err != nil {
+		return err
+	}
+	var policy *Policy
+	if *policyPath != "" {
+		if policy, err = loadPolicy(*policyPath, ""); err != nil {
+			return err
+		}
+	}
+	if len(positional) == 2 {
+		if err := os.MkdirAll(positional[1], 0755); err != nil {
+			return err
+		}
+		if err := os.Chdir(positional[1]); err != nil {
+			return err
+		}
+	}
+
+	repo := openRepo(".")
+	if v, err := repo.FormatVersion(); err != nil {
+		return err
+	} else if v != 0 && !*resume {
+		return fmt.Errorf("%s already exists; use --continue to resume an interrupted clone", localOrbiDirName)
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	client.addOutbox(author)
+	// Subkey authorizations name the repository, so use the origin's name
+	// for it before checking who signed what.
+	if ev := client.announcementOf(author); ev != nil && ev.Tags.GetD() != "" {
+		cfg, err := repo.Config()
+		if err != nil {
+			return err
+		}
+		if cfg.Name == "" {
+			cfg.Name = ev.Tags.GetD()
+			if err := repo.SaveConfig(cfg); err != nil {
+				return err
+			}
+		}
+	}
+	collaborators := client.collaboratorsOf(author)
+	for _, pk := range collaborators {
+		client.addOutbox(pk)
+	}
+	written, skipped, err := client.clone(author, collaborators, policy, *resume)
+	if err != nil {
+		if written == 0 {
+			return err
+		}
+		return fmt.Errorf("clone incomplete after %d files: %w", written, err)
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Origin, _ = nip19.EncodePublicKey(author)
+	cfg.Collaborators = nil
+	for _, pk := range collaborators {
+		npub, _ := nip19.EncodePublicKey(pk)
+		cfg.Collaborators = append(cfg.Collaborators, npub)
+	}
+	if err := repo.SaveConfig(cfg); err != nil {
+		return err
+	}
+	if err := repo.register(); err != nil {
+		slog.Warn("Failed to add repository to the workspace registry", "err", err)
+	}
+	fmt.Printf("Cloned %d file(s) from %s\n", written, positional[0])
+	if skipped > 0 {
+		return fmt.Errorf("%d file(s) were skipped", skipped)
+	}
+	return nil
+}
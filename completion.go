@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// globalFlags are the options accepted before the command.
+var globalFlags = []string{"--identity", "--key-file", "--passphrase-file", "--proxy", "-q", "--quiet", "--reset-health", "--signer", "-v", "--verbose", "-vv"}
+
+// completionSubcommands lists the subcommands of commands that have them.
+var completionSubcommands = map[string][]string{
+	"branch":     {"create", "delete"},
+	"bridge":     {"github"},
+	"completion": {"bash", "zsh", "fish"},
+	"identity":   {"add", "list", "use"},
+	"key":        {"encrypt", "recover", "split", "subkey", "unwrap"},
+	"policy":     {"check"},
+	"relay":      {"add", "list", "rm", "test"},
+	"release":    {"attach", "download"},
+}
+
+// completionArgs says what the arguments of a command or subcommand are:
+// tracked files, branches or relays. The empty command is publishing a file.
+// Anything else falls back to the shell's own filename completion.
+var completionArgs = map[string]string{
+	"":              "files",
+	"approve":       "files",
+	"bench":         "relays",
+	"branch delete": "branches",
+	"checkout":      "files",
+	"diff":          "files",
+	"log":           "files",
+	"relay rm":      "relays",
+	"relay test":    "relays",
+	"resolve":       "files",
+	"rm":            "files",
+	"switch":        "branches",
+	"verify":        "files",
+}
+
+// completionCommands returns the documented commands. They are read from
+// synopses rather than commands, which refers back to cmdComplete.
+func completionCommands() []string {
+	var names []string
+	for _, s := range synopses[1:] {
+		if name := strings.Fields(s)[1]; !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var flagPattern = regexp.MustCompile(`(?:^|[\s\[|])(--?[a-zA-Z][\w-]*)`)
+
+// completionFlags returns the flags of cmd as documented in its synopses,
+// plus the global options where the command has yet to be given.
+func completionFlags(cmd string) []string {
+	var flags []string
+	for i, s := range synopses {
+		words := strings.Fields(s)
+		if cmd == "" && i != 0 || cmd != "" && (len(words) < 2 || words[1] != cmd) {
+			continue
+		}
+		for _, m := range flagPattern.FindAllStringSubmatch(s, -1) {
+			if !contains(flags, m[1]) {
+				flags = append(flags, m[1])
+			}
+		}
+	}
+	if cmd == "" {
+		flags = append(flags, globalFlags...)
+	}
+	sort.Strings(flags)
+	return flags
+}
+
+// completionCandidates returns the words that can follow cmd and sub.
+func completionCandidates(cmd, sub string) []string {
+	if !contains(completionCommands(), cmd) {
+		cmd, sub = "", ""
+	}
+	if subs, ok := completionSubcommands[cmd]; ok && sub == "" {
+		return subs
+	}
+	kind, ok := completionArgs[strings.TrimSpace(cmd+" "+sub)]
+	if !ok {
+		kind = completionArgs[cmd]
+	}
+	repo := openRepo(".")
+	var words []string
+	switch kind {
+	case "files":
+		words, _ = repo.TrackedFiles()
+	case "branches":
+		words, _ = repo.Branches()
+	case "relays":
+		if cfg, err := repo.Config(); err == nil {
+			if cfg, err = withGlobalRelays(cfg); err == nil {
+				words = configuredRelays(cfg)
+			}
+		}
+	}
+	return words
+}
+
+// cmdComplete is called by the completion scripts to list candidates:
+// __complete commands | flags <cmd> | args <cmd> [<subcommand>].
+func cmdComplete(args []string) error {
+	var words []string
+	switch {
+	case len(args) == 1 && args[0] == "commands":
+		words = completionCommands()
+	case len(args) >= 1 && args[0] == "flags":
+		words = completionFlags(strings.Join(args[1:], ""))
+	case len(args) >= 2 && args[0] == "args":
+		sub := ""
+		if len(args) > 2 {
+			sub = args[2]
+		}
+		words = completionCandidates(args[1], sub)
+	}
+	for _, w := range words {
+		fmt.Println(w)
+	}
+	return nil
+}
+
+func cmdCompletion(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: orbi completion bash|zsh|fish")
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		return fmt.Errorf("unsupported shell %q: must be bash, zsh or fish", args[0])
+	}
+	return nil
+}
+
+// The scripts find the command and subcommand on the line, skipping global
+// options and their values, and ask orbi __complete for the candidates.
+
+const bashCompletion = `# orbi bash completion. Load it with
+#   source <(orbi completion bash)
+_orbi() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" cmd="" sub="" words i w
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        w="${COMP_WORDS[i]}"
+        case "$w" in
+        --identity|--key-file|--passphrase-file|--proxy|--signer) ((i++)) ;;
+        -*) ;;
+        *) if [[ -z $cmd ]]; then cmd="$w"; elif [[ -z $sub ]]; then sub="$w"; fi ;;
+        esac
+    done
+    if [[ $cur == -* ]]; then
+        words=$(orbi __complete flags "$cmd" 2>/dev/null)
+    elif [[ -z $cmd ]]; then
+        words="$(orbi __complete commands 2>/dev/null) $(orbi __complete args "" 2>/dev/null)"
+    else
+        words=$(orbi __complete args "$cmd" "$sub" 2>/dev/null)
+    fi
+    local IFS=$'\n'
+    COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _orbi orbi
+`
+
+const zshCompletion = `#compdef orbi
+# orbi zsh completion. Save it as _orbi in a directory on $fpath, or load it
+# with
+#   source <(orbi completion zsh)
+_orbi() {
+    local -a candidates
+    local cmd="" sub="" i w
+    for ((i = 2; i < CURRENT; i++)); do
+        w=${words[i]}
+        case $w in
+        --identity|--key-file|--passphrase-file|--proxy|--signer) ((i++)) ;;
+        -*) ;;
+        *) if [[ -z $cmd ]]; then cmd=$w; elif [[ -z $sub ]]; then sub=$w; fi ;;
+        esac
+    done
+    if [[ ${words[CURRENT]} == -* ]]; then
+        candidates=(${(f)"$(orbi __complete flags "$cmd" 2>/dev/null)"})
+    elif [[ -z $cmd ]]; then
+        candidates=(${(f)"$(orbi __complete commands 2>/dev/null)"} ${(f)"$(orbi __complete args "" 2>/dev/null)"})
+    else
+        candidates=(${(f)"$(orbi __complete args "$cmd" "$sub" 2>/dev/null)"})
+    fi
+    if (( ${#candidates} )); then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+if [[ $funcstack[1] == _orbi ]]; then
+    _orbi "$@"
+else
+    compdef _orbi orbi
+fi
+`
+
+const fishCompletion = `# orbi fish completion. Load it with
+#   orbi completion fish | source
+# or save it as ~/.config/fish/completions/orbi.fish.
+function __orbi_candidates
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    set -l cmd ""
+    set -l sub ""
+    set -l skip 0
+    for w in $tokens
+        if test $skip -eq 1
+            set skip 0
+            continue
+        end
+        switch $w
+            case --identity --key-file --passphrase-file --proxy --signer
+                set skip 1
+            case '-*'
+            case '*'
+                if test -z "$cmd"
+                    set cmd $w
+                else if test -z "$sub"
+                    set sub $w
+                end
+        end
+    end
+    if string match -q -- '-*' (commandline -ct)
+        orbi __complete flags "$cmd" 2>/dev/null
+    else if test -z "$cmd"
+        orbi __complete commands 2>/dev/null
+        orbi __complete args "" 2>/dev/null
+    else
+        orbi __complete args "$cmd" "$sub" 2>/dev/null
+    end
+end
+complete -c orbi -a '(__orbi_candidates)'
+`
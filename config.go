@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const configFileName = "config"
+
+// Config holds per-repository settings from .orbi/config, a JSON object.
+type Config struct {
+	// Name identifies the repository in its announcement. It defaults to
+	// the name of the directory.
+	Name string `json:"name,omitempty"`
+
+	// Origin is the npub whose files this repository was cloned from.
+	Origin string `json:"origin,omitempty"`
+
+	// Kinds remaps the event kinds used for each role.
+	Kinds *KindMap `json:"kinds,omitempty"`
+
+	// EOL is the line-ending policy: "lf", "crlf", "native" or empty to
+	// publish files byte for byte.
+	EOL string `json:"eol,omitempty"`
+
+	Release *ReleaseConfig `json:"release,omitempty"`
+
+	// Policy is the repo-relative path of a verification policy (usually
+	// policy.orbi) that fetched content must satisfy, and PolicySHA256 pins
+	// its expected contents.
+	Policy       string `json:"policy,omitempty"`
+	PolicySHA256 string `json:"policy_sha256,omitempty"`
+
+	// OwnersMode decides what happens when publishing a path owned by
+	// someone else per .orbi/owners: "warn" (the default) or "enforce".
+	OwnersMode string `json:"owners_mode,omitempty"`
+
+	// Collaborators are npubs allowed to publish files to the repository
+	// alongside its owner. Pull and clone take the newest version any of
+	// them published and reject events from everyone else.
+	Collaborators []string `json:"collaborators,omitempty"`
+
+	// CISigners are npubs of CI runners whose status events are trusted in
+	// addition to those of the repository's authors.
+	CISigners []string `json:"ci_signers,omitempty"`
+
+	// BlossomServers are the Blossom blob servers used for uploads.
+	BlossomServers []string `json:"blossom_servers,omitempty"`
+
+	// Storage is where the content of large files goes: "inline" in the
+	// event, "blossom" (the default when BlossomServers are set) or
+	// "nip96", uploading to NIP96Server. BlobThreshold is the file size in
+	// bytes above which files are stored off-relay; it defaults to 64 KiB.
+	Storage       string `json:"storage,omitempty"`
+	NIP96Server   string `json:"nip96_server,omitempty"`
+	BlobThreshold int    `json:"blob_threshold,omitempty"`
+
+	// IgnoreMtime writes pulled, cloned and checked out files with the
+	// current time instead of the modification time they were published
+	// with. Their permissions are restored either way.
+	IgnoreMtime bool `json:"ignore_mtime,omitempty"`
+
+	// MergeTool is a shell command that merges files changed both locally
+	// and remotely on pull, run with $BASE, $LOCAL, $REMOTE and $MERGED
+	// naming the versions and the output file, as git's mergetool does.
+	// Without it orbi merges line by line and writes conflict markers.
+	MergeTool string `json:"merge_tool,omitempty"`
+
+	// Compression, "gzip" or "zstd", compresses file content before
+	// publishing whenever that makes the event smaller.
+	Compression string `json:"compression,omitempty"`
+
+	// Addressable lists gitignore-style patterns of files published as
+	// addressable events keyed by path, so relays keep only their newest
+	// version.
+	Addressable []string `json:"addressable,omitempty"`
+
+	// Delta, when set, publishes later versions of text files as diffs
+	// against their parent, with a full snapshot every few versions.
+	Delta *DeltaConfig `json:"delta,omitempty"`
+
+	// SigningKey is the path of a secret key file holding a signing subkey
+	// authorized by Identity (an npub). When set, it signs everything
+	// instead of the main key, which can then stay offline.
+	SigningKey string `json:"signing_key,omitempty"`
+	Identity   string `json:"identity,omitempty"`
+
+	// KeyRotations record the keys this repository was published with
+	// before the current one, so their history is still read as its own.
+	KeyRotations []KeyRotation `json:"key_rotations,omitempty"`
+
+	// IdentityProfile names the identity profile (a key file under
+	// ~/.config/orbi/identities) used here unless --identity overrides it.
+	IdentityProfile string `json:"identity_profile,omitempty"`
+
+	// MergeQueue, when set, serializes pushes through head claims.
+	MergeQueue *MergeQueueConfig `json:"merge_queue,omitempty"`
+
+	// Watch tunes the debounce period and ignore rules of orbi watch.
+	Watch *WatchConfig `json:"watch,omitempty"`
+
+	// RelayGroups are ordered failover tiers of relays; the first group is
+	// always used and later ones only when too few of the earlier relays
+	// succeed. When empty the default relays are used.
+	RelayGroups []RelayGroup `json:"relay_groups,omitempty"`
+
+	// WriteRelays is a plain list of relays to publish to, an alternative to
+	// RelayGroups. ReadRelays, when set, are queried instead of the relays
+	// published to. Timeout bounds each operation on a relay, as a Go
+	// duration such as "5s". Settings left unset here fall back to the
+	// global config (~/.config/orbi/config).
+	WriteRelays []string `json:"write_relays,omitempty"`
+	ReadRelays  []string `json:"read_relays,omitempty"`
+	Timeout     string   `json:"timeout,omitempty"`
+
+	// MaxEventSize caps the serialized size of published events, in bytes.
+	// It defaults to 128 KiB; relays advertising a lower limit take
+	// precedence.
+	MaxEventSize int `json:"max_event_size,omitempty"`
+
+	// MinRelays is how many relays must accept every published event for
+	// the publish to count as successful. It defaults to one.
+	MinRelays int `json:"min_relays,omitempty"`
+
+	// Retry controls retries of relay operations after connection failures
+	// and timeouts.
+	Retry *RetryConfig `json:"retry,omitempty"`
+
+	// RelayTLS sets certificate pins, custom CAs or (for development)
+	// disabled verification for individual relays, keyed by relay URL.
+	RelayTLS map[string]*RelayTLS `json:"relay_tls,omitempty"`
+
+	// PoW is the NIP-13 proof-of-work difficulty to mine every event to.
+	// RelayPoW sets the difficulty individual relays require, keyed by
+	// relay URL.
+	PoW      int            `json:"pow,omitempty"`
+	RelayPoW map[string]int `json:"relay_pow,omitempty"`
+
+	// Proxy routes every connection through a socks5:// or http:// proxy,
+	// such as Tor at socks5://127.0.0.1:9050.
+	Proxy string `json:"proxy,omitempty"`
+}
+
+func (cfg *Config) validate() error {
+	switch cfg.EOL {
+	case "", eolLF, eolCRLF, eolNative:
+	default:
+		return fmt.Errorf("invalid eol %q: must be lf, crlf or native", cfg.EOL)
+	}
+	if cfg.Kinds != nil {
+		if err := cfg.Kinds.validate(); err != nil {
+			return err
+		}
+	}
+	switch cfg.Storage {
+	case "", orbi.StorageInline:
+	case orbi.StorageBlossom:
+		if len(cfg.BlossomServers) == 0 {
+			return fmt.Errorf("storage blossom requires blossom_servers")
+		}
+	case orbi.StorageNIP96:
+		if cfg.NIP96Server == "" {
+			return fmt.Errorf("storage nip96 requires nip96_server")
+		}
+	default:
+		return fmt.Errorf("invalid storage %q: must be inline, blossom or nip96", cfg.Storage)
+	}
+	if cfg.Compression != "" {
+		if err := orbi.CheckCompression(cfg.Compression); err != nil {
+			return err
+		}
+	}
+	for _, p := range cfg.Addressable {
+		if _, err := globRegexp(p); err != nil {
+			return fmt.Errorf("addressable %q: %w", p, err)
+		}
+	}
+	if cfg.Delta != nil {
+		if err := cfg.Delta.validate(); err != nil {
+			return err
+		}
+	}
+	switch cfg.OwnersMode {
+	case "", ownersWarn, ownersEnforce:
+	default:
+		return fmt.Errorf("invalid owners_mode %q: must be warn or enforce", cfg.OwnersMode)
+	}
+	if cfg.Origin != "" {
+		if _, err := parsePubkey(cfg.Origin); err != nil {
+			return fmt.Errorf("origin: %w", err)
+		}
+	}
+	for _, s := range cfg.Collaborators {
+		if _, err := parsePubkey(s); err != nil {
+			return fmt.Errorf("collaborators: %w", err)
+		}
+	}
+	if (cfg.SigningKey == "") != (cfg.Identity == "") {
+		return fmt.Errorf("signing_key and identity must be set together")
+	}
+	for _, r := range cfg.KeyRotations {
+		if _, err := parsePubkey(r.From); err != nil {
+			return fmt.Errorf("key_rotations: %w", err)
+		}
+		if _, err := parsePubkey(r.To); err != nil {
+			return fmt.Errorf("key_rotations: %w", err)
+		}
+	}
+	if cfg.MaxEventSize < 0 {
+		return fmt.Errorf("invalid max_event_size %d: must not be negative", cfg.MaxEventSize)
+	}
+	if cfg.Identity != "" {
+		if _, err := parsePubkey(cfg.Identity); err != nil {
+			return fmt.Errorf("identity: %w", err)
+		}
+	}
+	if cfg.IdentityProfile != "" {
+		if err := validProfileName(cfg.IdentityProfile); err != nil {
+			return err
+		}
+	}
+	if cfg.MergeQueue != nil {
+		if err := cfg.MergeQueue.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.Watch != nil {
+		if err := cfg.Watch.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateRelayGroups(cfg.RelayGroups); err != nil {
+		return err
+	}
+	if len(cfg.WriteRelays) > 0 && len(cfg.RelayGroups) > 0 {
+		return fmt.Errorf("write_relays and relay_groups are mutually exclusive")
+	}
+	if cfg.Timeout != "" {
+		if d, err := time.ParseDuration(cfg.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q: must be a positive duration such as 5s", cfg.Timeout)
+		}
+	}
+	if cfg.MinRelays < 0 {
+		return fmt.Errorf("invalid min_relays %d: must not be negative", cfg.MinRelays)
+	}
+	if cfg.Retry != nil {
+		if err := cfg.Retry.validate(); err != nil {
+			return err
+		}
+	}
+	for url, rt := range cfg.RelayTLS {
+		if err := rt.validate(url); err != nil {
+			return err
+		}
+	}
+	if err := validPoW(cfg.PoW); err != nil {
+		return fmt.Errorf("pow: %w", err)
+	}
+	for url, d := range cfg.RelayPoW {
+		if err := validPoW(d); err != nil {
+			return fmt.Errorf("relay_pow %s: %w", url, err)
+		}
+	}
+	if cfg.Proxy != "" {
+		if _, err := parseProxy(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Config reads the repository's configuration. A missing file yields the
+// zero Config.
+func (r *Repo) Config() (*Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.config()
+}
+
+func (r *Repo) config() (*Config, error) {
+	cfg := &Config{}
+	content, err := ioutil.ReadFile(filepath.Join(r.dir(), configFileName))
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", filepath.Join(localOrbiDirName, configFileName), err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SaveConfig writes cfg to .orbi/config.
+func (r *Repo) SaveConfig(cfg *Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.dir(), configFileName), append(content, '\n'), 0644)
+}
+
+// addressable reports whether rel is published as an addressable event.
+func (cfg *Config) addressable(rel string) bool {
+	for _, p := range cfg.Addressable {
+		if matchGlob(p, rel) {
+			return true
+		}
+	}
+	return false
+}
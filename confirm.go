@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// PublishSummary describes an event that is about to be published, for
+// display before the user confirms.
+type PublishSummary struct {
+	Path      string
+	Size      int
+	Relays    []string
+	Encrypted bool
+	// Binary is set for non-text content, which is published base64-encoded.
+	Binary   bool
+	Warnings []string
+}
+
+// sensitiveNames are file name patterns that usually hold secrets.
+var sensitiveNames = []string{
+	".env", ".env.*", "*.pem", "*.key", "*.p12", "*.pfx", "id_rsa*", "id_ed25519*", "id_ecdsa*",
+	"*secret*", "*credential*", ".npmrc", ".pypirc", ".netrc", "*.kdbx",
+}
+
+// sensitiveContent are markers that suggest a file contains key material.
+var sensitiveContent = [][]byte{
+	[]byte("PRIVATE KEY-----"),
+	[]byte("nsec1"),
+	[]byte("ncryptsec1"),
+	[]byte("AKIA"),
+}
+
+// sensitiveWarnings explains why a file looks like it shouldn't be public.
+func sensitiveWarnings(rel string, content []byte) []string {
+	var warnings []string
+	base := strings.ToLower(path.Base(rel))
+	for _, pattern := range sensitiveNames {
+		if ok, _ := path.Match(pattern, base); ok {
+			warnings = append(warnings, fmt.Sprintf("the name %s matches %q, which usually holds secrets", rel, pattern))
+			break
+		}
+	}
+	for _, marker := range sensitiveContent {
+		if bytes.Contains(content, marker) {
+			warnings = append(warnings, fmt.Sprintf("%s contains %q, which looks like key material", rel, marker))
+		}
+	}
+	return warnings
+}
+
+func (s PublishSummary) String() string {
+	var b strings.Builder
+	visibility := "PUBLIC"
+	if s.Encrypted {
+		visibility = "encrypted"
+	}
+	if s.Binary {
+		visibility += ", binary as base64"
+	}
+	fmt.Fprintf(&b, "About to publish %s (%d bytes, %s) to:\n", s.Path, s.Size, visibility)
+	for _, r := range s.Relays {
+		fmt.Fprintf(&b, "  %s\n", r)
+	}
+	for _, w := range s.Warnings {
+		fmt.Fprintf(&b, "WARNING: %s\n", w)
+	}
+	b.WriteString("Published events cannot be reliably deleted.")
+	return b.String()
+}
+
+func newPublishSummary(rel string, raw []byte, ev *nostr.Event, relays []string) PublishSummary {
+	s := PublishSummary{
+		Path:      rel,
+		Size:      eventSize(ev),
+		Relays:    relays,
+		Encrypted: ev.Tags.Find("encrypted") != nil,
+		Binary:    ev.Tags.Find("encoding") != nil && ev.Tags.Find("charset") == nil,
+	}
+	if !s.Encrypted {
+		s.Warnings = sensitiveWarnings(rel, raw)
+	}
+	return s
+}
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// promptConfirm is the CLI's Client.Confirm: it prints the summary and asks
+// on the terminal, refusing when there is no terminal to ask on.
+func promptConfirm(s PublishSummary) (bool, error) {
+	fmt.Println(s)
+	if !isTerminal(os.Stdin) {
+		return false, fmt.Errorf("refusing to publish without confirmation; pass --yes to skip the prompt")
+	}
+	question := "Publish? [y/N] "
+	if len(s.Warnings) > 0 {
+		question = "This file looks sensitive. Type 'publish' to continue: "
+	}
+	fmt.Print(question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if len(s.Warnings) > 0 {
+		return answer == "publish", nil
+	}
+	return answer == "y" || answer == "yes", nil
+}
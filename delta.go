@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// defaultSnapshotEvery is how many versions of a file make up a delta chain
+// when DeltaConfig doesn't say: a full snapshot followed by nine deltas.
+const defaultSnapshotEvery = 10
+
+// maxDeltaChain bounds how many deltas are followed to rebuild a version.
+const maxDeltaChain = 1000
+
+// DeltaConfig enables publishing later versions of text files as diffs
+// against their parent.
+type DeltaConfig struct {
+	// SnapshotEvery is how many versions in a row form a chain before the
+	// full content is published again. It defaults to 10.
+	SnapshotEvery int `json:"snapshot_every,omitempty"`
+}
+
+func (d *DeltaConfig) validate() error {
+	if d.SnapshotEvery < 0 || d.SnapshotEvery > maxDeltaChain {
+		return fmt.Errorf("invalid delta snapshot_every %d: must be between 1 and %d, or 0 for the default of %d", d.SnapshotEvery, maxDeltaChain, defaultSnapshotEvery)
+	}
+	return nil
+}
+
+func (d *DeltaConfig) snapshotEvery() int {
+	if d.SnapshotEvery > 0 {
+		return d.SnapshotEvery
+	}
+	return defaultSnapshotEvery
+}
+
+// deltaDepth returns how many deltas lead up to ev from the last full
+// snapshot, zero if ev is one.
+func deltaDepth(ev *nostr.Event) int {
+	tag := ev.Tags.Find("delta")
+	if tag == nil || len(tag) < 3 {
+		return 0
+	}
+	n, _ := strconv.Atoi(tag[2])
+	return n
+}
+
+// deltaOption returns the option publishing content, the next version of
+// rel after parent, as a delta, or nil when a full snapshot is due or the
+// parent isn't text.
+func (c *Client) deltaOption(d *DeltaConfig, rel, parent string, content []byte) (orbi.EventOption, error) {
+	ev, err := c.eventByID(parent)
+	if err != nil {
+		return nil, err
+	}
+	depth := deltaDepth(ev) + 1
+	if depth >= d.snapshotEvery() || ev.Tags.Find("storage") != nil {
+		return nil, nil
+	}
+	base, err := c.eventContent(ev)
+	if err != nil {
+		return nil, err
+	}
+	if !orbi.IsText(base) {
+		return nil, nil
+	}
+	return orbi.WithDelta(parent, unifiedDiff(rel, rel, string(base), string(content)), depth), nil
+}
+
+// eventByID fetches the event with the given id, checking that it is
+// authentic. Events still in the outbox are found too.
+func (c *Client) eventByID(id string) (*nostr.Event, error) {
+	queued, err := c.Repo.Outbox()
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range queued {
+		if ev.ID == id {
+			return ev, nil
+		}
+	}
+	for _, ev := range c.query(nostr.Filter{IDs: []string{id}}) {
+		if ok, _ := ev.CheckSignature(); ok && ev.ID == id && ev.CheckID() {
+			return ev, nil
+		}
+	}
+	return nil, fmt.Errorf("event %s: %w", id, ErrNotFound)
+}
+
+// eventContent returns the file content of ev like readEventContent,
+// rebuilding deltas from the versions they are based on, and checks it
+// against the hash in ev's "x" tag when there is one.
+func (c *Client) eventContent(ev *nostr.Event) ([]byte, error) {
+	content, err := c.rebuildContent(ev)
+	if err != nil {
+		return nil, err
+	}
+	if tag := ev.Tags.Find("x"); tag != nil && len(tag) >= 2 {
+		if got := orbi.ContentHash(content); got != tag[1] {
+			return nil, fmt.Errorf("event %s: content has hash %s but the event says %s; it may be corrupted or truncated", ev.ID, got, tag[1])
+		}
+	}
+	return content, nil
+}
+
+// rebuildContent follows ev's chain of deltas back to a full version and
+// applies them in order.
+func (c *Client) rebuildContent(ev *nostr.Event) ([]byte, error) {
+	var chain []*nostr.Event
+	for tag := ev.Tags.Find("delta"); tag != nil; tag = ev.Tags.Find("delta") {
+		if len(chain) == maxDeltaChain {
+			return nil, fmt.Errorf("event %s: delta chain is longer than %d versions", chain[0].ID, maxDeltaChain)
+		}
+		chain = append(chain, ev)
+		base, err := c.eventByID(tag[1])
+		if err != nil {
+			return nil, fmt.Errorf("base of delta %s: %w", ev.ID, err)
+		}
+		ev = base
+	}
+	content, err := readEventContent(ev, c.sk, c.Observer)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if content, err = applyDelta(chain[i], content, c.sk); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// applyDelta applies the diff carried by ev to the content of its base.
+func applyDelta(ev *nostr.Event, base []byte, sk string) ([]byte, error) {
+	diff, err := orbi.DecodeContent(ev, sk)
+	if err != nil {
+		return nil, err
+	}
+	if len(diff) == 0 {
+		return base, nil
+	}
+	files, err := parseDiff(string(diff))
+	if err != nil {
+		return nil, fmt.Errorf("delta %s: %w", ev.ID, err)
+	}
+	if len(files) != 1 {
+		return nil, fmt.Errorf("delta %s: expected a diff of one file, got %d", ev.ID, len(files))
+	}
+	content, err := applyHunks(string(base), files[0].hunks)
+	if err != nil {
+		return nil, fmt.Errorf("delta %s: %w", ev.ID, err)
+	}
+	return []byte(content), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+func TestEventContentDeltas(t *testing.T) {
+	base := strings.Repeat("a line of text\n", 20)
+	tests := []struct {
+		name     string
+		versions []string
+	}{
+		{"snapshot", []string{base}},
+		{"one delta", []string{base, "new first line\n" + base}},
+		{"chain", []string{
+			base,
+			strings.Replace(base, "a line", "line 1", 1),
+			strings.Replace(base, "a line", "line 1", 2),
+			strings.Replace(base, "a line", "line 1", 3) + "appended\n",
+		}},
+		{"empty delta", []string{base, base}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, transport := newTestClient(t)
+			var prev *nostr.Event
+			for i, v := range tt.versions {
+				var opts []orbi.EventOption
+				if prev != nil {
+					opts = append(opts, orbi.WithParent(prev.ID), orbi.WithDelta(prev.ID, unifiedDiff("a.txt", "a.txt", tt.versions[i-1], v), deltaDepth(prev)+1))
+				}
+				ev := testEvent(t, c, "a.txt", v, 1700000000+int64(i), opts...)
+				if prev != nil && ev.Tags.Find("delta") == nil {
+					t.Fatalf("version %d is not a delta", i)
+				}
+				if err := transport.Publish(context.Background(), testRelayURL, *ev); err != nil {
+					t.Fatal(err)
+				}
+				got, err := c.eventContent(ev)
+				if err != nil {
+					t.Fatalf("version %d: %v", i, err)
+				}
+				if string(got) != v {
+					t.Errorf("version %d rebuilds to %q, want %q", i, got, v)
+				}
+				prev = ev
+			}
+		})
+	}
+}
+
+func TestEventContentMissingBase(t *testing.T) {
+	c, _ := newTestClient(t)
+	v1 := strings.Repeat("a line of text\n", 20)
+	e1 := testEvent(t, c, "a.txt", v1, 1700000000)
+	e2 := testEvent(t, c, "a.txt", v1+"more\n", 1700000001, orbi.WithDelta(e1.ID, unifiedDiff("a.txt", "a.txt", v1, v1+"more\n"), 1))
+	if _, err := c.eventContent(e2); err == nil || !strings.Contains(err.Error(), "base of delta") {
+		t.Errorf("got error %v, want a missing base", err)
+	}
+}
+
+func TestDeltaOnlyWhenSmaller(t *testing.T) {
+	c, _ := newTestClient(t)
+	e1 := testEvent(t, c, "a.txt", "one\n", 1700000000)
+	e2 := testEvent(t, c, "a.txt", "two\n", 1700000001, orbi.WithDelta(e1.ID, unifiedDiff("a.txt", "a.txt", "one\n", "two\n"), 1))
+	if tag := e2.Tags.Find("delta"); tag != nil {
+		t.Errorf("a diff larger than the file was published as %v", tag)
+	}
+}
+
+func TestDeltaConfigValidate(t *testing.T) {
+	for _, tt := range []struct {
+		every, want int
+		ok          bool
+	}{
+		{0, defaultSnapshotEvery, true},
+		{1, 1, true},
+		{maxDeltaChain, maxDeltaChain, true},
+		{-1, 0, false},
+		{maxDeltaChain + 1, 0, false},
+	} {
+		d := DeltaConfig{SnapshotEvery: tt.every}
+		err := d.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("snapshot_every %d: got error %v, want ok=%v", tt.every, err, tt.ok)
+		}
+		if tt.ok && d.snapshotEvery() != tt.want {
+			t.Errorf("snapshot_every %d means %d, want %d", tt.every, d.snapshotEvery(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' kept, '-' deleted, '+' inserted.
+// Lines keep their trailing newline so a missing final newline is a change.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits content after each newline.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a shortest edit script from a to b with Myers'
+// algorithm.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max
+	v := make([]int, 2*max+2)
+	var trace [][]int
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// unifiedHunks renders the differences between a and b as unified diff
+// hunks with the usual three lines of context. It returns "" if they are
+// equal.
+func unifiedHunks(a, b string) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+	var out strings.Builder
+	aLine, bLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+		// Back up over leading context, then extend the hunk while changes
+		// are close enough for their context to overlap.
+		start := i
+		for start > 0 && i-start < diffContext && ops[start-1].kind == ' ' {
+			start--
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+		stop := end + 1
+		for stop < len(ops) && stop-end <= diffContext && ops[stop].kind == ' ' {
+			stop++
+		}
+
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		var aCount, bCount int
+		var body strings.Builder
+		for _, op := range ops[start:stop] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+			body.WriteByte(op.kind)
+			body.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				body.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		out.WriteString(body.String())
+		for _, op := range ops[i:stop] {
+			if op.kind != '+' {
+				aLine++
+			}
+			if op.kind != '-' {
+				bLine++
+			}
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// unifiedDiff renders a git-style diff of one file. Empty names mean the
+// file doesn't exist on that side.
+func unifiedDiff(oldName, newName, a, b string) string {
+	hunks := unifiedHunks(a, b)
+	if hunks == "" {
+		return ""
+	}
+	name := newName
+	if name == "" {
+		name = oldName
+	}
+	from, to := "a/"+oldName, "b/"+newName
+	var header strings.Builder
+	fmt.Fprintf(&header, "diff --git a/%s b/%s\n", name, name)
+	if oldName == "" {
+		header.WriteString("new file mode 100644\n")
+		from = "/dev/null"
+	}
+	if newName == "" {
+		header.WriteString("deleted file mode 100644\n")
+		to = "/dev/null"
+	}
+	fmt.Fprintf(&header, "--- %s\n+++ %s\n", from, to)
+	return header.String() + hunks
+}
+
+// hunk is a parsed unified diff hunk.
+type hunk struct {
+	oldStart int
+	ops      []diffOp
+}
+
+// applyHunks applies hunks to a, searching near each hunk's recorded
+// position when earlier changes have shifted it.
+func applyHunks(a string, hunks []hunk) (string, error) {
+	lines := splitLines(a)
+	var out []string
+	pos := 0
+	for n, h := range hunks {
+		var old, repl []string
+		for _, op := range h.ops {
+			if op.kind != '+' {
+				old = append(old, op.line)
+			}
+			if op.kind != '-' {
+				repl = append(repl, op.line)
+			}
+		}
+		want := h.oldStart - 1
+		if len(old) == 0 {
+			want = h.oldStart
+		}
+		at := -1
+		for delta := 0; at < 0 && (want-delta >= pos || want+delta <= len(lines)); delta++ {
+			for _, cand := range []int{want - delta, want + delta} {
+				if at < 0 && cand >= pos && cand+len(old) <= len(lines) && equalLines(lines[cand:cand+len(old)], old) {
+					at = cand
+				}
+			}
+		}
+		if at < 0 {
+			return "", fmt.Errorf("%w: hunk %d does not apply", ErrConflict, n+1)
+		}
+		out = append(out, lines[pos:at]...)
+		out = append(out, repl...)
+		pos = at + len(old)
+	}
+	out = append(out, lines[pos:]...)
+	return strings.Join(out, ""), nil
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// errFilesDiffer makes orbi diff exit non-zero when it printed a difference,
+// like diff(1).
+var errFilesDiffer = errors.New("files differ")
+
+// ANSI escapes used by orbi diff --color.
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// colorizeDiff highlights a unified diff the way git does.
+func colorizeDiff(diff string) string {
+	var b strings.Builder
+	for _, line := range splitLines(diff) {
+		text := strings.TrimSuffix(line, "\n")
+		color := ""
+		switch {
+		case strings.HasPrefix(text, "diff "), strings.HasPrefix(text, "--- "), strings.HasPrefix(text, "+++ "),
+			strings.HasPrefix(text, "new file"), strings.HasPrefix(text, "deleted file"):
+			color = ansiBold
+		case strings.HasPrefix(text, "@@"):
+			color = ansiCyan
+		case strings.HasPrefix(text, "-"):
+			color = ansiRed
+		case strings.HasPrefix(text, "+"):
+			color = ansiGreen
+		}
+		if color == "" {
+			b.WriteString(line)
+			continue
+		}
+		b.WriteString(color + text + ansiReset + line[len(text):])
+	}
+	return b.String()
+}
+
+// latestVersion returns the newest remote version of rel, or nil when it has
+// never been published.
+func (c *Client) latestVersion(rel string, authors []string) *nostr.Event {
+	var head *nostr.Event
+	for _, ev := range c.versions(rel, authors) {
+		if head == nil || before(head, ev) {
+			head = ev
+		}
+	}
+	return head
+}
+
+// diffRemote renders the difference between the newest remote version of
+// rel and the working copy. Line endings are compared as they would be
+// published.
+func (c *Client) diffRemote(rel string, cfg *Config) (string, error) {
+	var remote []byte
+	oldName := rel
+	if ev := c.latestVersion(rel, c.pullAuthors(cfg)); ev != nil {
+		content, err := c.eventContent(ev)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", rel, err)
+		}
+		remote = content
+	} else {
+		oldName = ""
+	}
+	newName := rel
+	local, err := ioutil.ReadFile(c.Repo.Abs(rel))
+	if os.IsNotExist(err) {
+		newName = ""
+	} else if err != nil {
+		return "", err
+	}
+	local = normalizeEOL(local, cfg.EOL)
+	if oldName == "" && newName == "" {
+		return "", fmt.Errorf("%s: %w", rel, ErrNotFound)
+	}
+	if string(remote) == string(local) {
+		return "", nil
+	}
+	if (remote != nil && !orbi.IsText(remote)) || (local != nil && !orbi.IsText(local)) {
+		return fmt.Sprintf("Binary files a/%s and b/%s differ\n", rel, rel), nil
+	}
+	return unifiedDiff(oldName, newName, string(remote), string(local)), nil
+}
+
+func cmdDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	color := fs.Bool("color", false, "highlight the diff with terminal colors")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		idx, err := client.Repo.Index()
+		if err != nil {
+			return err
+		}
+		rest = idx.Paths()
+	}
+
+	differ := false
+	for _, arg := range rest {
+		rel, err := client.Repo.Rel(arg)
+		if err != nil {
+			return err
+		}
+		diff, err := client.diffRemote(rel, cfg)
+		if err != nil {
+			return err
+		}
+		if diff == "" {
+			continue
+		}
+		differ = true
+		if *color {
+			diff = colorizeDiff(diff)
+		}
+		fmt.Print(diff)
+	}
+	if differ {
+		return errFilesDiffer
+	}
+	return nil
+}
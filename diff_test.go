@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"unchanged", "one\ntwo\n", "one\ntwo\n"},
+		{"change", "one\ntwo\nthree\n", "one\n2\nthree\n"},
+		{"insert", "one\nthree\n", "one\ntwo\nthree\n"},
+		{"delete", "one\ntwo\nthree\n", "one\nthree\n"},
+		{"append", "one\n", "one\ntwo\n"},
+		{"no final newline", "one\ntwo", "one\nTWO"},
+		{"far apart", strings.Repeat("x\n", 20) + "end\n", "start\n" + strings.Repeat("x\n", 20) + "END\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := unifiedDiff("f.txt", "f.txt", tt.a, tt.b)
+			if tt.a == tt.b {
+				if diff != "" {
+					t.Fatalf("diff of equal content is %q, want none", diff)
+				}
+				return
+			}
+			if !strings.HasPrefix(diff, "diff --git a/f.txt b/f.txt\n--- a/f.txt\n+++ b/f.txt\n@@ ") {
+				t.Errorf("diff has header %q", strings.SplitN(diff, "@@", 2)[0])
+			}
+			files, err := parseDiff(diff)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("diff has %d files, want 1", len(files))
+			}
+			got, err := applyHunks(tt.a, files[0].hunks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.b {
+				t.Errorf("applying the diff gives %q, want %q\n%s", got, tt.b, diff)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffNewAndDeletedFiles(t *testing.T) {
+	if diff := unifiedDiff("", "f.txt", "", "one\n"); !strings.Contains(diff, "new file mode 100644\n--- /dev/null\n+++ b/f.txt\n") {
+		t.Errorf("diff of a new file is %q", diff)
+	}
+	if diff := unifiedDiff("f.txt", "", "one\n", ""); !strings.Contains(diff, "deleted file mode 100644\n--- a/f.txt\n+++ /dev/null\n") {
+		t.Errorf("diff of a deleted file is %q", diff)
+	}
+}
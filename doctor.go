@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// maxClockSkew is how far the local clock may drift before relays start
+// rejecting or misordering events.
+const maxClockSkew = time.Minute
+
+// doctorNIPs are the NIPs orbi relies on, with what breaks without them.
+var doctorNIPs = []struct {
+	nip  int
+	need string
+}{
+	{9, "rm and history rewrites can't delete events"},
+	{40, "merge queue claims never expire"},
+}
+
+// doctor collects the results of diagnostic checks.
+type doctor struct {
+	problems int
+}
+
+func (d *doctor) ok(check, detail string) {
+	fmt.Printf("[ ok ] %s: %s\n", check, detail)
+}
+
+func (d *doctor) warn(check, detail, fix string) {
+	fmt.Printf("[warn] %s: %s\n", check, detail)
+	if fix != "" {
+		fmt.Printf("       fix: %s\n", fix)
+	}
+}
+
+func (d *doctor) fail(check, detail, fix string) {
+	d.problems++
+	fmt.Printf("[FAIL] %s: %s\n", check, detail)
+	if fix != "" {
+		fmt.Printf("       fix: %s\n", fix)
+	}
+}
+
+func (d *doctor) checkKey(cfg *Config) {
+	path := secretKeyPath()
+	if cfg != nil && cfg.SigningKey != "" {
+		path = expandPath(cfg.SigningKey)
+	} else if envSecretKey() != "" {
+		if _, pk, err := loadNostrSecretKey(); err != nil {
+			d.fail("key", err.Error(), fmt.Sprintf("%s must hold a single nsec1... or 64-character hex key", nostrSecretKeyEnvVar))
+		} else {
+			d.ok("key", fmt.Sprintf("$%s (pubkey %s)", nostrSecretKeyEnvVar, pk))
+		}
+		return
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		d.fail("key", path+" does not exist", fmt.Sprintf("save your nsec in %s or point %s at it", path, nostrSecretPathEnvVar))
+		return
+	} else if err != nil {
+		d.fail("key", err.Error(), "")
+		return
+	}
+	if content, err := ioutil.ReadFile(path); err == nil && orbi.IsEncryptedKey(string(content)) {
+		d.ok("key", path+" (encrypted with NIP-49)")
+	} else if err == nil && isBunkerURI(string(content)) {
+		d.ok("key", path+" (NIP-46 remote signer)")
+	} else if _, pk, err := readSecretKey(path); err != nil {
+		d.fail("key", err.Error(), fmt.Sprintf("%s must contain a single nsec1... or 64-character hex key", path))
+	} else {
+		d.ok("key", fmt.Sprintf("%s (pubkey %s)", path, pk))
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		d.warn("key permissions", fmt.Sprintf("%s is readable by other users (%v)", path, info.Mode().Perm()), "chmod 600 "+path)
+	}
+}
+
+func (d *doctor) checkRepo(r *Repo) *Config {
+	v, err := r.FormatVersion()
+	switch {
+	case err != nil:
+		d.fail("repository", err.Error(), "")
+		return nil
+	case v == 0:
+		d.ok("repository", "no .orbi directory here yet")
+		return &Config{}
+	case v > repoFormatVersion:
+		d.fail("repository", fmt.Sprintf("format %d is newer than this orbi supports (%d)", v, repoFormatVersion), "upgrade orbi")
+		return nil
+	case v < repoFormatVersion:
+		d.warn("repository", fmt.Sprintf("format %d is older than the current %d", v, repoFormatVersion), "run orbi migrate")
+	default:
+		d.ok("repository", fmt.Sprintf("format %d", v))
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		d.fail("config", err.Error(), "fix or remove "+localOrbiDirName+"/"+configFileName)
+		cfg = nil
+	}
+	idx, err := r.Index()
+	if err != nil {
+		d.fail("index", err.Error(), "")
+		return cfg
+	}
+	var missing []string
+	for _, p := range idx.Paths() {
+		if _, err := os.Stat(r.Abs(p)); os.IsNotExist(err) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		d.warn("index", fmt.Sprintf("%d tracked file(s) missing from disk: %s", len(missing), strings.Join(missing, ", ")),
+			"restore them from the relays or stop tracking them")
+	} else {
+		d.ok("index", fmt.Sprintf("%d tracked file(s)", len(idx.Files)))
+	}
+	if _, err := r.Reflog(); err != nil {
+		d.warn("reflog", err.Error(), "remove "+localOrbiDirName+"/"+reflogFileName+" to start a fresh one")
+	}
+	if pending, err := r.Outbox(); err != nil {
+		d.fail("outbox", err.Error(), "")
+	} else if len(pending) > 0 {
+		d.warn("outbox", fmt.Sprintf("%d commit(s) are waiting to be published", len(pending)), "publish them once a relay is reachable")
+	}
+	return cfg
+}
+
+func (d *doctor) checkRelays(t orbi.Transport, relays []string) {
+	var skewChecked bool
+	for _, url := range relays {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultRelayTimeout)
+		start := time.Now()
+		_, err := t.Fetch(ctx, url, nostr.Filter{Kinds: []int{eventKindFile}, Limit: 1})
+		elapsed := time.Since(start)
+		cancel()
+		if err != nil {
+			d.fail("relay "+url, err.Error(), "check your connection or remove the relay from your configuration")
+			continue
+		}
+		d.ok("relay "+url, fmt.Sprintf("reachable in %s", elapsed.Round(time.Millisecond)))
+
+		ctx, cancel = context.WithTimeout(context.Background(), defaultRelayTimeout)
+		info, err := nip11.Fetch(ctx, url)
+		cancel()
+		if err != nil {
+			d.warn("relay "+url, "no NIP-11 information document", "")
+		} else {
+			for _, n := range doctorNIPs {
+				if !supportsNIP(info, n.nip) {
+					d.warn("relay "+url, fmt.Sprintf("does not advertise NIP-%02d; %s", n.nip, n.need), "")
+				}
+			}
+			if info.Limitation != nil && info.Limitation.MaxMessageLength > 0 && info.Limitation.MaxMessageLength < defaultMaxEventSize {
+				d.warn("relay "+url, fmt.Sprintf("accepts messages of at most %d bytes", info.Limitation.MaxMessageLength),
+					"large files will be refused; prefer relays with higher limits")
+			}
+		}
+
+		if !skewChecked {
+			if skew, err := relayClockSkew(url); err == nil {
+				skewChecked = true
+				if skew > maxClockSkew || skew < -maxClockSkew {
+					d.fail("clock", fmt.Sprintf("local clock is off by %s compared to %s", skew.Round(time.Second), url),
+						"enable time synchronization (NTP) on this machine")
+				} else {
+					d.ok("clock", fmt.Sprintf("within %s of %s", maxClockSkew, url))
+				}
+			}
+		}
+	}
+}
+
+// supportsNIP reports whether info lists nip as supported.
+func supportsNIP(info nip11.RelayInformationDocument, nip int) bool {
+	for _, n := range info.SupportedNIPs {
+		if f, ok := n.(float64); ok && int(f) == nip {
+			return true
+		}
+		if i, ok := n.(int); ok && i == nip {
+			return true
+		}
+	}
+	return false
+}
+
+// relayClockSkew compares the local clock with the Date header of the
+// relay's HTTP endpoint; positive means the local clock is ahead.
+func relayClockSkew(url string) (time.Duration, error) {
+	httpURL := "https" + strings.TrimPrefix(url, "wss")
+	if strings.HasPrefix(url, "ws://") {
+		httpURL = "http" + strings.TrimPrefix(url, "ws")
+	}
+	resp, err := (&http.Client{Timeout: defaultRelayTimeout}).Head(httpURL)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(date), nil
+}
+
+func cmdDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	offline := fs.Bool("offline", false, "skip the relay checks")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	d := &doctor{}
+	repo := openRepo(".")
+	cfg := d.checkRepo(repo)
+	d.checkKey(cfg)
+	if !*offline && cfg != nil {
+		global, err := withGlobalRelays(cfg)
+		if err != nil {
+			d.fail("global config", err.Error(), "fix or remove "+globalConfigPath())
+			global = cfg
+		}
+		cfg = global
+		tlsConfigs, err := relayTLSConfigs(cfg)
+		if err != nil {
+			d.fail("relay_tls", err.Error(), "")
+		}
+		d.checkRelays(orbi.WebsocketTransport{TLS: tlsConfigs, Proxied: proxyURL != nil}, configuredRelays(cfg))
+	}
+	if d.problems > 0 {
+		return fmt.Errorf("%d problem(s) found", d.problems)
+	}
+	fmt.Println("No problems found")
+	return nil
+}
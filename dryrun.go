@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// dryRunTagWidth truncates long tag values, such as deltas, in dry-run
+// output.
+const dryRunTagWidth = 80
+
+// describeEvent prints what publishing ev would send and how its content
+// differs from the version it replaces, without sending anything. raw is
+// the content to show when it can't be read back from ev, as for blobs a
+// dry run doesn't upload.
+func (c *Client) describeEvent(ev *nostr.Event, raw []byte) {
+	rel := c.filePath(ev)
+	content, err := c.eventContent(ev)
+	if err != nil {
+		content = raw
+	}
+	fmt.Printf("Would publish %s\n", rel)
+	fmt.Printf("  id:     %s\n", ev.ID)
+	fmt.Printf("  kind:   %d\n", ev.Kind)
+	fmt.Printf("  size:   %d bytes\n", eventSize(ev))
+	for _, w := range newPublishSummary(rel, content, ev, c.Relays()).Warnings {
+		fmt.Printf("  WARNING: %s\n", w)
+	}
+	for _, tag := range ev.Tags {
+		s := strings.Join(tag, " ")
+		if len(s) > dryRunTagWidth {
+			s = s[:dryRunTagWidth] + "..."
+		}
+		fmt.Printf("  tag:    %s\n", strings.ReplaceAll(s, "\n", `\n`))
+	}
+	for _, r := range c.Relays() {
+		fmt.Printf("  relay:  %s\n", r)
+	}
+
+	var old []byte
+	oldName := rel
+	if parents := eventParents(ev); len(parents) > 0 {
+		parent, err := c.eventByID(parents[0])
+		if err == nil {
+			old, err = c.eventContent(parent)
+		}
+		if err != nil {
+			fmt.Printf("  (previous version %s unavailable: %v)\n\n", short(parents[0]), err)
+			return
+		}
+	} else {
+		oldName = ""
+	}
+	switch {
+	case string(old) == string(content) && oldName != "":
+		fmt.Println("  (content unchanged)")
+	case (old != nil && !orbi.IsText(old)) || !orbi.IsText(content):
+		fmt.Printf("Binary files a/%s and b/%s differ\n", rel, rel)
+	default:
+		fmt.Print(unifiedDiff(oldName, rel, string(old), string(content)))
+	}
+	fmt.Println()
+}
+
+// dryRunPush describes every event push would send: the outbox, preceded
+// with all by a version of each staged or modified tracked file, as
+// stageModified would pick them.
+func (c *Client) dryRunPush(cfg *Config, all bool) (int, error) {
+	n := 0
+	if all {
+		idx, err := c.Repo.Index()
+		if err != nil {
+			return 0, err
+		}
+		patterns, err := c.Repo.ignorePatterns()
+		if err != nil {
+			return 0, err
+		}
+		paths := append([]string(nil), idx.Staged...)
+		for _, rel := range idx.Paths() {
+			if _, err := os.Stat(c.Repo.Abs(rel)); err != nil || ignored(patterns, rel) || contains(paths, rel) {
+				continue
+			}
+			changed, err := c.Repo.changedSince(idx.Files[rel], rel)
+			if err != nil {
+				return n, err
+			}
+			if changed {
+				paths = append(paths, rel)
+			}
+		}
+		for _, rel := range paths {
+			fc, err := c.commitFile(cfg, c.Repo.Abs(rel), "")
+			if err != nil {
+				return n, fmt.Errorf("%s: %w", rel, err)
+			}
+			c.describeEvent(fc.ev, fc.raw)
+			n++
+		}
+	}
+	events, err := c.Repo.Outbox()
+	if err != nil {
+		return n, err
+	}
+	for _, ev := range events {
+		c.describeEvent(ev, nil)
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// Line-ending policies for the "eol" config setting. Published content always
+// uses LF; the policy decides what is written to disk on checkout.
+const (
+	eolLF     = "lf"
+	eolCRLF   = "crlf"
+	eolNative = "native"
+)
+
+// normalizeEOL converts CRLF line endings in text content to LF before
+// publishing. Binary content and an empty policy leave content untouched.
+func normalizeEOL(content []byte, policy string) []byte {
+	if policy == "" || !orbi.IsText(content) {
+		return content
+	}
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// wouldNormalize reports whether publishing content under policy would change
+// its line endings.
+func wouldNormalize(content []byte, policy string) bool {
+	return policy != "" && orbi.IsText(content) && bytes.Contains(content, []byte("\r\n"))
+}
+
+// applyEOL converts published LF content to the line endings policy asks for
+// when writing it to disk.
+func applyEOL(content []byte, policy string) []byte {
+	if policy == eolNative {
+		policy = eolLF
+		if runtime.GOOS == "windows" {
+			policy = eolCRLF
+		}
+	}
+	if policy != eolCRLF || !orbi.IsText(content) {
+		return content
+	}
+	lf := bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(lf, []byte("\n"), []byte("\r\n"))
+}
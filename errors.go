@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// Error classes returned throughout orbi. Callers should test for them with
+// errors.Is rather than matching on message text.
+var (
+	// ErrNoKey means no usable secret key could be loaded.
+	ErrNoKey = errors.New("no usable secret key")
+	// ErrRelayRejected means a relay refused an event or no relay accepted it.
+	ErrRelayRejected = orbi.ErrRelayRejected
+	// ErrNotFound means a file, event or other object does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the local and remote state have diverged.
+	ErrConflict = errors.New("conflict")
+	// ErrUntrusted means an event was signed by an author that isn't allowed.
+	ErrUntrusted = errors.New("untrusted author")
+	// ErrTooLarge means an event exceeds what the relays will accept.
+	ErrTooLarge = errors.New("event too large")
+	// ErrUnsupported means an event uses a format this version can't read.
+	ErrUnsupported = errors.New("unsupported event format")
+	// ErrOffline means no relay could be reached at all.
+	ErrOffline = errors.New("no relay reachable")
+)
+
+// RelayError records which relay an operation failed on.
+type RelayError struct {
+	URL string
+	Err error
+}
+
+func (e *RelayError) Error() string {
+	return e.URL + ": " + e.Err.Error()
+}
+
+func (e *RelayError) Unwrap() error {
+	return e.Err
+}
+
+// exitCode maps an error class to the process exit status used by the CLI.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrNoKey):
+		return 2
+	case errors.Is(err, ErrNotFound):
+		return 3
+	case errors.Is(err, ErrRelayRejected):
+		return 4
+	case errors.Is(err, ErrConflict):
+		return 5
+	case errors.Is(err, ErrUntrusted):
+		return 6
+	case errors.Is(err, ErrTooLarge):
+		return 7
+	case errors.Is(err, ErrUnsupported):
+		return 8
+	case errors.Is(err, ErrOffline):
+		return 9
+	default:
+		return 1
+	}
+}
+
+// fatal logs err and exits with the status matching its class.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(exitCode(err))
+}
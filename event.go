@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// readEventContent reverses the transformations recorded in ev's tags and
+// returns the original file bytes, downloading them when the event points to
+// a blob, with progress reported to obs. sk is only needed for encrypted
+// events. Deltas need the versions they are based on and are read with
+// Client.eventContent instead.
+func readEventContent(ev *nostr.Event, sk string, obs Observer) ([]byte, error) {
+	if err := checkEventFormat(ev); err != nil {
+		return nil, err
+	}
+	if ev.Tags.Find("storage") != nil {
+		return fetchEventBlob(ev, obs)
+	}
+	if ev.Tags.Find("delta") != nil {
+		return nil, fmt.Errorf("event %s is a delta against another version", ev.ID)
+	}
+	return orbi.DecodeContent(ev, sk)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	fetchDirName = "fetch"
+	// fetchPageSize is how many events are requested per page; relays cap
+	// their responses, so large histories take several.
+	fetchPageSize = 500
+)
+
+// fetchCheckpoint is the progress of an interrupted fetch, stored in
+// .orbi/fetch/<name>.json next to the events received so far in
+// .orbi/fetch/<name>/.
+type fetchCheckpoint struct {
+	Filter nostr.Filter           `json:"filter"`
+	Relays map[string]*relayPager `json:"relays"`
+}
+
+// relayPager tracks paging through one relay from newest to oldest.
+type relayPager struct {
+	Until nostr.Timestamp `json:"until,omitempty"`
+	Done  bool            `json:"done,omitempty"`
+}
+
+func (r *Repo) fetchPath(name string) string {
+	return filepath.Join(r.dir(), fetchDirName, name)
+}
+
+// loadCheckpoint returns the saved progress of the named fetch, or nil.
+func (r *Repo) loadCheckpoint(name string) (*fetchCheckpoint, error) {
+	content, err := ioutil.ReadFile(r.fetchPath(name) + ".json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cp := &fetchCheckpoint{}
+	if err := json.Unmarshal(content, cp); err != nil {
+		return nil, fmt.Errorf("invalid fetch checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+func (r *Repo) saveCheckpoint(name string, cp *fetchCheckpoint) error {
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := r.fetchPath(name) + ".json.tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.fetchPath(name)+".json")
+}
+
+// clearCheckpoint removes the named fetch's progress and stored events.
+func (r *Repo) clearCheckpoint(name string) error {
+	if err := os.RemoveAll(r.fetchPath(name)); err != nil {
+		return err
+	}
+	err := os.Remove(r.fetchPath(name) + ".json")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// fetchedEvents reads back the events stored by the named fetch.
+func (r *Repo) fetchedEvents(name string) ([]*nostr.Event, error) {
+	files, err := ioutil.ReadDir(r.fetchPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var events []*nostr.Event
+	for _, f := range files {
+		content, err := ioutil.ReadFile(filepath.Join(r.fetchPath(name), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ev := &nostr.Event{}
+		if err := json.Unmarshal(content, ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// fetchCheckpointed pages through every relay's events matching filter,
+// saving progress after each page under name. If resume is set and an
+// earlier fetch with the same name was interrupted, it continues from where
+// that one stopped; otherwise any old progress is discarded. The caller
+// clears the checkpoint once it has used the events.
+func (c *Client) fetchCheckpointed(name string, filter nostr.Filter, resume bool) ([]*nostr.Event, error) {
+	c.Repo.mu.Lock()
+	if err := c.Repo.checkFormat(); err != nil {
+		c.Repo.mu.Unlock()
+		return nil, err
+	}
+	c.Repo.mu.Unlock()
+
+	cp, err := c.Repo.loadCheckpoint(name)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil && !resume {
+		if err := c.Repo.clearCheckpoint(name); err != nil {
+			return nil, err
+		}
+		cp = nil
+	}
+	if cp == nil {
+		if resume {
+			return nil, fmt.Errorf("no interrupted fetch to continue: %w", ErrNotFound)
+		}
+		cp = &fetchCheckpoint{Filter: filter, Relays: make(map[string]*relayPager)}
+	} else {
+		slog.Info("Resuming interrupted fetch")
+	}
+	if err := os.MkdirAll(c.Repo.fetchPath(name), 0755); err != nil {
+		return nil, err
+	}
+
+	for _, url := range c.ReadRelays() {
+		pager, ok := cp.Relays[url]
+		if !ok {
+			pager = &relayPager{}
+			cp.Relays[url] = pager
+		}
+		for !pager.Done {
+			f := cp.Filter
+			f.Limit = fetchPageSize
+			if pager.Until > 0 {
+				until := pager.Until
+				f.Until = &until
+			}
+			var events []*nostr.Event
+			err := c.withRetry(url, func() (err error) {
+				ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+				defer cancel()
+				events, err = c.Transport.Fetch(ctx, url, f)
+				return err
+			})
+			if err != nil {
+				slog.Warn("Failed to query relay", "relay", url, "err", err)
+				break
+			}
+			slog.Debug("Fetched page", "relay", url, "events", len(events))
+			oldest := pager.Until
+			fresh := 0
+			for _, ev := range events {
+				c.Observer.OnFetch(url, ev)
+				c.Repo.cacheEvent(ev)
+				path := filepath.Join(c.Repo.fetchPath(name), ev.ID+".json")
+				if _, err := os.Stat(path); err == nil {
+					continue
+				}
+				content, err := json.Marshal(ev)
+				if err != nil {
+					return nil, err
+				}
+				if err := ioutil.WriteFile(path, content, 0644); err != nil {
+					return nil, err
+				}
+				fresh++
+				if oldest == 0 || ev.CreatedAt < oldest {
+					oldest = ev.CreatedAt
+				}
+			}
+			// The next page starts at the oldest timestamp seen, which may
+			// repeat a few events; a page with nothing new means the end.
+			if len(events) < fetchPageSize || fresh == 0 {
+				pager.Done = true
+			}
+			pager.Until = oldest
+			if err := c.Repo.saveCheckpoint(name, cp); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c.Repo.fetchedEvents(name)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// eventParents returns the ids of the versions ev was built on.
+func eventParents(ev *nostr.Event) []string {
+	var parents []string
+	for tag := range ev.Tags.FindAll("e") {
+		if len(tag) >= 4 && tag[3] == "parent" {
+			parents = append(parents, tag[1])
+		}
+	}
+	return parents
+}
+
+// forkHeads returns the versions in events that no other version builds on,
+// oldest first. More than one head means the history of the file forked.
+func forkHeads(events []*nostr.Event) []*nostr.Event {
+	superseded := make(map[string]bool)
+	for _, ev := range events {
+		for _, p := range eventParents(ev) {
+			superseded[p] = true
+		}
+	}
+	var heads []*nostr.Event
+	for _, ev := range events {
+		if !superseded[ev.ID] {
+			heads = append(heads, ev)
+		}
+	}
+	sort.Slice(heads, func(i, j int) bool { return before(heads[i], heads[j]) })
+	return heads
+}
+
+// fileHeads fetches every version of rel and returns its heads.
+func (c *Client) fileHeads(rel string) []*nostr.Event {
+	return forkHeads(c.query(nostr.Filter{
+		Kinds:   c.kinds("").files(),
+		Authors: c.headAuthors(),
+		Tags:    nostr.TagMap{"f": []string{c.pathTag(rel)}},
+	}))
+}
+
+// resolveFork publishes filePath as a version whose parents are all of
+// heads, so the divergent chains converge. When pick is set its content
+// replaces the working copy first.
+func (c *Client) resolveFork(filePath, rel string, heads []*nostr.Event, pick *nostr.Event, message string) (*nostr.Event, error) {
+	if pick != nil {
+		content, err := c.eventContent(pick)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Repo.WriteFile(rel, content, 0644); err != nil {
+			return nil, err
+		}
+	}
+	// The resolution builds on the heads, not on whatever was checked out.
+	if err := c.Repo.UpdateIndex(func(idx *orbi.Index) error {
+		if entry, ok := idx.Files[rel]; ok {
+			entry.EventID = heads[0].ID
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	var opts []orbi.EventOption
+	for _, h := range heads {
+		opts = append(opts, orbi.WithParent(h.ID))
+	}
+	force := c.Force
+	c.Force = true
+	defer func() { c.Force = force }()
+	return c.PublishFile(filePath, message, opts...)
+}
+
+func cmdResolve(args []string) error {
+	fs := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	pick := fs.String("pick", "", "resolve to the content of this head")
+	ours := fs.Bool("ours", false, "resolve to the working copy, e.g. after merging by hand")
+	getMessage := messageFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: orbi resolve [--pick <event-id> | --ours] [-m <message>] <file>")
+	}
+	if *pick != "" && *ours {
+		return fmt.Errorf("--pick and --ours are mutually exclusive")
+	}
+	message, err := getMessage()
+	if err != nil {
+		return err
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	filePath := expandPath(positional[0])
+	rel, err := client.Repo.Rel(filePath)
+	if err != nil {
+		return err
+	}
+	heads := client.fileHeads(rel)
+	if len(heads) < 2 {
+		fmt.Printf("%s has not forked\n", rel)
+		return nil
+	}
+
+	var chosen *nostr.Event
+	if *pick != "" {
+		for _, h := range heads {
+			if h.ID == *pick {
+				chosen = h
+			}
+		}
+		if chosen == nil {
+			return fmt.Errorf("%s is not a head of %s: %w", *pick, rel, ErrNotFound)
+		}
+	} else if !*ours {
+		fmt.Printf("%s has %d competing versions:\n", rel, len(heads))
+		for _, h := range heads {
+			fmt.Printf("  %s  %s  %s\n", h.ID, h.CreatedAt.Time().Format(time.RFC3339), eventMessage(h))
+		}
+		fmt.Fprintln(os.Stderr, "Choose one with --pick <event-id>, or merge them by hand and use --ours.")
+		return fmt.Errorf("%w: %s has forked", ErrConflict, rel)
+	}
+	if message == "" {
+		message = fmt.Sprintf("Resolve fork of %s", rel)
+	}
+	ev, err := client.resolveFork(filePath, rel, heads, chosen, message)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Resolved %d versions of %s into %s\n", len(heads), rel, ev.ID)
+	return nil
+}
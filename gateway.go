@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var badgeColors = map[string]string{
+	ciSuccess: "#4c1",
+	ciFailure: "#e05d44",
+	ciPending: "#dfb317",
+	"":        "#9f9f9f",
+}
+
+// badgeSVG renders a flat two-part status badge.
+func badgeSVG(label, state string) string {
+	value := state
+	if value == "" {
+		value = "unknown"
+	}
+	lw, vw := 6*len(label)+10, 6*len(value)+10
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">
+<rect width="%[2]d" height="20" fill="#555"/>
+<rect x="%[2]d" width="%[5]d" height="20" fill="%[6]s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,sans-serif" font-size="11">
+<text x="%[7]d" y="14">%[3]s</text>
+<text x="%[8]d" y="14">%[4]s</text>
+</g>
+</svg>
+`, lw+vw, lw, label, value, vw, badgeColors[state], lw/2, lw+vw/2)
+}
+
+// handleBadge serves /badge/<event-id>.svg with the combined CI state of a
+// commit event by one of the repository's authors.
+func (c *Client) handleBadge(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/badge/"), ".svg")
+		if !nostr.IsValid32ByteHex(id) {
+			http.Error(w, "invalid event id", http.StatusBadRequest)
+			return
+		}
+		commits := c.query(nostr.Filter{IDs: []string{id}})
+		if len(commits) == 0 || !validSignature(commits[0]) || !c.signsFor(commits[0].PubKey, c.pullAuthors(cfg)) {
+			http.Error(w, "event not found", http.StatusNotFound)
+			return
+		}
+		statuses := c.ciStatuses([]string{id}, c.ciTrusted(cfg))[id]
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "no-cache, max-age=60")
+		fmt.Fprint(w, badgeSVG("orbi ci", combinedState(statuses)))
+	}
+}
+
+func cmdGateway(args []string) error {
+	fs := flag.NewFlagSet("gateway", flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:8080", "address to serve HTTP on")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	// The gateway only reads from relays, so it can run without a key. It
+	// then only trusts the origin, collaborators and CI signers.
+	client, err := newCLIClient()
+	if errors.Is(err, ErrNoKey) {
+		repo := openRepo(".")
+		var cfg *Config
+		if cfg, err = repo.Config(); err == nil {
+			client, err = newConfiguredClient(repo, cfg, "", "")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/badge/", client.handleBadge(cfg))
+	slog.Info("Serving gateway", "url", "http://"+*listen)
+	return http.ListenAndServe(*listen, mux)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	defaultGitHubAPI = "https://api.github.com"
+	githubTokenEnv   = "GITHUB_TOKEN"
+)
+
+// githubClient is a minimal GitHub REST API client for one repository.
+type githubClient struct {
+	api   string
+	token string
+	repo  string // owner/name
+}
+
+func newGitHubClient(api, repo string) (*githubClient, error) {
+	if strings.Count(repo, "/") != 1 {
+		return nil, fmt.Errorf("invalid GitHub repository %q: expected owner/name", repo)
+	}
+	token := os.Getenv(githubTokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("set %s to a GitHub token with access to %s", githubTokenEnv, repo)
+	}
+	return &githubClient{api: strings.TrimSuffix(api, "/"), token: token, repo: repo}, nil
+}
+
+// do sends a request for path (relative to the repository unless it starts
+// with a slash) and decodes the JSON response into out when it is non-nil.
+func (g *githubClient) do(method, path string, body, out interface{}) error {
+	url := g.api + "/repos/" + g.repo + path
+	if strings.HasPrefix(path, "/") {
+		url = g.api + path
+	}
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := (&http.Client{Timeout: httpTimeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("GitHub %s: %w", path, ErrNotFound)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("GitHub %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type githubCommit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+			Date  string `json:"date"`
+		} `json:"author"`
+	} `json:"commit"`
+	Files []struct {
+		Filename string `json:"filename"`
+		Status   string `json:"status"`
+	} `json:"files"`
+}
+
+type githubContent struct {
+	SHA      string `json:"sha"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+type githubUser struct {
+	Login   string `json:"login"`
+	HTMLURL string `json:"html_url"`
+}
+
+type githubIssue struct {
+	ID          int64      `json:"id"`
+	Number      int        `json:"number"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body"`
+	State       string     `json:"state"`
+	HTMLURL     string     `json:"html_url"`
+	CreatedAt   string     `json:"created_at"`
+	User        githubUser `json:"user"`
+	PullRequest *struct{}  `json:"pull_request"`
+	Labels      []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+type githubComment struct {
+	ID        int64      `json:"id"`
+	Body      string     `json:"body"`
+	HTMLURL   string     `json:"html_url"`
+	CreatedAt string     `json:"created_at"`
+	User      githubUser `json:"user"`
+}
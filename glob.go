@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// globRegexp compiles a gitignore-style pattern into a regular expression
+// over slash-separated repo-relative paths:
+//
+//   - a leading "/" anchors the pattern to the repository root, as does any
+//     "/" in the middle; otherwise it matches at any depth
+//   - a trailing "/" matches a directory and everything below it
+//   - "*" and "?" don't cross "/", "**" does
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	dir := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.HasPrefix(pattern, "/") || strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	if dir {
+		b.WriteString("/.*$")
+	} else {
+		b.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(b.String())
+}
+
+// matchGlob reports whether the repo-relative path rel matches pattern.
+func matchGlob(pattern, rel string) bool {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(path.Clean(rel))
+}
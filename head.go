@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// headAuthors are the keys whose file events make up this repository's
+// history as seen from this client.
+func (c *Client) headAuthors() []string {
+	authors := []string{c.pk}
+	if c.identity != "" {
+		authors = append(authors, c.identity)
+	}
+	return append(authors, c.previousKeys...)
+}
+
+// remoteHead returns the newest version of rel on the current branch on the
+// relays by any of the repository's authors, or nil if it has never been
+// published there.
+func (c *Client) remoteHead(cfg *Config, rel string) *nostr.Event {
+	var head *nostr.Event
+	for _, ev := range c.versions(rel, c.pullAuthors(cfg)) {
+		if head == nil || before(head, ev) {
+			head = ev
+		}
+	}
+	return head
+}
+
+// checkHead refuses to publish rel on top of parent, the locally recorded
+// version, when the relays have a different newer version. A remote head
+// older than parent only means the relays asked never got parent, as when
+// it went to another relay group, and is not a conflict. When parent
+// cannot be found to compare, publishing is refused as well. Versions by
+// collaborators count, since pull would bring them in.
+func (c *Client) checkHead(cfg *Config, rel, parent string) error {
+	head := c.remoteHead(cfg, rel)
+	if head == nil || head.ID == parent {
+		return nil
+	}
+	if parent == "" {
+		return fmt.Errorf("%w: %s was already published as %s; pull first or use --force", ErrConflict, rel, head.ID)
+	}
+	parentEv, err := c.eventByID(parent)
+	if err != nil {
+		return fmt.Errorf("%w: cannot find %s, the recorded version of %s, to compare with %s on the relays (%v); pull first or use --force", ErrConflict, parent, rel, head.ID, err)
+	}
+	if !before(parentEv, head) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s has advanced to %s since %s; pull first or use --force", ErrConflict, rel, head.ID, parent)
+}
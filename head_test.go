@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+func TestCheckHead(t *testing.T) {
+	author, _ := newTestClient(t)
+	v1 := testEvent(t, author, "a.txt", "one\n", 1700000000)
+	v2 := testEvent(t, author, "a.txt", "two\n", 1700000001, orbi.WithParent(v1.ID))
+	v3 := testEvent(t, author, "a.txt", "three\n", 1700000002, orbi.WithParent(v2.ID))
+	collaborator, _ := newTestClient(t)
+	stranger, _ := newTestClient(t)
+	byCollaborator := testEvent(t, collaborator, "a.txt", "theirs\n", 1700000003, orbi.WithParent(v2.ID))
+	byStranger := testEvent(t, stranger, "a.txt", "spam\n", 1700000003, orbi.WithParent(v2.ID))
+
+	tests := []struct {
+		name      string
+		published []*nostr.Event // on the relay
+		queued    []*nostr.Event // in the outbox only
+		parent    string
+		conflict  bool
+	}{
+		{"never published", nil, nil, "", false},
+		{"new file already published", []*nostr.Event{v1}, nil, "", true},
+		{"up to date", []*nostr.Event{v1, v2}, nil, v2.ID, false},
+		{"relay has a newer version", []*nostr.Event{v1, v2}, nil, v1.ID, true},
+		{"relay missed the parent", []*nostr.Event{v1}, []*nostr.Event{v2}, v2.ID, false},
+		{"relay missed the parent and has a newer version", []*nostr.Event{v1, v3}, []*nostr.Event{v2}, v2.ID, true},
+		{"parent unavailable", []*nostr.Event{v1}, nil, v2.ID, true},
+		{"collaborator published a newer version", []*nostr.Event{v1, v2, byCollaborator}, nil, v2.ID, true},
+		{"stranger published a newer version", []*nostr.Event{v1, v2, byStranger}, nil, v2.ID, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, transport := newTestClient(t)
+			c.sk, c.pk = author.sk, author.pk
+			cfg, err := c.Repo.Config()
+			if err != nil {
+				t.Fatal(err)
+			}
+			npub, _ := nip19.EncodePublicKey(collaborator.pk)
+			cfg.Collaborators = []string{npub}
+			for _, ev := range tt.published {
+				if err := transport.Publish(context.Background(), testRelayURL, *ev); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, ev := range tt.queued {
+				if err := c.Repo.addToOutbox(ev); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = c.checkHead(cfg, "a.txt", tt.parent)
+			if got := errors.Is(err, ErrConflict); got != tt.conflict {
+				t.Errorf("got %v, want conflict=%v", err, tt.conflict)
+			}
+			if err != nil && !tt.conflict {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
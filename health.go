@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// healthFileName records how well each relay has been doing, so the relays
+// that keep failing are tried last.
+const healthFileName = "relay_health.json"
+
+const (
+	// unhealthyFailures is how many failures in a row make a relay
+	// unhealthy.
+	unhealthyFailures = 3
+	// healthCooldown is how long after its last failure an unhealthy relay
+	// gets another full chance.
+	healthCooldown = time.Hour
+	// latencyWeight is the weight of the newest sample in the moving average
+	// of a relay's latency.
+	latencyWeight = 0.3
+)
+
+// RelayHealth is the track record of one relay.
+type RelayHealth struct {
+	Successes int `json:"successes"`
+	Failures  int `json:"failures"`
+	// Consecutive counts the failures since the last success.
+	Consecutive int `json:"consecutive_failures,omitempty"`
+	// LatencyMS is a moving average of successful operations, in
+	// milliseconds.
+	LatencyMS   float64 `json:"latency_ms,omitempty"`
+	LastFailure int64   `json:"last_failure,omitempty"`
+}
+
+// score is the relay's success rate, smoothed so that relays without a
+// record start in the middle.
+func (h *RelayHealth) score() float64 {
+	return float64(h.Successes+1) / float64(h.Successes+h.Failures+2)
+}
+
+// unhealthy reports whether the relay has been failing recently.
+func (h *RelayHealth) unhealthy() bool {
+	return h.Consecutive >= unhealthyFailures && time.Since(time.Unix(h.LastFailure, 0)) < healthCooldown
+}
+
+func (r *Repo) healthPath() string {
+	return filepath.Join(r.dir(), healthFileName)
+}
+
+// RelayHealth returns the recorded health of every relay by URL.
+func (r *Repo) RelayHealth() (map[string]*RelayHealth, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.relayHealth()
+}
+
+func (r *Repo) relayHealth() (map[string]*RelayHealth, error) {
+	health := make(map[string]*RelayHealth)
+	content, err := ioutil.ReadFile(r.healthPath())
+	if os.IsNotExist(err) {
+		return health, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &health); err != nil {
+		// The record is only advisory; start over rather than fail.
+		slog.Debug("Ignoring unreadable relay health", "err", err)
+		return make(map[string]*RelayHealth), nil
+	}
+	return health, nil
+}
+
+// recordRelayResult adds the outcome of one operation on url to its health.
+// Nothing is recorded outside a repository.
+func (r *Repo) recordRelayResult(url string, err error, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, statErr := os.Stat(r.dir()); statErr != nil {
+		return
+	}
+	health, loadErr := r.relayHealth()
+	if loadErr != nil {
+		return
+	}
+	h, ok := health[url]
+	if !ok {
+		h = &RelayHealth{}
+		health[url] = h
+	}
+	if err != nil {
+		h.Failures++
+		h.Consecutive++
+		h.LastFailure = time.Now().Unix()
+	} else {
+		h.Successes++
+		h.Consecutive = 0
+		ms := float64(elapsed) / float64(time.Millisecond)
+		if h.LatencyMS == 0 {
+			h.LatencyMS = ms
+		} else {
+			h.LatencyMS = latencyWeight*ms + (1-latencyWeight)*h.LatencyMS
+		}
+	}
+	content, jsonErr := json.MarshalIndent(health, "", "  ")
+	if jsonErr != nil {
+		return
+	}
+	if err := ioutil.WriteFile(r.healthPath(), append(content, '\n'), 0644); err != nil {
+		slog.Debug("Failed to record relay health", "err", err)
+	}
+}
+
+// ResetRelayHealth forgets every relay's track record.
+func (r *Repo) ResetRelayHealth() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.Remove(r.healthPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// byHealth returns relays ordered healthiest first: unhealthy relays last,
+// then by success rate and latency. Relays without a record keep their
+// configured order among equals.
+func (c *Client) byHealth(relays []string) []string {
+	health, err := c.Repo.RelayHealth()
+	if err != nil || len(health) == 0 {
+		return relays
+	}
+	get := func(url string) *RelayHealth {
+		if h, ok := health[url]; ok {
+			return h
+		}
+		return &RelayHealth{}
+	}
+	sorted := append([]string(nil), relays...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := get(sorted[i]), get(sorted[j])
+		if a.unhealthy() != b.unhealthy() {
+			return !a.unhealthy()
+		}
+		if a.score() != b.score() {
+			return a.score() > b.score()
+		}
+		return a.LatencyMS < b.LatencyMS
+	})
+	return sorted
+}
+
+// unhealthyRelay reports whether url has been failing recently.
+func (c *Client) unhealthyRelay(url string) bool {
+	health, err := c.Repo.RelayHealth()
+	if err != nil {
+		return false
+	}
+	h, ok := health[url]
+	return ok && h.unhealthy()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// parsePubkey accepts an npub or a hex public key.
+func parsePubkey(s string) (string, error) {
+	if nostr.IsValidPublicKey(s) {
+		return s, nil
+	}
+	prefix, decoded, err := nip19.Decode(s)
+	if err != nil || prefix != "npub" {
+		return "", fmt.Errorf("invalid public key %q", s)
+	}
+	return decoded.(string), nil
+}
+
+// History returns every file event published by author since the given time,
+// oldest first. An empty author means the client's own key.
+func (c *Client) History(author string, since nostr.Timestamp) []*nostr.Event {
+	if author == "" {
+		author = c.pk
+	}
+	filter := nostr.Filter{
+		Kinds:   c.kinds(author).files(),
+		Authors: []string{author},
+	}
+	if since > 0 {
+		filter.Since = &since
+	}
+	events := c.query(filter)
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CreatedAt != events[j].CreatedAt {
+			return events[i].CreatedAt < events[j].CreatedAt
+		}
+		return events[i].ID < events[j].ID
+	})
+	return events
+}
+
+// eventPath returns the repo-relative path in a file event's "f" tag, with
+// slashes as separators whatever system published it.
+func eventPath(ev *nostr.Event) string {
+	if tag := ev.Tags.Find("f"); tag != nil {
+		return slashPath(tag[1])
+	}
+	return ""
+}
+
+// eventMessage returns the commit message in a file event's "m" tag.
+func eventMessage(ev *nostr.Event) string {
+	if tag := ev.Tags.Find("m"); tag != nil {
+		return tag[1]
+	}
+	return ""
+}
+
+// latestByPath keeps the newest event for each path, preferring the higher
+// ID when timestamps tie so the choice is stable.
+func latestByPath(events []*nostr.Event) map[string]*nostr.Event {
+	return latestBy(events, eventPath)
+}
+
+// latestBy is latestByPath with the path of each event given by pathOf.
+func latestBy(events []*nostr.Event, pathOf func(*nostr.Event) string) map[string]*nostr.Event {
+	latest := make(map[string]*nostr.Event)
+	for _, ev := range events {
+		p := pathOf(ev)
+		if p == "" {
+			continue
+		}
+		cur, ok := latest[p]
+		if !ok || ev.CreatedAt > cur.CreatedAt || (ev.CreatedAt == cur.CreatedAt && ev.ID > cur.ID) {
+			latest[p] = ev
+		}
+	}
+	return latest
+}
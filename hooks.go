@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// hooksDirName holds executable hooks, named after the point they run at.
+const hooksDirName = "hooks"
+
+const (
+	// hookPrePublish runs before a file is read for publishing, so it may
+	// rewrite it; failing aborts the publish.
+	hookPrePublish = "pre-publish"
+	// hookPostPublish runs after a version was published.
+	hookPostPublish = "post-publish"
+	// hookPostPull runs after pull wrote a newer version into the working
+	// copy.
+	hookPostPull = "post-pull"
+)
+
+// runHook runs the hook name, if the repository has an executable one, from
+// the repository root with the file, event id and message in ORBI_FILE,
+// ORBI_EVENT_ID and ORBI_MESSAGE.
+func (c *Client) runHook(name, rel, eventID, message string) error {
+	path := filepath.Join(c.Repo.dir(), hooksDirName, name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		slog.Debug("Ignoring hook that is not executable", "hook", path)
+		return nil
+	}
+	cmd := exec.Command(path)
+	cmd.Dir = c.Repo.root
+	cmd.Env = append(os.Environ(),
+		"ORBI_HOOK="+name,
+		"ORBI_FILE="+rel,
+		"ORBI_EVENT_ID="+eventID,
+		"ORBI_MESSAGE="+message,
+	)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	slog.Debug("Running hook", "hook", name, "file", rel)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook: %w", name, err)
+	}
+	return nil
+}
+
+// runPostHook runs a hook whose failure can't undo what already happened,
+// so it is only reported.
+func (c *Client) runPostHook(name, rel string, ev *nostr.Event) {
+	if err := c.runHook(name, rel, ev.ID, c.fileMessage(ev)); err != nil {
+		slog.Warn("Hook failed", "file", rel, "err", err)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// identityFlag is the profile named with --identity.
+var identityFlag string
+
+// identitiesDir holds one secret key file per identity profile, named after
+// the profile.
+func identitiesDir() string {
+	return filepath.Join(filepath.Dir(globalConfigPath()), "identities")
+}
+
+// validProfileName rejects names that can't be a plain file name.
+func validProfileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid identity name %q", name)
+	}
+	return nil
+}
+
+// profileKeyPath returns the key file of the named identity profile.
+func profileKeyPath(name string) string {
+	return filepath.Join(identitiesDir(), name)
+}
+
+// activeProfile returns the identity profile in use: --identity, or the
+// default of the repository in the current directory. It is empty when
+// neither names one.
+func activeProfile() string {
+	if identityFlag != "" {
+		return identityFlag
+	}
+	if os.Getenv(nostrSecretPathEnvVar) != "" {
+		return ""
+	}
+	cfg, err := openRepo(".").Config()
+	if err != nil {
+		return ""
+	}
+	return cfg.IdentityProfile
+}
+
+func cmdIdentity(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			return cmdIdentityList(args[1:])
+		case "add":
+			return cmdIdentityAdd(args[1:])
+		case "use":
+			return cmdIdentityUse(args[1:])
+		}
+	}
+	return fmt.Errorf("usage: orbi identity list|add|use")
+}
+
+func cmdIdentityList(args []string) error {
+	fs := flag.NewFlagSet("identity list", flag.ContinueOnError)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	entries, err := ioutil.ReadDir(identitiesDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	active := activeProfile()
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		marker := " "
+		if name == active {
+			marker = "*"
+		}
+		path := profileKeyPath(name)
+		content, err := ioutil.ReadFile(path)
+		var desc string
+		switch {
+		case err != nil:
+			desc = err.Error()
+		case orbi.IsEncryptedKey(string(content)):
+			desc = "(encrypted)"
+		case isBunkerURI(string(content)):
+			desc = "(remote signer)"
+		default:
+			if _, pk, err := readSecretKey(path); err != nil {
+				desc = err.Error()
+			} else {
+				desc, _ = nip19.EncodePublicKey(pk)
+			}
+		}
+		fmt.Printf("%s %-16s %s\n", marker, name, desc)
+	}
+	return nil
+}
+
+func cmdIdentityAdd(args []string) error {
+	fs := flag.NewFlagSet("identity add", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: orbi identity add <name> <key-file>")
+	}
+	name := positional[0]
+	if err := validProfileName(name); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(expandPath(positional[1]))
+	if err != nil {
+		return err
+	}
+	if !orbi.IsEncryptedKey(string(content)) && !isBunkerURI(string(content)) {
+		if _, _, err := readSecretKey(expandPath(positional[1])); err != nil {
+			return err
+		}
+	}
+	path := profileKeyPath(name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("identity %s already exists", name)
+	}
+	if err := os.MkdirAll(identitiesDir(), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Added identity %s\n", name)
+	return nil
+}
+
+func cmdIdentityUse(args []string) error {
+	fs := flag.NewFlagSet("identity use", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: orbi identity use <name>")
+	}
+	name := positional[0]
+	if err := validProfileName(name); err != nil {
+		return err
+	}
+	if _, err := os.Stat(profileKeyPath(name)); err != nil {
+		return fmt.Errorf("identity %s: %w", name, ErrNotFound)
+	}
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	cfg.IdentityProfile = name
+	if err := repo.SaveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Printf("This repository now uses identity %s\n", name)
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName is the file at the repository root listing gitignore-style
+// patterns of files orbi leaves alone.
+const ignoreFileName = ".orbiignore"
+
+// ignorePatterns returns the patterns of files to leave alone: editor scratch
+// files, then the lines of .orbiignore. Blank lines and lines starting with
+// "#" are skipped.
+func (r *Repo) ignorePatterns() ([]string, error) {
+	patterns := append([]string(nil), defaultWatchIgnore...)
+	f, err := os.Open(filepath.Join(r.root, ignoreFileName))
+	if os.IsNotExist(err) {
+		return patterns, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// ignored reports whether rel matches patterns. As in gitignore, the last
+// matching pattern wins and a leading "!" re-includes what an earlier
+// pattern excluded.
+func ignored(patterns []string, rel string) bool {
+	result := false
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if matchGlob(p[1:], rel) {
+				result = false
+			}
+		} else if matchGlob(p, rel) {
+			result = true
+		}
+	}
+	return result
+}
+
+// walkFiles calls fn with the repo-relative path of every regular file under
+// dir, skipping hidden directories such as .orbi and .git.
+func (r *Repo) walkFiles(dir string, fn func(rel string) error) error {
+	root, err := filepath.Abs(r.root)
+	if err != nil {
+		return err
+	}
+	start, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != start && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel))
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// gitChange is a file a git commit added or modified.
+type gitChange struct {
+	path string
+	mode string
+	blob string
+}
+
+// gitCommit is a commit being imported.
+type gitCommit struct {
+	sha         string
+	time        time.Time
+	authorName  string
+	authorEmail string
+	message     string
+}
+
+// gitImporter publishes the history of a git repository as file events.
+type gitImporter struct {
+	c        *Client
+	dir      string
+	ignore   []string
+	imported int
+}
+
+// git runs git in the repository being imported and returns its output.
+func (g *gitImporter) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", g.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// commit reads the metadata of the commit sha.
+func (g *gitImporter) commit(sha string) (*gitCommit, error) {
+	out, err := g.git("show", "-s", "--format=%H%x00%at%x00%an%x00%ae%x00%B", sha)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(string(out), "\x00", 5)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("unexpected output from git show for %s", sha)
+	}
+	at, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: invalid author time %q", sha, fields[1])
+	}
+	return &gitCommit{
+		sha:         fields[0],
+		time:        time.Unix(at, 0),
+		authorName:  fields[2],
+		authorEmail: fields[3],
+		message:     strings.TrimSpace(fields[4]),
+	}, nil
+}
+
+// changes returns the files sha added or modified relative to its first
+// parent. Deletions are reported and skipped.
+func (g *gitImporter) changes(sha string) ([]gitChange, error) {
+	out, err := g.git("diff-tree", "-r", "--root", "--no-renames", "--no-commit-id", "-z", sha)
+	if err != nil {
+		return nil, err
+	}
+	// Each entry is ":<old mode> <new mode> <old blob> <new blob> <status>"
+	// followed by the path, both NUL-terminated.
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	var changes []gitChange
+	for i := 0; i+1 < len(fields); i += 2 {
+		meta := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(meta) != 5 {
+			return nil, fmt.Errorf("unexpected output from git diff-tree for %s", sha)
+		}
+		if meta[4] == "D" {
+			slog.Info("Skipping file deleted in git", "file", fields[i+1], "commit", short(sha))
+			continue
+		}
+		changes = append(changes, gitChange{path: fields[i+1], mode: meta[1], blob: meta[3]})
+	}
+	return changes, nil
+}
+
+// tree returns every file in the tree of sha.
+func (g *gitImporter) tree(sha string) ([]gitChange, error) {
+	out, err := g.git("ls-tree", "-r", "-z", sha)
+	if err != nil {
+		return nil, err
+	}
+	var files []gitChange
+	for _, entry := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		meta, p, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected output from git ls-tree for %s", sha)
+		}
+		files = append(files, gitChange{path: p, mode: fields[0], blob: fields[2]})
+	}
+	return files, nil
+}
+
+// publish writes every change into the working copy and publishes it with
+// the commit's message, author and time.
+func (g *gitImporter) publish(cm *gitCommit, changes []gitChange) error {
+	opts := []orbi.EventOption{
+		orbi.WithCreatedAt(cm.time),
+		orbi.WithExtraTags(
+			nostr.Tag{"author", cm.authorName, cm.authorEmail},
+			nostr.Tag{"commit", cm.sha},
+		),
+	}
+	for _, ch := range changes {
+		if ch.mode != "100644" && ch.mode != "100755" {
+			slog.Info("Skipping file that is not a regular file", "file", ch.path, "mode", ch.mode)
+			continue
+		}
+		if err := checkRel(ch.path); err != nil {
+			slog.Warn("Skipping file", "file", ch.path, "err", err)
+			continue
+		}
+		if ignored(g.ignore, ch.path) {
+			slog.Debug("Skipping ignored file", "file", ch.path)
+			continue
+		}
+		data, err := g.git("cat-file", "blob", ch.blob)
+		if err != nil {
+			return err
+		}
+		perm := os.FileMode(0644)
+		if ch.mode == "100755" {
+			perm = 0755
+		}
+		if err := g.c.Repo.WriteFile(ch.path, data, perm); err != nil {
+			return err
+		}
+		if err := os.Chmod(g.c.Repo.Abs(ch.path), perm); err != nil {
+			return err
+		}
+		// The working copy was just written; record the commit's time
+		// instead of the import's.
+		if err := os.Chtimes(g.c.Repo.Abs(ch.path), cm.time, cm.time); err != nil {
+			return err
+		}
+		fileOpts := append(opts[:len(opts):len(opts)], orbi.WithFileInfo(perm, cm.time))
+		if _, err := g.c.PublishFile(g.c.Repo.Abs(ch.path), cm.message, fileOpts...); err != nil {
+			return fmt.Errorf("%s: %w", ch.path, err)
+		}
+		g.imported++
+		fmt.Printf("  %s %s\n", short(cm.sha), ch.path)
+	}
+	return nil
+}
+
+// importHistory publishes every commit reachable from rev along its first
+// parents, oldest first, or only the tree of rev when headOnly is set.
+func (g *gitImporter) importHistory(rev string, headOnly bool) error {
+	if headOnly {
+		cm, err := g.commit(rev)
+		if err != nil {
+			return err
+		}
+		files, err := g.tree(cm.sha)
+		if err != nil {
+			return err
+		}
+		return g.publish(cm, files)
+	}
+	out, err := g.git("rev-list", "--reverse", "--first-parent", rev)
+	if err != nil {
+		return err
+	}
+	for _, sha := range strings.Fields(string(out)) {
+		cm, err := g.commit(sha)
+		if err != nil {
+			return err
+		}
+		changes, err := g.changes(sha)
+		if err != nil {
+			return err
+		}
+		if err := g.publish(cm, changes); err != nil {
+			return fmt.Errorf("commit %s: %w", short(sha), err)
+		}
+	}
+	return nil
+}
+
+func cmdImportGit(args []string) error {
+	fs := flag.NewFlagSet("import-git", flag.ContinueOnError)
+	rev := fs.String("rev", "HEAD", "the git revision to import")
+	headOnly := fs.Bool("head-only", false, "import only the files at the revision instead of its history")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: orbi import-git [--rev <rev>] [--head-only] <git-dir>")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	client.Quiet = true
+	patterns, err := client.Repo.ignorePatterns()
+	if err != nil {
+		return err
+	}
+	g := &gitImporter{c: client, dir: positional[0], ignore: patterns}
+	err = g.importHistory(*rev, *headOnly)
+	fmt.Printf("Imported %d file version(s) from %s\n", g.imported, positional[0])
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const indexFileName = "index.json"
+
+// Index reads the tracking index. A repository without one has an empty
+// index.
+func (r *Repo) Index() (*orbi.Index, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, err := r.formatVersion(); err != nil {
+		return nil, err
+	} else if v > 0 {
+		if err := r.checkFormat(); err != nil {
+			return nil, err
+		}
+	}
+	return r.index()
+}
+
+func (r *Repo) index() (*orbi.Index, error) {
+	return orbi.ReadIndex(filepath.Join(r.dir(), indexFileName))
+}
+
+// writeIndex replaces the index atomically.
+func (r *Repo) writeIndex(idx *orbi.Index) error {
+	return orbi.WriteIndex(filepath.Join(r.dir(), indexFileName), idx)
+}
+
+// UpdateIndex loads the index, applies fn and writes the result back while
+// holding the repository lock.
+func (r *Repo) UpdateIndex(fn func(idx *orbi.Index) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	idx, err := r.index()
+	if err != nil {
+		return err
+	}
+	if err := fn(idx); err != nil {
+		return err
+	}
+	return r.writeIndex(idx)
+}
+
+// migrateTrackedFiles converts the newline-delimited tracked_files list used
+// by format 1 into index.json.
+func migrateTrackedFiles(r *Repo) error {
+	path := filepath.Join(r.dir(), trackedFilesFileName)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	idx, err := r.index()
+	if err != nil {
+		return err
+	}
+	for _, f := range strings.Split(string(content), "\n") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if _, ok := idx.Files[f]; !ok {
+			idx.Files[f] = &orbi.IndexEntry{Path: f}
+		}
+	}
+	if err := r.writeIndex(idx); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
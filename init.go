@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// templateFiles resolves a template naddr to the file events it consists of.
+// If the addressed event carries a manifest (like a tag event does) exactly
+// those versions are used; otherwise the newest version of every file the
+// template's author has published.
+func (c *Client) templateFiles(naddr string) (map[string]*nostr.Event, error) {
+	prefix, decoded, err := nip19.Decode(naddr)
+	if err != nil || prefix != "naddr" {
+		return nil, fmt.Errorf("invalid template address %q", naddr)
+	}
+	ptr := decoded.(nostr.EntityPointer)
+	if len(ptr.Relays) > 0 {
+		c.SetRelays(append(ptr.Relays, c.Relays()...))
+	}
+
+	files := make(map[string]*nostr.Event)
+	addressed := c.query(nostr.Filter{
+		Kinds:   []int{ptr.Kind},
+		Authors: []string{ptr.PublicKey},
+		Tags:    nostr.TagMap{"d": []string{ptr.Identifier}},
+	})
+	if len(addressed) > 0 {
+		sort.Slice(addressed, func(i, j int) bool { return addressed[i].CreatedAt > addressed[j].CreatedAt })
+		if entries := parseManifest(addressed[0]); len(entries) > 0 {
+			var ids []string
+			for _, e := range entries {
+				ids = append(ids, e.EventID)
+			}
+			for _, ev := range c.query(nostr.Filter{IDs: ids, Authors: []string{ptr.PublicKey}}) {
+				files[eventPath(ev)] = ev
+			}
+			if len(files) < len(entries) {
+				return nil, fmt.Errorf("template manifest lists %d files but only %d were found: %w", len(entries), len(files), ErrNotFound)
+			}
+			return files, nil
+		}
+	}
+
+	files = latestByPath(c.History(ptr.PublicKey, 0))
+	if len(files) == 0 {
+		return nil, fmt.Errorf("template %s has no files: %w", naddr, ErrNotFound)
+	}
+	return files, nil
+}
+
+// substitute replaces {{key}} placeholders with their values.
+func substitute(content []byte, vars map[string]string) []byte {
+	s := string(content)
+	for k, v := range vars {
+		s = strings.ReplaceAll(s, "{{"+k+"}}", v)
+	}
+	return []byte(s)
+}
+
+// initFromTemplate writes the template's files into the repository with
+// placeholders substituted, then publishes them as fresh history under the
+// client's own key.
+func (c *Client) initFromTemplate(naddr string, vars map[string]string) error {
+	files, err := c.templateFiles(naddr)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		if _, err := os.Stat(c.Repo.Abs(p)); err == nil {
+			return fmt.Errorf("%w: %s already exists", ErrConflict, p)
+		}
+	}
+	for _, p := range paths {
+		content, err := c.eventContent(files[p])
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if orbi.IsText(content) {
+			content = substitute(content, vars)
+		}
+		if err := c.Repo.WriteFile(p, content, fileMode(c.fileMetadata(files[p]))); err != nil {
+			return err
+		}
+		fmt.Printf("Created %s\n", p)
+	}
+	for _, p := range paths {
+		if _, err := c.PublishFile(c.Repo.Abs(p), "Initialize from template"); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// initConfig builds the config of a new repository from the init flags.
+// Without relays of its own or in the global config it lists the default
+// relays, so they can be seen and edited.
+func initConfig(name string, relays []string, identity, storage string, servers []string) (*Config, error) {
+	cfg := &Config{Name: name, IdentityProfile: identity, Storage: storage, BlossomServers: servers}
+	for _, s := range relays {
+		r, err := parseRelayURL(s)
+		if err != nil {
+			return nil, err
+		}
+		cfg.WriteRelays = append(cfg.WriteRelays, r)
+	}
+	if len(cfg.WriteRelays) == 0 {
+		global, err := readGlobalConfig()
+		if err != nil {
+			return nil, err
+		}
+		if len(global.WriteRelays) == 0 && len(global.RelayGroups) == 0 {
+			cfg.WriteRelays = append([]string(nil), defaultRelays...)
+		}
+	}
+	if identity != "" {
+		if err := validProfileName(identity); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+// generateKey writes a fresh secret key to path unless a key is already
+// there, returning whether it did.
+func generateKey(path string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	sk := nostr.GeneratePrivateKey()
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(path, []byte(nsec+"\n"), 0600); err != nil {
+		return false, err
+	}
+	pk, _ := nostr.GetPublicKey(sk)
+	npub, _ := nip19.EncodePublicKey(pk)
+	fmt.Printf("Generated key %s in %s\n", npub, path)
+	return true, nil
+}
+
+func cmdInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	template := fs.String("template", "", "naddr of a template repository to start from")
+	var rawVars stringList
+	fs.Var(&rawVars, "var", "template variable as key=value (repeatable)")
+	yes := fs.Bool("yes", false, "publish template files without asking for confirmation")
+	name := fs.String("name", "", "repository name (defaults to the directory name)")
+	var relays, servers stringList
+	fs.Var(&relays, "relay", "relay to publish to (repeatable)")
+	identity := fs.String("identity", "", "identity profile to use in this repository")
+	storage := fs.String("storage", "", "where large files go: inline, blossom or nip96")
+	fs.Var(&servers, "blossom-server", "Blossom server for --storage blossom (repeatable)")
+	keygen := fs.Bool("keygen", false, "generate a keypair if there is none yet")
+	noAnnounce := fs.Bool("no-announce", false, "do not publish a repository announcement")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	repo := openRepo(".")
+	v, err := repo.FormatVersion()
+	if err != nil {
+		return err
+	}
+	if v != 0 {
+		return fmt.Errorf("%s already exists", localOrbiDirName)
+	}
+	cwd, _ := os.Getwd()
+	if *name == "" {
+		*name = filepath.Base(cwd)
+	}
+	cfg, err := initConfig(*name, relays, *identity, *storage, servers)
+	if err != nil {
+		return err
+	}
+	if *identity != "" && !*keygen {
+		if _, err := os.Stat(profileKeyPath(*identity)); err != nil {
+			return fmt.Errorf("identity %s: %w", *identity, ErrNotFound)
+		}
+	}
+	if err := repo.UpdateIndex(func(*orbi.Index) error { return nil }); err != nil {
+		return err
+	}
+	if err := repo.SaveConfig(cfg); err != nil {
+		return err
+	}
+	if err := repo.register(); err != nil {
+		slog.Warn("Failed to add repository to the workspace registry", "err", err)
+	}
+	fmt.Printf("Initialized empty orbi repository in %s\n", repo.dir())
+	if *keygen {
+		if _, err := generateKey(secretKeyPath()); err != nil {
+			return fmt.Errorf("generating key: %w", err)
+		}
+	}
+	if *noAnnounce && *template == "" {
+		return nil
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	if !*noAnnounce {
+		// The repository is usable without its announcement; it can be
+		// published later with orbi announce.
+		if ev, err := client.announce(); err != nil {
+			slog.Warn("Failed to announce the repository; retry with orbi announce", "err", err)
+		} else {
+			fmt.Printf("Announced repository %s\nEvent ID: %s\n", ev.Tags.GetD(), ev.ID)
+		}
+	}
+	if *template == "" {
+		return nil
+	}
+	if !*yes {
+		client.Confirm = promptConfirm
+	}
+	npub, _ := nip19.EncodePublicKey(client.pk)
+	vars := map[string]string{
+		"name": *name,
+		"npub": npub,
+		"year": strconv.Itoa(time.Now().Year()),
+	}
+	for _, kv := range rawVars {
+		k, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid --var %q: expected key=value", kv)
+		}
+		vars[k] = val
+	}
+	return client.initFromTemplate(*template, vars)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-34 issues and their NIP-22 comments.
+const (
+	eventKindIssue   = 1621
+	eventKindComment = 1111
+)
+
+// forgeTimestamp converts a forge's RFC 3339 time, falling back to now.
+func forgeTimestamp(s string) nostr.Timestamp {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return nostr.Timestamp(t.Unix())
+	}
+	return nostr.Now()
+}
+
+// importIssues republishes the repository's GitHub issues and their comments
+// as NIP-34 issue events linked to this repository. The original author and
+// URL are kept in tags. Issues already imported are skipped, so the import
+// can be rerun to pick up new activity.
+func (b *githubBridge) importIssues() (int, error) {
+	cfg, err := b.c.Repo.Config()
+	if err != nil {
+		return 0, err
+	}
+	if b.state.Issues == nil {
+		b.state.Issues = make(map[string]string)
+	}
+	n := 0
+	for page := 1; ; page++ {
+		var issues []githubIssue
+		path := "/issues?state=all&sort=created&direction=asc&per_page=100&page=" + strconv.Itoa(page)
+		if err := b.gh.do("GET", path, nil, &issues); err != nil {
+			return n, err
+		}
+		for _, issue := range issues {
+			if issue.PullRequest != nil {
+				continue
+			}
+			imported, err := b.importIssue(cfg, issue)
+			if err != nil {
+				return n, fmt.Errorf("issue #%d: %w", issue.Number, err)
+			}
+			n += imported
+		}
+		if len(issues) < 100 {
+			return n, nil
+		}
+	}
+}
+
+func (b *githubBridge) importIssue(cfg *Config, issue githubIssue) (int, error) {
+	key := "issue/" + strconv.FormatInt(issue.ID, 10)
+	n := 0
+	root, ok := b.state.Issues[key]
+	if !ok {
+		ev := nostr.Event{
+			PubKey:    b.c.pk,
+			CreatedAt: forgeTimestamp(issue.CreatedAt),
+			Kind:      eventKindIssue,
+			Content:   issue.Body,
+			Tags: nostr.Tags{
+				{"ver", eventFormatVersion},
+				{"a", b.c.repoAddress(cfg)},
+				{"p", b.c.Identity()},
+				{"subject", issue.Title},
+				{"author", issue.User.Login, issue.User.HTMLURL},
+				{"r", issue.HTMLURL},
+			},
+		}
+		for _, l := range issue.Labels {
+			ev.Tags = append(ev.Tags, nostr.Tag{"t", l.Name})
+		}
+		if err := b.publishImported(&ev, key); err != nil {
+			return n, err
+		}
+		root = ev.ID
+		n++
+	}
+
+	for page := 1; ; page++ {
+		var comments []githubComment
+		path := fmt.Sprintf("/issues/%d/comments?per_page=100&page=%d", issue.Number, page)
+		if err := b.gh.do("GET", path, nil, &comments); err != nil {
+			return n, err
+		}
+		for _, cm := range comments {
+			key := "comment/" + strconv.FormatInt(cm.ID, 10)
+			if _, ok := b.state.Issues[key]; ok {
+				continue
+			}
+			ev := nostr.Event{
+				PubKey:    b.c.pk,
+				CreatedAt: forgeTimestamp(cm.CreatedAt),
+				Kind:      eventKindComment,
+				Content:   cm.Body,
+				Tags: nostr.Tags{
+					{"ver", eventFormatVersion},
+					{"E", root},
+					{"K", strconv.Itoa(eventKindIssue)},
+					{"P", b.c.pk},
+					{"e", root},
+					{"k", strconv.Itoa(eventKindIssue)},
+					{"p", b.c.pk},
+					{"author", cm.User.Login, cm.User.HTMLURL},
+					{"r", cm.HTMLURL},
+				},
+			}
+			if err := b.publishImported(&ev, key); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if len(comments) < 100 {
+			return n, nil
+		}
+	}
+}
+
+// publishImported signs and publishes ev and remembers it under key.
+func (b *githubBridge) publishImported(ev *nostr.Event, key string) error {
+	if err := b.c.sign(ev); err != nil {
+		return err
+	}
+	if err := b.c.publish(ev); err != nil {
+		return err
+	}
+	b.state.Issues[key] = ev.ID
+	return b.save()
+}
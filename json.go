@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// printJSON writes v to stdout as indented JSON, for commands run with
+// --json.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// relayResultJSON is a RelayResult as printed by --json.
+type relayResultJSON struct {
+	URL       string `json:"url"`
+	OK        bool   `json:"ok"`
+	Error     string `json:"error,omitempty"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+}
+
+func relayResultsJSON(results []RelayResult) []relayResultJSON {
+	out := make([]relayResultJSON, len(results))
+	for i, r := range results {
+		out[i] = relayResultJSON{URL: r.URL, OK: r.Err == nil, ElapsedMS: r.Elapsed.Milliseconds()}
+		if r.Err != nil {
+			out[i].Error = r.Err.Error()
+		}
+	}
+	return out
+}
+
+// recordingObserver keeps the relay results of every published event so
+// they can be reported as JSON instead of logged.
+type recordingObserver struct {
+	nopObserver
+	mu      sync.Mutex
+	results map[string][]RelayResult
+}
+
+func (o *recordingObserver) OnPublishDone(ev *nostr.Event, results []RelayResult) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.results == nil {
+		o.results = make(map[string][]RelayResult)
+	}
+	o.results[ev.ID] = results
+}
+
+// Results returns the relay results recorded for the event with id.
+func (o *recordingObserver) Results(id string) []RelayResult {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.results[id]
+}
+
+// eventJSON describes a version of a file for --json output.
+type eventJSON struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path,omitempty"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	Message   string    `json:"message,omitempty"`
+	Parents   []string  `json:"parents,omitempty"`
+	MIME      string    `json:"mime,omitempty"`
+	Size      int       `json:"size,omitempty"`
+	CI        string    `json:"ci,omitempty"`
+}
+
+func (c *Client) eventJSON(ev *nostr.Event) eventJSON {
+	meta := c.fileMetadata(ev)
+	return eventJSON{
+		ID:        ev.ID,
+		Path:      c.filePath(ev),
+		Author:    c.identityOf(ev.PubKey),
+		CreatedAt: ev.CreatedAt.Time().UTC(),
+		Message:   c.fileMessage(ev),
+		Parents:   eventParents(ev),
+		MIME:      meta.MIME,
+		Size:      meta.Size,
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// Key shares are single lines of text so they can be pasted into mail or
+// chat. Plain shares carry the npub of the split key, the threshold, the x
+// coordinate and the share bytes; encrypted shares wrap a plain one with
+// NIP-44 for a trustee.
+const (
+	sharePrefix          = "orbi-share1:"
+	encryptedSharePrefix = "orbi-share1-nip44:"
+)
+
+type keyShare struct {
+	Pubkey    string
+	Threshold int
+	shamirShare
+}
+
+func (s keyShare) String() string {
+	npub, _ := nip19.EncodePublicKey(s.Pubkey)
+	return fmt.Sprintf("%s%s:%d:%d:%s", sharePrefix, npub, s.Threshold, s.X, hex.EncodeToString(s.Y))
+}
+
+func parseKeyShare(line string) (keyShare, error) {
+	if strings.HasPrefix(line, encryptedSharePrefix) {
+		return keyShare{}, fmt.Errorf("share is encrypted to a trustee; have them run orbi key unwrap first")
+	}
+	fields := strings.Split(strings.TrimPrefix(line, sharePrefix), ":")
+	if !strings.HasPrefix(line, sharePrefix) || len(fields) != 4 {
+		return keyShare{}, fmt.Errorf("not a key share")
+	}
+	pk, err := parsePubkey(fields[0])
+	if err != nil {
+		return keyShare{}, err
+	}
+	threshold, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return keyShare{}, fmt.Errorf("invalid threshold: %w", err)
+	}
+	x, err := strconv.ParseUint(fields[2], 10, 8)
+	if err != nil || x == 0 {
+		return keyShare{}, fmt.Errorf("invalid share index %q", fields[2])
+	}
+	y, err := hex.DecodeString(fields[3])
+	if err != nil {
+		return keyShare{}, fmt.Errorf("invalid share data: %w", err)
+	}
+	return keyShare{Pubkey: pk, Threshold: threshold, shamirShare: shamirShare{X: byte(x), Y: y}}, nil
+}
+
+// splitKey splits sk into shares. When trustees are given there must be one
+// per share and each share is encrypted to its trustee.
+func splitKey(sk, pk string, n, threshold int, trustees []string) ([]string, error) {
+	if len(trustees) > 0 && len(trustees) != n {
+		return nil, fmt.Errorf("got %d trustees for %d shares", len(trustees), n)
+	}
+	secret, err := hex.DecodeString(sk)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := shamirSplit(secret, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+	npub, _ := nip19.EncodePublicKey(pk)
+	var lines []string
+	for i, s := range shares {
+		line := keyShare{Pubkey: pk, Threshold: threshold, shamirShare: s}.String()
+		if len(trustees) > 0 {
+			trustee, err := parsePubkey(trustees[i])
+			if err != nil {
+				return nil, err
+			}
+			ck, err := nip44.GenerateConversationKey(trustee, sk)
+			if err != nil {
+				return nil, err
+			}
+			ciphertext, err := nip44.Encrypt(line, ck)
+			if err != nil {
+				return nil, err
+			}
+			line = encryptedSharePrefix + npub + ":" + ciphertext
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// unwrapShare decrypts a share that was encrypted to the trustee key sk.
+func unwrapShare(line, sk string) (string, error) {
+	if !strings.HasPrefix(line, encryptedSharePrefix) {
+		return "", fmt.Errorf("not an encrypted key share")
+	}
+	rest := strings.TrimPrefix(line, encryptedSharePrefix)
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return "", fmt.Errorf("not an encrypted key share")
+	}
+	owner, err := parsePubkey(rest[:i])
+	if err != nil {
+		return "", err
+	}
+	ck, err := nip44.GenerateConversationKey(owner, sk)
+	if err != nil {
+		return "", err
+	}
+	plain, err := nip44.Decrypt(rest[i+1:], ck)
+	if err != nil {
+		return "", fmt.Errorf("share is not encrypted to this key: %w", err)
+	}
+	if _, err := parseKeyShare(plain); err != nil {
+		return "", err
+	}
+	return plain, nil
+}
+
+// recoverKey reassembles a secret key from plain share lines and checks it
+// against the public key recorded in them.
+func recoverKey(lines []string) (string, error) {
+	var shares []shamirShare
+	var first keyShare
+	for i, line := range lines {
+		s, err := parseKeyShare(line)
+		if err != nil {
+			return "", fmt.Errorf("share %d: %w", i+1, err)
+		}
+		if i == 0 {
+			first = s
+		} else if s.Pubkey != first.Pubkey || s.Threshold != first.Threshold {
+			return "", fmt.Errorf("share %d belongs to a different split", i+1)
+		}
+		shares = append(shares, s.shamirShare)
+	}
+	if len(shares) < first.Threshold {
+		return "", fmt.Errorf("need %d shares, got %d", first.Threshold, len(shares))
+	}
+	secret, err := shamirCombine(shares)
+	if err != nil {
+		return "", err
+	}
+	sk := hex.EncodeToString(secret)
+	if pk, err := nostr.GetPublicKey(sk); err != nil || pk != first.Pubkey {
+		return "", fmt.Errorf("shares do not reassemble the key they were split from")
+	}
+	return sk, nil
+}
+
+// readShareLines reads non-empty lines from each named file, or stdin for -.
+func readShareLines(paths []string) ([]string, error) {
+	var lines []string
+	for _, p := range paths {
+		var r io.Reader = os.Stdin
+		if p != "-" {
+			f, err := os.Open(p)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+func cmdKey(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "encrypt":
+			return cmdKeyEncrypt(args[1:])
+		case "split":
+			return cmdKeySplit(args[1:])
+		case "recover":
+			return cmdKeyRecover(args[1:])
+		case "unwrap":
+			return cmdKeyUnwrap(args[1:])
+		case "subkey":
+			return cmdKeySubkey(args[1:])
+		}
+	}
+	return fmt.Errorf("usage: orbi key encrypt|split|recover|unwrap|subkey")
+}
+
+func cmdKeySplit(args []string) error {
+	fs := flag.NewFlagSet("key split", flag.ContinueOnError)
+	threshold := fs.Int("threshold", 2, "number of shares needed to recover the key")
+	n := fs.Int("shares", 3, "number of shares to produce")
+	var trustees stringList
+	fs.Var(&trustees, "trustee", "npub to encrypt the next share to (repeatable, one per share)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("usage: orbi key split [--threshold <k>] [--shares <n>] [--trustee <npub>]...")
+	}
+	sk, pk, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+	lines, err := splitKey(sk, pk, *n, *threshold, trustees)
+	if err != nil {
+		return err
+	}
+	for i, line := range lines {
+		if len(trustees) > 0 {
+			fmt.Printf("# share %d for %s\n", i+1, trustees[i])
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func cmdKeyRecover(args []string) error {
+	fs := flag.NewFlagSet("key recover", flag.ContinueOnError)
+	out := fs.String("o", "", "write the recovered nsec to this file instead of stdout")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		positional = []string{"-"}
+	}
+	lines, err := readShareLines(positional)
+	if err != nil {
+		return err
+	}
+	var shares []string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "#") {
+			shares = append(shares, line)
+		}
+	}
+	sk, err := recoverKey(shares)
+	if err != nil {
+		return err
+	}
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	if *out == "" {
+		fmt.Println(nsec)
+		return nil
+	}
+	if err := ioutil.WriteFile(*out, []byte(nsec+"\n"), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("Recovered key written to %s\n", *out)
+	return nil
+}
+
+func cmdKeyUnwrap(args []string) error {
+	fs := flag.NewFlagSet("key unwrap", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		positional = []string{"-"}
+	}
+	lines, err := readShareLines(positional)
+	if err != nil {
+		return err
+	}
+	sk, _, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		plain, err := unwrapShare(line, sk)
+		if err != nil {
+			return err
+		}
+		fmt.Println(plain)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip49"
+)
+
+// KeyRotation records that the key From was replaced by To.
+type KeyRotation struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	At   int64  `json:"at"`
+}
+
+// previousKeys returns the hex keys that were rotated, directly or through
+// a chain of rotations, into pk.
+func (cfg *Config) previousKeys(pk string) []string {
+	chain := []string{pk}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range cfg.KeyRotations {
+			from, err1 := parsePubkey(r.From)
+			to, err2 := parsePubkey(r.To)
+			if err1 == nil && err2 == nil && contains(chain, to) && !contains(chain, from) {
+				chain = append(chain, from)
+				changed = true
+			}
+		}
+	}
+	return chain[1:]
+}
+
+// confirmOverwrite asks before replacing the key at path.
+func confirmOverwrite(path string) (bool, error) {
+	if !isTerminal(os.Stdin) {
+		return false, fmt.Errorf("refusing to overwrite %s without confirmation; pass --force", path)
+	}
+	fmt.Printf("%s already holds a key. Overwrite it? [y/N] ", path)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func cmdKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	out := fs.String("o", "", "write the key to this file instead of the secret key path")
+	noEncrypt := fs.Bool("no-encrypt", false, "write the key as a plain nsec instead of an ncryptsec")
+	logn := fs.Uint("logn", 16, "scrypt work factor as a power of two")
+	force := fs.Bool("force", false, "overwrite an existing key without asking")
+	rotate := fs.Bool("rotate", false, "replace the current key, recording the rotation in the repository config")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("usage: orbi keygen [-o <file>] [--no-encrypt] [--force] [--rotate]")
+	}
+	path := secretKeyPath()
+	if *out != "" {
+		path = expandPath(*out)
+	}
+
+	var oldPK string
+	if *rotate {
+		if _, oldPK, err = readSecretKey(path); err != nil {
+			return fmt.Errorf("reading the key to rotate: %w", err)
+		}
+	} else if _, err := os.Stat(path); err == nil && !*force {
+		ok, err := confirmOverwrite(path)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("keygen cancelled")
+		}
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return err
+	}
+	content, _ := nip19.EncodePrivateKey(sk)
+	if !*noEncrypt {
+		passphrase, err := newPassphrase()
+		if err != nil {
+			return err
+		}
+		if content, err = nip49.Encrypt(sk, passphrase, uint8(*logn), nip49.NotKnownToHaveBeenHandledInsecurely); err != nil {
+			return err
+		}
+	}
+
+	if *rotate {
+		// Keep the old key: it is still needed to prove authorship of, or
+		// delete, what it published.
+		if err := os.Rename(path, path+".old"); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(content+"\n"), 0600); err != nil {
+		return err
+	}
+	npub, _ := nip19.EncodePublicKey(pk)
+	fmt.Printf("Wrote key for %s to %s\n", npub, path)
+	if !*rotate {
+		return nil
+	}
+
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	oldNpub, _ := nip19.EncodePublicKey(oldPK)
+	cfg.KeyRotations = append(cfg.KeyRotations, KeyRotation{From: oldNpub, To: npub, At: time.Now().Unix()})
+	if err := repo.SaveConfig(cfg); err != nil {
+		return fmt.Errorf("new key written but the rotation was not recorded: %w", err)
+	}
+	fmt.Printf("Rotated %s to %s; the old key was moved to %s.old\n", oldNpub, npub, path)
+	return nil
+}
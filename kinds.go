@@ -0,0 +1,270 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventKindAnnouncement is the addressable event in which a repository
+// declares its name and the event kinds it uses.
+const eventKindAnnouncement = 30444
+
+// KindMap assigns an event kind to each role orbi publishes. Repositories can
+// remap roles in .orbi/config and declare the mapping in their announcement,
+// so forks and future versions can move kinds without breaking readers.
+type KindMap struct {
+	File     int `json:"file,omitempty"`
+	Tag      int `json:"tag,omitempty"`
+	CIStatus int `json:"ci_status,omitempty"`
+	Approval int `json:"approval,omitempty"`
+	Patch    int `json:"patch,omitempty"`
+	Lock     int `json:"lock,omitempty"`
+	// Latest is the addressable kind used for files configured to keep
+	// only their newest version.
+	Latest int `json:"latest,omitempty"`
+}
+
+// defaultKinds is the compatibility registry: the kinds every orbi version
+// has used for each role unless a repository says otherwise.
+var defaultKinds = KindMap{
+	File:     eventKindFile,
+	Tag:      eventKindTag,
+	CIStatus: eventKindCIStatus,
+	Approval: eventKindApproval,
+	Patch:    4448,
+	Lock:     4449,
+	Latest:   eventKindLatestFile,
+}
+
+// roles lists the roles with their announcement names, in a stable order.
+func (k *KindMap) roles() []struct {
+	name string
+	kind *int
+} {
+	return []struct {
+		name string
+		kind *int
+	}{
+		{"file", &k.File},
+		{"tag", &k.Tag},
+		{"ci_status", &k.CIStatus},
+		{"approval", &k.Approval},
+		{"patch", &k.Patch},
+		{"lock", &k.Lock},
+		{"latest", &k.Latest},
+	}
+}
+
+// withDefaults fills unset roles from the registry.
+func (k KindMap) withDefaults() KindMap {
+	d := defaultKinds
+	for i, role := range k.roles() {
+		if *role.kind == 0 {
+			*role.kind = *d.roles()[i].kind
+		}
+	}
+	return k
+}
+
+// validate checks that every kind is a regular (stored, non-replaceable)
+// kind, except latest which must be addressable, and that no two roles share
+// one.
+func (k KindMap) validate() error {
+	seen := make(map[int]string)
+	for _, role := range k.roles() {
+		n := *role.kind
+		if n == 0 {
+			continue
+		}
+		if role.kind == &k.Latest {
+			if !nostr.IsAddressableKind(n) {
+				return fmt.Errorf("kind %d for %s is not an addressable event kind (30000-39999)", n, role.name)
+			}
+		} else if n < 1000 || n >= 10000 {
+			return fmt.Errorf("kind %d for %s is not a regular event kind (1000-9999)", n, role.name)
+		}
+		if other, ok := seen[n]; ok {
+			return fmt.Errorf("kind %d is used for both %s and %s", n, other, role.name)
+		}
+		seen[n] = role.name
+	}
+	return nil
+}
+
+// files returns the kinds file versions are published as.
+func (k KindMap) files() []int {
+	return []int{k.File, k.Latest}
+}
+
+// kindTags encodes the mapping as ["kind", role, number] tags.
+func (k KindMap) kindTags() nostr.Tags {
+	var tags nostr.Tags
+	for _, role := range k.roles() {
+		tags = append(tags, nostr.Tag{"kind", role.name, strconv.Itoa(*role.kind)})
+	}
+	return tags
+}
+
+// parseKindTags reads a mapping from an announcement, ignoring roles this
+// version of orbi doesn't know and falling back to the registry for roles
+// the announcement doesn't mention.
+func parseKindTags(ev *nostr.Event) KindMap {
+	if checkEventFormat(ev) != nil {
+		return defaultKinds
+	}
+	var k KindMap
+	for tag := range ev.Tags.FindAll("kind") {
+		if len(tag) < 3 {
+			continue
+		}
+		n, err := strconv.Atoi(tag[2])
+		if err != nil {
+			continue
+		}
+		for _, role := range k.roles() {
+			if role.name == tag[1] {
+				*role.kind = n
+			}
+		}
+	}
+	if k.validate() != nil {
+		return defaultKinds
+	}
+	return k.withDefaults()
+}
+
+// kinds returns the kind mapping used by author's repository: the local
+// config for the client's own key, otherwise the one declared in the
+// author's newest announcement.
+func (c *Client) kinds(author string) KindMap {
+	if author == "" || author == c.pk {
+		cfg, err := c.Repo.Config()
+		if err != nil || cfg.Kinds == nil {
+			return defaultKinds
+		}
+		return cfg.Kinds.withDefaults()
+	}
+
+	c.mu.RLock()
+	k, ok := c.kindCache[author]
+	c.mu.RUnlock()
+	if ok {
+		return k
+	}
+	k = defaultKinds
+	announcements := c.query(nostr.Filter{Kinds: []int{eventKindAnnouncement}, Authors: []string{author}})
+	if len(announcements) > 0 {
+		sort.Slice(announcements, func(i, j int) bool { return announcements[i].CreatedAt > announcements[j].CreatedAt })
+		k = parseKindTags(announcements[0])
+	}
+	c.mu.Lock()
+	if c.kindCache == nil {
+		c.kindCache = make(map[string]KindMap)
+	}
+	c.kindCache[author] = k
+	c.mu.Unlock()
+	return k
+}
+
+// announcementOf returns author's newest validly signed repository
+// announcement, or nil.
+func (c *Client) announcementOf(author string) *nostr.Event {
+	var latest *nostr.Event
+	for _, ev := range c.query(nostr.Filter{Kinds: []int{eventKindAnnouncement}, Authors: []string{author}}) {
+		if ev.PubKey == author && validSignature(ev) && (latest == nil || before(latest, ev)) {
+			latest = ev
+		}
+	}
+	return latest
+}
+
+// collaboratorsOf returns the collaborators author declares in their
+// newest repository announcement.
+func (c *Client) collaboratorsOf(author string) []string {
+	latest := c.announcementOf(author)
+	if latest == nil {
+		return nil
+	}
+	var collaborators []string
+	for tag := range latest.Tags.FindAll("collaborator") {
+		if nostr.IsValidPublicKey(tag[1]) && !contains(collaborators, tag[1]) {
+			collaborators = append(collaborators, tag[1])
+		}
+	}
+	return collaborators
+}
+
+// repoName returns the configured repository name. It defaults to the name
+// the origin announced in a clone, and to the name of the directory
+// otherwise.
+func (c *Client) repoName(cfg *Config) string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	if origin, err := parsePubkey(cfg.Origin); err == nil {
+		if ev := c.announcementOf(origin); ev != nil && ev.Tags.GetD() != "" {
+			return ev.Tags.GetD()
+		}
+	}
+	root, _ := filepath.Abs(c.Repo.root)
+	return filepath.Base(root)
+}
+
+// announce publishes the repository announcement declaring its name, kind
+// mapping and collaborators, followed by its NIP-34 counterpart.
+func (c *Client) announce() (*nostr.Event, error) {
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	name := c.repoName(cfg)
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindAnnouncement,
+		Tags:      nostr.Tags{{"ver", eventFormatVersion}, {"d", name}, {"name", name}},
+	}
+	ev.Tags = append(ev.Tags, c.kinds("").kindTags()...)
+	for _, s := range cfg.Collaborators {
+		pk, err := parsePubkey(s)
+		if err != nil {
+			return nil, err
+		}
+		ev.Tags = append(ev.Tags, nostr.Tag{"collaborator", pk})
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	if _, err := c.announceNIP34(cfg); err != nil {
+		return &ev, fmt.Errorf("NIP-34 announcement: %w", err)
+	}
+	return &ev, nil
+}
+
+func cmdAnnounce(args []string) error {
+	fs := flag.NewFlagSet("announce", flag.ContinueOnError)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	ev, err := client.announce()
+	if err != nil {
+		return err
+	}
+	for tag := range ev.Tags.FindAll("kind") {
+		fmt.Printf("  %-10s %s\n", tag[1], tag[2])
+	}
+	fmt.Printf("Announced repository %s\nEvent ID: %s\n", ev.Tags.GetD(), ev.ID)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func cmdLog(args []string) error {
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	reverse := fs.Bool("reverse", false, "list the oldest version first")
+	jsonOut := fs.Bool("json", false, "print the versions as JSON")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: orbi log [--reverse] [--json] <file>")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	rel, err := client.Repo.Rel(rest[0])
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	versions := client.versions(rel, client.pullAuthors(cfg))
+	if len(versions) == 0 {
+		return fmt.Errorf("%s: %w", rel, ErrNotFound)
+	}
+	sort.Slice(versions, func(i, j int) bool { return before(versions[j], versions[i]) != *reverse })
+	ids := make([]string, len(versions))
+	for i, ev := range versions {
+		ids[i] = ev.ID
+	}
+	statuses := client.ciStatuses(ids, client.ciTrusted(cfg))
+
+	if *jsonOut {
+		out := make([]eventJSON, len(versions))
+		for i, ev := range versions {
+			out[i] = client.eventJSON(ev)
+			out[i].CI = combinedState(statuses[ev.ID])
+		}
+		return printJSON(out)
+	}
+
+	for _, ev := range versions {
+		fmt.Printf("event %s", ev.ID)
+		if state := combinedState(statuses[ev.ID]); state != "" {
+			fmt.Printf(" [ci: %s]", state)
+		}
+		fmt.Println()
+		if author := client.identityOf(ev.PubKey); author != client.Identity() || len(cfg.Collaborators) > 0 {
+			npub, _ := nip19.EncodePublicKey(author)
+			fmt.Printf("Author: %s\n", npub)
+		}
+		if parents := eventParents(ev); len(parents) > 1 {
+			fmt.Print("Merge:")
+			for _, p := range parents {
+				fmt.Printf(" %s", short(p))
+			}
+			fmt.Println()
+		}
+		fmt.Printf("Date:   %s\n\n", time.Unix(int64(ev.CreatedAt), 0).Format(time.RFC1123Z))
+		if msg := client.fileMessage(ev); msg != "" {
+			fmt.Printf("    %s\n\n", msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// levelTrace sits below debug and covers individual relay round-trips.
+const levelTrace = slog.LevelDebug - 4
+
+// logLevel is the threshold set by the global -v, -vv and -q options.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging installs a text handler on standard error as the default
+// logger. Timestamps are left out since orbi is run interactively.
+func setupLogging() {
+	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			if a.Key == slog.LevelKey && a.Value.Any() == levelTrace {
+				return slog.String(slog.LevelKey, "TRACE")
+			}
+			return a
+		},
+	})
+	slog.SetDefault(slog.New(h))
+}
+
+// quiet reports whether -q was given, in which case only errors and the
+// final event ID are printed.
+func quiet() bool {
+	return logLevel.Level() > slog.LevelWarn
+}
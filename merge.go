@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// conflictMarker starts the local side of a conflicting region.
+const conflictMarker = "<<<<<<< "
+
+// mergeHunk replaces the base lines [start, end) with lines.
+type mergeHunk struct {
+	start, end int
+	lines      []string
+}
+
+// diffHunks groups the edit script from base to other into hunks over base.
+func diffHunks(base, other []string) []mergeHunk {
+	var hunks []mergeHunk
+	var cur *mergeHunk
+	i := 0
+	for _, op := range diffLines(base, other) {
+		if op.kind == ' ' {
+			if cur != nil {
+				hunks = append(hunks, *cur)
+				cur = nil
+			}
+			i++
+			continue
+		}
+		if cur == nil {
+			cur = &mergeHunk{start: i, end: i}
+		}
+		if op.kind == '-' {
+			i++
+			cur.end = i
+		} else {
+			cur.lines = append(cur.lines, op.line)
+		}
+	}
+	if cur != nil {
+		hunks = append(hunks, *cur)
+	}
+	return hunks
+}
+
+// applyHunksRange returns base[start:end] with hunks, which must lie inside
+// that range, applied.
+func applyHunksRange(base []string, start, end int, hunks []mergeHunk) []string {
+	var out []string
+	p := start
+	for _, h := range hunks {
+		out = append(out, base[p:h.start]...)
+		out = append(out, h.lines...)
+		p = h.end
+	}
+	return append(out, base[p:end]...)
+}
+
+// merge3 merges the changes from base to ours and from base to theirs line
+// by line. Regions both sides changed differently are wrapped in conflict
+// markers labelled with ourLabel and theirLabel; the second result reports
+// whether there were any.
+func merge3(base, ours, theirs, ourLabel, theirLabel string) (string, bool) {
+	baseLines := splitLines(base)
+	a := diffHunks(baseLines, splitLines(ours))
+	b := diffHunks(baseLines, splitLines(theirs))
+
+	var out strings.Builder
+	conflicted := false
+	pos := 0
+	for len(a) > 0 || len(b) > 0 {
+		// Start a region at the earliest hunk and grow it while hunks from
+		// either side overlap it. Insertions at the same spot overlap too.
+		var ra, rb []mergeHunk
+		take := func(side *[]mergeHunk, region *[]mergeHunk) {
+			*region = append(*region, (*side)[0])
+			*side = (*side)[1:]
+		}
+		var first mergeHunk
+		if len(b) == 0 || len(a) > 0 && a[0].start <= b[0].start {
+			first = a[0]
+			take(&a, &ra)
+		} else {
+			first = b[0]
+			take(&b, &rb)
+		}
+		start, end := first.start, first.end
+		overlaps := func(h mergeHunk) bool {
+			return h.start < end || h.start == end && (h.start == h.end || start == end)
+		}
+		for {
+			var h mergeHunk
+			if len(a) > 0 && overlaps(a[0]) {
+				h = a[0]
+				take(&a, &ra)
+			} else if len(b) > 0 && overlaps(b[0]) {
+				h = b[0]
+				take(&b, &rb)
+			} else {
+				break
+			}
+			if h.end > end {
+				end = h.end
+			}
+		}
+
+		for _, line := range baseLines[pos:start] {
+			out.WriteString(line)
+		}
+		pos = end
+		mine := applyHunksRange(baseLines, start, end, ra)
+		other := applyHunksRange(baseLines, start, end, rb)
+		switch {
+		case len(rb) == 0:
+			writeLines(&out, mine)
+		case len(ra) == 0:
+			writeLines(&out, other)
+		case strings.Join(mine, "") == strings.Join(other, ""):
+			writeLines(&out, mine)
+		default:
+			conflicted = true
+			out.WriteString(conflictMarker + ourLabel + "\n")
+			writeLines(&out, mine)
+			endLine(&out)
+			out.WriteString("=======\n")
+			writeLines(&out, other)
+			endLine(&out)
+			out.WriteString(">>>>>>> " + theirLabel + "\n")
+		}
+	}
+	for _, line := range baseLines[pos:] {
+		out.WriteString(line)
+	}
+	return out.String(), conflicted
+}
+
+func writeLines(b *strings.Builder, lines []string) {
+	for _, line := range lines {
+		b.WriteString(line)
+	}
+}
+
+// endLine terminates the last line written to b, so a marker that follows
+// starts on its own line.
+func endLine(b *strings.Builder) {
+	if s := b.String(); s != "" && !strings.HasSuffix(s, "\n") {
+		b.WriteString("\n")
+	}
+}
+
+// runMergeTool runs the configured merge tool the way git does: through the
+// shell, with $BASE, $LOCAL and $REMOTE naming files holding each version
+// and $MERGED the file to write the result to. A non-zero exit means the
+// tool could not merge.
+func runMergeTool(tool, rel string, base, ours, theirs []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "orbi-merge-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Base(rel)
+	files := map[string][]byte{"BASE": base, "LOCAL": ours, "REMOTE": theirs, "MERGED": ours}
+	env := os.Environ()
+	for key, content := range files {
+		path := filepath.Join(dir, strings.ToLower(key)+"."+name)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			return nil, err
+		}
+		env = append(env, key+"="+path)
+	}
+	cmd := exec.Command("sh", "-c", tool)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("merge tool: %w", err)
+	}
+	return ioutil.ReadFile(filepath.Join(dir, "merged."+name))
+}
+
+// mergePulled merges the remote head into a working copy that was edited
+// since base, the version it was last synced to, and records head as the
+// version the working copy now builds on. It returns pullMerged, or
+// pullConflict after leaving conflict markers (or the merge tool's partial
+// result) in the file, or pullModified when the versions can't be merged.
+func (c *Client) mergePulled(entry *orbi.IndexEntry, base, head *nostr.Event, cfg *Config) (string, error) {
+	baseContent, err := c.eventContent(base)
+	if err != nil {
+		return "", err
+	}
+	theirs, err := c.eventContent(head)
+	if err != nil {
+		return "", err
+	}
+	local, err := ioutil.ReadFile(c.Repo.Abs(entry.Path))
+	if err != nil {
+		return "", err
+	}
+	ours := normalizeEOL(local, cfg.EOL)
+	if !orbi.IsText(baseContent) || !orbi.IsText(theirs) || !orbi.IsText(ours) {
+		return pullModified, nil
+	}
+
+	var merged []byte
+	conflicted := false
+	if cfg.MergeTool != "" {
+		if merged, err = runMergeTool(cfg.MergeTool, entry.Path, baseContent, ours, theirs); err != nil {
+			slog.Warn("Merge tool failed; leaving the file unchanged", "file", entry.Path, "err", err)
+			return pullModified, nil
+		}
+		conflicted = bytes.Contains(merged, []byte(conflictMarker))
+	} else {
+		var text string
+		text, conflicted = merge3(string(baseContent), string(ours), string(theirs), "local", "remote "+short(head.ID))
+		merged = []byte(text)
+	}
+
+	// Take the remote version as the new base, then put the merge on top
+	// as a local modification.
+	previous, err := c.restoreVersion(entry.Path, head)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(c.Repo.Abs(entry.Path), applyEOL(merged, cfg.EOL), fileMode(c.fileMetadata(head))); err != nil {
+		return "", err
+	}
+	if err := c.Repo.LogHead("pull", entry.Path, previous, head.ID, c.fileMessage(head)); err != nil {
+		return "", err
+	}
+	if !conflicted {
+		return pullMerged, nil
+	}
+	err = c.Repo.UpdateIndex(func(idx *orbi.Index) error {
+		if e, ok := idx.Files[entry.Path]; ok {
+			e.Conflict = true
+		}
+		return nil
+	})
+	return pullConflict, err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	defaultClaimTTL = 60 * time.Second
+	// claimSettle is how long a pusher waits after claiming the head for
+	// competing claims to reach the relays.
+	claimSettle = 2 * time.Second
+)
+
+// MergeQueueConfig turns on serialized pushes. Before publishing, a pusher
+// claims the current repository head with a lock event, waits for competing
+// claims, and only proceeds if its claim is the earliest; afterwards it
+// confirms the new head. Concurrent pushes then fail with a conflict instead
+// of silently forking.
+type MergeQueueConfig struct {
+	// Members are the npubs whose claims and confirmations count. Empty
+	// means only this repository's identity.
+	Members []string `json:"members,omitempty"`
+	// ClaimTTL is how many seconds an unconfirmed claim blocks others.
+	ClaimTTL int `json:"claim_ttl,omitempty"`
+}
+
+func (q *MergeQueueConfig) validate() error {
+	for _, m := range q.Members {
+		if _, err := parsePubkey(m); err != nil {
+			return fmt.Errorf("merge_queue members: %w", err)
+		}
+	}
+	if q.ClaimTTL < 0 {
+		return fmt.Errorf("merge_queue claim_ttl must not be negative")
+	}
+	return nil
+}
+
+func (q *MergeQueueConfig) ttl() time.Duration {
+	if q.ClaimTTL == 0 {
+		return defaultClaimTTL
+	}
+	return time.Duration(q.ClaimTTL) * time.Second
+}
+
+// headClaim is a claim this client holds on the repository head.
+type headClaim struct {
+	// Base is the head the claim was made against; empty for the first push.
+	Base  string
+	Event *nostr.Event
+}
+
+// repoAddress is the "a" tag value of the repository announcement, which
+// merge queue events reference.
+func (c *Client) repoAddress(cfg *Config) string {
+	return fmt.Sprintf("%d:%s:%s", eventKindAnnouncement, c.Identity(), c.repoName(cfg))
+}
+
+func (c *Client) queueMembers(cfg *Config) []string {
+	var members []string
+	for _, m := range cfg.MergeQueue.Members {
+		if pk, err := parsePubkey(m); err == nil {
+			members = append(members, pk)
+		}
+	}
+	if len(members) == 0 {
+		members = []string{c.Identity()}
+	}
+	return members
+}
+
+// queueEvents fetches the validly signed merge queue events of members.
+func (c *Client) queueEvents(cfg *Config, members []string) []*nostr.Event {
+	var result []*nostr.Event
+	for _, ev := range c.query(nostr.Filter{
+		Kinds: []int{c.kinds("").Lock},
+		Tags:  nostr.TagMap{"a": []string{c.repoAddress(cfg)}},
+	}) {
+		if ok, _ := ev.CheckSignature(); ok && c.signsFor(ev.PubKey, members) {
+			result = append(result, ev)
+		}
+	}
+	return result
+}
+
+// markedRef returns the id of ev's "e" tag with the given marker.
+func markedRef(ev *nostr.Event, marker string) string {
+	for tag := range ev.Tags.FindAll("e") {
+		if len(tag) >= 4 && tag[3] == marker {
+			return tag[1]
+		}
+	}
+	return ""
+}
+
+// before orders events by creation time, then id.
+func before(a, b *nostr.Event) bool {
+	if a.CreatedAt != b.CreatedAt {
+		return a.CreatedAt < b.CreatedAt
+	}
+	return a.ID < b.ID
+}
+
+// queueHead returns the head recorded by the newest confirmation.
+func queueHead(events []*nostr.Event) string {
+	var latest *nostr.Event
+	for _, ev := range events {
+		if ev.Tags.FindWithValue("status", "confirm") != nil && (latest == nil || before(latest, ev)) {
+			latest = ev
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return markedRef(latest, "head")
+}
+
+// claimHead claims the current head for a push. It fails with ErrConflict if
+// the head moves or another member's claim on it came first.
+func (c *Client) claimHead(cfg *Config) (*headClaim, error) {
+	members := c.queueMembers(cfg)
+	base := queueHead(c.queueEvents(cfg, members))
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      c.kinds("").Lock,
+		Tags: nostr.Tags{
+			{"ver", eventFormatVersion},
+			{"a", c.repoAddress(cfg)},
+			{"status", "claim"},
+			{"expiration", strconv.FormatInt(time.Now().Add(cfg.MergeQueue.ttl()).Unix(), 10)},
+		},
+	}
+	if base != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", base, "", "base"})
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, fmt.Errorf("failed to claim the merge queue: %w", err)
+	}
+
+	time.Sleep(claimSettle)
+	events := c.queueEvents(cfg, members)
+	if head := queueHead(events); head != base {
+		return nil, fmt.Errorf("%w: the head moved to %s while claiming; pull and retry", ErrConflict, head)
+	}
+	expired := nostr.Timestamp(time.Now().Add(-cfg.MergeQueue.ttl()).Unix())
+	winner := &ev
+	for _, other := range events {
+		if other.Tags.FindWithValue("status", "claim") == nil || markedRef(other, "base") != base || other.CreatedAt < expired {
+			continue
+		}
+		if before(other, winner) {
+			winner = other
+		}
+	}
+	if winner.ID != ev.ID {
+		return nil, fmt.Errorf("%w: %s claimed the head first; pull and retry", ErrConflict, winner.PubKey)
+	}
+	return &headClaim{Base: base, Event: &ev}, nil
+}
+
+// confirmHead records head as the repository head, releasing claim.
+func (c *Client) confirmHead(cfg *Config, claim *headClaim, head string) error {
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      c.kinds("").Lock,
+		Tags: nostr.Tags{
+			{"ver", eventFormatVersion},
+			{"a", c.repoAddress(cfg)},
+			{"status", "confirm"},
+			{"e", claim.Event.ID, "", "claim"},
+			{"e", head, "", "head"},
+		},
+	}
+	if claim.Base != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", claim.Base, "", "base"})
+	}
+	if ev.CreatedAt <= claim.Event.CreatedAt {
+		ev.CreatedAt = claim.Event.CreatedAt + 1
+	}
+	if err := c.sign(&ev); err != nil {
+		return err
+	}
+	if err := c.publish(&ev); err != nil {
+		slog.Warn("Failed to confirm the new head; the claim will expire", "ttl", cfg.MergeQueue.ttl())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const (
+	formatFileName = "format"
+	// repoFormatVersion is the .orbi layout written by this version of orbi.
+	repoFormatVersion = 2
+	// eventFormatVersion is stamped on every published event in a "ver" tag.
+	eventFormatVersion = orbi.FormatVersion
+)
+
+// migration upgrades a repository from format version from to from+1.
+// Automatic migrations are applied transparently whenever the repository is
+// written to; the rest need an explicit 'orbi migrate'.
+type migration struct {
+	from        int
+	description string
+	auto        bool
+	run         func(r *Repo) error
+}
+
+// migrations must be kept in order of their from version.
+var migrations = []migration{
+	{from: 1, description: "convert tracked_files to index.json", auto: true, run: migrateTrackedFiles},
+}
+
+// FormatVersion returns the layout version of the repository. Repositories
+// created before the format file existed are version 1; 0 means there is no
+// .orbi directory yet.
+func (r *Repo) FormatVersion() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.formatVersion()
+}
+
+func (r *Repo) formatVersion() (int, error) {
+	content, err := ioutil.ReadFile(filepath.Join(r.dir(), formatFileName))
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(r.dir()); os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 1, nil
+	} else if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid repository format %q", strings.TrimSpace(string(content)))
+	}
+	return v, nil
+}
+
+func (r *Repo) writeFormatVersion(v int) error {
+	return ioutil.WriteFile(filepath.Join(r.dir(), formatFileName), []byte(strconv.Itoa(v)+"\n"), 0644)
+}
+
+// checkFormat refuses to touch repositories whose layout doesn't match this
+// version of orbi, and stamps new ones.
+func (r *Repo) checkFormat() error {
+	v, err := r.formatVersion()
+	if err != nil {
+		return err
+	}
+	switch {
+	case v == 0:
+		if err := os.MkdirAll(r.dir(), 0755); err != nil {
+			return err
+		}
+		return r.writeFormatVersion(repoFormatVersion)
+	case v > repoFormatVersion:
+		return fmt.Errorf("repository format %d is newer than this orbi supports (%d); please upgrade", v, repoFormatVersion)
+	case v < repoFormatVersion:
+		for _, m := range migrations {
+			if m.from < v {
+				continue
+			}
+			if m.from > v || !m.auto {
+				break
+			}
+			if err := m.run(r); err != nil {
+				return fmt.Errorf("migration %d->%d failed: %w", m.from, m.from+1, err)
+			}
+			v++
+			if err := r.writeFormatVersion(v); err != nil {
+				return err
+			}
+		}
+		if v < repoFormatVersion {
+			return fmt.Errorf("repository format %d is outdated; run 'orbi migrate'", v)
+		}
+	}
+	return nil
+}
+
+// Migrate applies every pending migration in order and returns the
+// descriptions of those it ran.
+func (r *Repo) Migrate() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	v, err := r.formatVersion()
+	if err != nil {
+		return nil, err
+	}
+	if v == 0 {
+		return nil, fmt.Errorf("%s: %w", r.dir(), ErrNotFound)
+	}
+	if v > repoFormatVersion {
+		return nil, fmt.Errorf("repository format %d is newer than this orbi supports (%d)", v, repoFormatVersion)
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		if m.from != v {
+			continue
+		}
+		if err := m.run(r); err != nil {
+			return applied, fmt.Errorf("migration %d->%d failed: %w", m.from, m.from+1, err)
+		}
+		v++
+		if err := r.writeFormatVersion(v); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m.description)
+	}
+	if err := r.writeFormatVersion(v); err != nil {
+		return applied, err
+	}
+	return applied, nil
+}
+
+func cmdMigrate(args []string) error {
+	applied, err := openRepo(".").Migrate()
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("Repository is up to date (format %d)\n", repoFormatVersion)
+		return nil
+	}
+	for _, d := range applied {
+		fmt.Printf("Migrated: %s\n", d)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// isLegacyEvent reports whether ev predates the "ver" tag: its content is
+// the raw file and its "f" tag only a base name.
+func isLegacyEvent(ev *nostr.Event) bool {
+	return ev.Kind == defaultKinds.File && ev.Tags.Find("ver") == nil
+}
+
+// legacyPathMap maps legacy base names to repo-relative paths using the
+// tracked files, for names that are unambiguous. overrides take precedence.
+func legacyPathMap(idx *orbi.Index, overrides map[string]string) map[string]string {
+	byBase := make(map[string][]string)
+	for _, p := range idx.Paths() {
+		byBase[path.Base(p)] = append(byBase[path.Base(p)], p)
+	}
+	mapping := make(map[string]string)
+	for base, paths := range byBase {
+		if len(paths) == 1 {
+			mapping[base] = paths[0]
+		}
+	}
+	for k, v := range overrides {
+		mapping[k] = v
+	}
+	return mapping
+}
+
+// migratedIDs returns which of ids have already been republished by the
+// client, so an interrupted migration can be resumed.
+func (c *Client) migratedIDs(ids []string) map[string]bool {
+	done := make(map[string]bool)
+	if len(ids) == 0 {
+		return done
+	}
+	for _, ev := range c.query(nostr.Filter{
+		Kinds:   c.kinds("").files(),
+		Authors: []string{c.pk},
+		Tags:    nostr.TagMap{"e": ids},
+	}) {
+		for tag := range ev.Tags.FindAll("e") {
+			if len(tag) >= 4 && tag[3] == "migrated-from" {
+				done[tag[1]] = true
+			}
+		}
+	}
+	return done
+}
+
+// migrateEvents republishes every legacy event in the structured format,
+// oldest first, chaining versions of the same path through parent tags.
+func (c *Client) migrateEvents(overrides map[string]string, dryRun bool) (int, error) {
+	var legacy []*nostr.Event
+	for _, ev := range c.History("", 0) {
+		if isLegacyEvent(ev) {
+			legacy = append(legacy, ev)
+		}
+	}
+	if len(legacy) == 0 {
+		return 0, nil
+	}
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return 0, err
+	}
+	mapping := legacyPathMap(idx, overrides)
+	ids := make([]string, len(legacy))
+	for i, ev := range legacy {
+		ids[i] = ev.ID
+	}
+	done := c.migratedIDs(ids)
+
+	sort.SliceStable(legacy, func(i, j int) bool { return legacy[i].CreatedAt < legacy[j].CreatedAt })
+	parents := make(map[string]string)
+	latest := make(map[string]string)
+	migrated := 0
+	for _, old := range legacy {
+		base := eventPath(old)
+		rel := base
+		if mapped, ok := mapping[base]; ok {
+			rel = mapped
+		}
+		if done[old.ID] {
+			continue
+		}
+		if dryRun {
+			fmt.Printf("would migrate %s (%s) -> %s\n", old.ID, old.CreatedAt.Time().Format("2006-01-02 15:04"), rel)
+			migrated++
+			continue
+		}
+
+		content, err := c.eventContent(old)
+		if err != nil {
+			return migrated, fmt.Errorf("%s: %w", old.ID, err)
+		}
+		opts := []orbi.EventOption{
+			orbi.WithPath(rel),
+			orbi.WithMessage(eventMessage(old)),
+			orbi.WithCreatedAt(old.CreatedAt.Time()),
+			// Legacy events carry no file metadata; describe the file as of
+			// when it was first published.
+			orbi.WithFileInfo(fileMode(c.fileMetadata(old)), old.CreatedAt.Time()),
+			orbi.WithExtraTags(nostr.Tag{"e", old.ID, "", "migrated-from"}),
+		}
+		if parent, ok := parents[rel]; ok {
+			opts = append(opts, orbi.WithParent(parent))
+		}
+		ev, err := orbi.BuildEvent(c.pk, c.kinds("").File, content, opts...)
+		if err != nil {
+			return migrated, err
+		}
+		if err := c.sign(&ev); err != nil {
+			return migrated, err
+		}
+		if err := c.publish(&ev); err != nil {
+			return migrated, fmt.Errorf("%s: %w", old.ID, err)
+		}
+		parents[rel] = ev.ID
+		latest[rel] = old.ID + " " + ev.ID
+		migrated++
+		fmt.Printf("migrated %s -> %s (%s)\n", old.ID, ev.ID, rel)
+	}
+
+	if dryRun {
+		return migrated, nil
+	}
+	err = c.Repo.UpdateIndex(func(idx *orbi.Index) error {
+		for rel, ids := range latest {
+			oldID, newID, _ := strings.Cut(ids, " ")
+			if e, ok := idx.Files[rel]; ok && (e.EventID == "" || e.EventID == oldID) {
+				e.EventID = newID
+			}
+		}
+		return nil
+	})
+	return migrated, err
+}
+
+func cmdMigrateEvents(args []string) error {
+	fs := flag.NewFlagSet("migrate-events", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "show what would be migrated without publishing")
+	var rawMap stringList
+	fs.Var(&rawMap, "map", "map a legacy base name to a repo path as name=path (repeatable)")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	overrides := make(map[string]string)
+	for _, kv := range rawMap {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid --map %q: expected name=path", kv)
+		}
+		overrides[k] = v
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	n, err := client.migrateEvents(overrides, *dryRun)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Println("No legacy events to migrate")
+	} else if !*dryRun {
+		fmt.Printf("Migrated %d legacy events\n", n)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip49"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// nostrPassphraseEnvVar holds the passphrase of an ncryptsec key for
+// non-interactive use.
+const nostrPassphraseEnvVar = "NOSTR_PASSPHRASE"
+
+// passphraseFile is where --passphrase-file says the passphrase is stored.
+var passphraseFile string
+
+// readPassphrase returns the passphrase from --passphrase-file, the
+// environment or, failing both, the terminal.
+func readPassphrase(prompt string) (string, error) {
+	if passphraseFile != "" {
+		content, err := ioutil.ReadFile(expandPath(passphraseFile))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	if p := os.Getenv(nostrPassphraseEnvVar); p != "" {
+		return p, nil
+	}
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("no passphrase: set %s or pass --passphrase-file", nostrPassphraseEnvVar)
+	}
+	return readHidden(prompt)
+}
+
+// readHidden prompts on stderr and reads a line from the terminal without
+// echoing it where stty is available.
+func readHidden(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = os.Stdin
+		return cmd.Run()
+	}
+	if stty("-echo") == nil {
+		defer stty("echo")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	fmt.Fprintln(os.Stderr)
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// cmdKeyEncrypt replaces a plaintext secret key file with its ncryptsec.
+// newPassphrase asks for a passphrase to encrypt a key with, twice when it
+// is typed in.
+func newPassphrase() (string, error) {
+	passphrase, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if passphraseFile == "" && os.Getenv(nostrPassphraseEnvVar) == "" {
+		again, err := readHidden("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", fmt.Errorf("passphrases don't match")
+		}
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+func cmdKeyEncrypt(args []string) error {
+	fs := flag.NewFlagSet("key encrypt", flag.ContinueOnError)
+	logn := fs.Uint("logn", 16, "scrypt work factor as a power of two")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("usage: orbi key encrypt [--logn <n>] [<key-file>]")
+	}
+	path := secretKeyPath()
+	if len(positional) == 1 {
+		path = expandPath(positional[0])
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if orbi.IsEncryptedKey(string(content)) {
+		return fmt.Errorf("%s is already encrypted", path)
+	}
+	sk, pk, err := readSecretKey(path)
+	if err != nil {
+		return err
+	}
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	// The key has been sitting on disk in plaintext, which the key
+	// security byte records.
+	ncryptsec, err := nip49.Encrypt(sk, passphrase, uint8(*logn), nip49.KnownToHaveBeenHandledInsecurely)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(ncryptsec+"\n"), 0600); err != nil {
+		return err
+	}
+	npub, _ := nip19.EncodePublicKey(pk)
+	fmt.Printf("Encrypted the key for %s in %s\n", npub, path)
+	return nil
+}
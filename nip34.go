@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// NIP-34 event kinds, used so orbi repositories and patches show up in other
+// Nostr git clients.
+const (
+	eventKindRepoAnnouncement = 30617
+	eventKindPatch            = 1617
+)
+
+// repoOwner returns the key that owns the repository: its origin when it was
+// cloned, otherwise the client's own.
+func (c *Client) repoOwner(cfg *Config) string {
+	if pk, err := parsePubkey(cfg.Origin); err == nil {
+		return pk
+	}
+	return c.pk
+}
+
+// nip34Address returns the NIP-34 address of the repository announcement.
+func (c *Client) nip34Address(cfg *Config) string {
+	return fmt.Sprintf("%d:%s:%s", eventKindRepoAnnouncement, c.repoOwner(cfg), c.repoName(cfg))
+}
+
+// announceNIP34 publishes the repository as a NIP-34 announcement, listing
+// the relays it lives on and its collaborators as maintainers.
+func (c *Client) announceNIP34(cfg *Config) (*nostr.Event, error) {
+	name := c.repoName(cfg)
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindRepoAnnouncement,
+		Tags:      nostr.Tags{{"d", name}, {"name", name}, {"t", "orbi"}},
+	}
+	if relays := c.Relays(); len(relays) > 0 {
+		ev.Tags = append(ev.Tags, append(nostr.Tag{"relays"}, relays...))
+	}
+	maintainers := nostr.Tag{"maintainers"}
+	for _, s := range cfg.Collaborators {
+		pk, err := parsePubkey(s)
+		if err != nil {
+			return nil, err
+		}
+		maintainers = append(maintainers, pk)
+	}
+	if len(maintainers) > 1 {
+		ev.Tags = append(ev.Tags, maintainers)
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// publishPatches publishes events as a NIP-34 patch series against the
+// repository: the first patch is the root and every later one replies to
+// the one before it. It returns the patch events, oldest first.
+func (c *Client) publishPatches(events []*nostr.Event) ([]*nostr.Event, error) {
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	var published []*nostr.Event
+	for i, ev := range events {
+		content, err := c.formatPatch(ev, i+1, len(events))
+		if err != nil {
+			return published, err
+		}
+		patch := nostr.Event{
+			PubKey:    c.pk,
+			CreatedAt: nostr.Now(),
+			Kind:      eventKindPatch,
+			Content:   content,
+			Tags:      nostr.Tags{{"a", c.nip34Address(cfg)}, {"p", c.repoOwner(cfg)}},
+		}
+		if i == 0 {
+			patch.Tags = append(patch.Tags, nostr.Tag{"t", "root"})
+		} else {
+			patch.Tags = append(patch.Tags,
+				nostr.Tag{"e", published[0].ID, "", "root"},
+				nostr.Tag{"e", published[i-1].ID, "", "reply"})
+		}
+		if err := c.sign(&patch); err != nil {
+			return published, err
+		}
+		if err := c.publish(&patch); err != nil {
+			return published, fmt.Errorf("%s: %w", short(ev.ID), err)
+		}
+		published = append(published, &patch)
+	}
+	return published, nil
+}
+
+// parseEventID accepts an event id as hex, note or nevent.
+func parseEventID(s string) (string, bool) {
+	if nostr.IsValid32ByteHex(s) {
+		return s, true
+	}
+	prefix, decoded, err := nip19.Decode(s)
+	if err != nil {
+		return "", false
+	}
+	switch prefix {
+	case "note":
+		return decoded.(string), true
+	case "nevent":
+		return decoded.(nostr.EventPointer).ID, true
+	}
+	return "", false
+}
+
+// patchEvent fetches the NIP-34 patch with the given id and returns its
+// content, a patch in git's mailbox format, and the pubkey that signed it.
+func (c *Client) patchEvent(id string) (content, author string, err error) {
+	ev, err := c.eventByID(id)
+	if err != nil {
+		return "", "", err
+	}
+	if ev.Kind != eventKindPatch {
+		return "", "", fmt.Errorf("event %s is kind %d, not a patch", short(id), ev.Kind)
+	}
+	if ok, _ := ev.CheckSignature(); !ok {
+		return "", "", fmt.Errorf("%w: patch %s has an invalid signature", ErrUntrusted, short(id))
+	}
+	if !strings.HasPrefix(ev.Content, "From ") {
+		return "", "", fmt.Errorf("patch %s is not in mailbox format", short(id))
+	}
+	return ev.Content, ev.PubKey, nil
+}
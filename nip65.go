@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// relayIndexers are asked for relay lists in addition to the client's own
+// relays, since many people publish their list there.
+var relayIndexers = []string{"wss://purplepag.es"}
+
+// relayList is a NIP-65 relay list: where its author reads and writes.
+type relayList struct {
+	Read, Write []string
+}
+
+// parseRelayList reads the "r" tags of a kind 10002 event. A relay without a
+// marker is used for both reading and writing.
+func parseRelayList(ev *nostr.Event) relayList {
+	var l relayList
+	for tag := range ev.Tags.FindAll("r") {
+		url := nostr.NormalizeURL(tag[1])
+		if !strings.HasPrefix(url, "wss://") && !strings.HasPrefix(url, "ws://") {
+			continue
+		}
+		marker := ""
+		if len(tag) > 2 {
+			marker = tag[2]
+		}
+		if marker == "" || marker == "read" {
+			l.Read = append(l.Read, url)
+		}
+		if marker == "" || marker == "write" {
+			l.Write = append(l.Write, url)
+		}
+	}
+	return l
+}
+
+// fetchRelayLists returns the newest validly signed relay list of each of
+// authors found on relays.
+func (c *Client) fetchRelayLists(authors, relays []string) map[string]relayList {
+	newest := make(map[string]*nostr.Event)
+	filter := nostr.Filter{Kinds: []int{nostr.KindRelayListMetadata}, Authors: authors}
+	for _, r := range relays {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+		events, err := c.Transport.Fetch(ctx, r, filter)
+		cancel()
+		if err != nil {
+			continue
+		}
+		for _, ev := range events {
+			if ok, _ := ev.CheckSignature(); !ok || !contains(authors, ev.PubKey) {
+				continue
+			}
+			if cur, ok := newest[ev.PubKey]; !ok || ev.CreatedAt > cur.CreatedAt {
+				newest[ev.PubKey] = ev
+			}
+		}
+	}
+	lists := make(map[string]relayList)
+	for pk, ev := range newest {
+		lists[pk] = parseRelayList(ev)
+	}
+	return lists
+}
+
+// addOutbox makes queries also go to the write relays author declares in
+// their relay list, where their events are to be found. It must be called
+// before the client first talks to a relay.
+func (c *Client) addOutbox(author string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !contains(c.outboxAuthors, author) {
+		c.outboxAuthors = append(c.outboxAuthors, author)
+	}
+}
+
+// discoverRelays looks up relay lists the first time relays are needed:
+// with useRelayList set the identity's write relays replace the configured
+// ones, and the write relays of every outbox author are added to the relays
+// queried. It must not call anything that discovers relays itself.
+func (c *Client) discoverRelays() {
+	c.discoverOnce.Do(func() {
+		c.mu.RLock()
+		authors := append([]string(nil), c.outboxAuthors...)
+		own := c.useRelayList
+		var bootstrap []string
+		for _, r := range append(append(append([]string(nil), c.relays...), c.readRelays...), relayIndexers...) {
+			if !contains(bootstrap, r) {
+				bootstrap = append(bootstrap, r)
+			}
+		}
+		c.mu.RUnlock()
+		if own && !contains(authors, c.Identity()) {
+			authors = append(authors, c.Identity())
+		}
+		if len(authors) == 0 {
+			return
+		}
+		lists := c.fetchRelayLists(authors, bootstrap)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if own && c.useRelayList {
+			if write := lists[c.Identity()].Write; len(write) > 0 {
+				c.relays = write
+				c.groups = nil
+			}
+		}
+		for _, a := range c.outboxAuthors {
+			for _, r := range lists[a].Write {
+				if len(c.readRelays) == 0 {
+					c.readRelays = append([]string(nil), c.relays...)
+				}
+				if !contains(c.readRelays, r) {
+					c.readRelays = append(c.readRelays, r)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// eventKindHTTPAuth is the NIP-98 HTTP authorization kind.
+const eventKindHTTPAuth = 27235
+
+// nip96Info is the part of a server's /.well-known/nostr/nip96.json orbi
+// needs.
+type nip96Info struct {
+	APIURL         string `json:"api_url"`
+	DelegatedToURL string `json:"delegated_to_url"`
+}
+
+// nip96Response is a NIP-96 upload response; the stored file is described by
+// the tags of a NIP-94 event.
+type nip96Response struct {
+	Status     string `json:"status"`
+	Message    string `json:"message"`
+	Nip94Event struct {
+		Tags nostr.Tags `json:"tags"`
+	} `json:"nip94_event"`
+}
+
+// nip96APIURL discovers where server accepts uploads, following one
+// delegation.
+func nip96APIURL(server string) (string, error) {
+	for i := 0; i < 2; i++ {
+		resp, err := (&http.Client{Timeout: httpTimeout}).Get(strings.TrimSuffix(server, "/") + "/.well-known/nostr/nip96.json")
+		if err != nil {
+			return "", err
+		}
+		var info nip96Info
+		err = json.NewDecoder(resp.Body).Decode(&info)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return "", fmt.Errorf("%s: %s", server, resp.Status)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: invalid NIP-96 information: %w", server, err)
+		}
+		if info.APIURL != "" {
+			return info.APIURL, nil
+		}
+		if info.DelegatedToURL == "" {
+			break
+		}
+		server = info.DelegatedToURL
+	}
+	return "", fmt.Errorf("%s does not announce a NIP-96 upload URL", server)
+}
+
+// httpAuth builds a NIP-98 Authorization header for a request to url whose
+// body hashes to payload.
+func (c *Client) httpAuth(method, url string, body []byte) (string, error) {
+	sum := sha256.Sum256(body)
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindHTTPAuth,
+		Tags: nostr.Tags{
+			{"u", url},
+			{"method", method},
+			{"payload", hex.EncodeToString(sum[:])},
+		},
+	}
+	if err := c.sign(&ev); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return "", err
+	}
+	return "Nostr " + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// nip96Upload stores data on a NIP-96 server, asking it not to transform the
+// file, and returns the URL it is served from.
+func (c *Client) nip96Upload(server string, data []byte, name string) (string, error) {
+	apiURL, err := nip96APIURL(server)
+	if err != nil {
+		return "", err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, err := w.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	fw.Write(data)
+	w.WriteField("content_type", orbi.DetectMIME(name, data))
+	w.WriteField("no_transform", "true")
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	auth, err := c.httpAuth(http.MethodPost, apiURL, body.Bytes())
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, apiURL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := (&http.Client{Timeout: httpTimeout}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result nip96Response
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode/100 != 2 || result.Status == "error" {
+		reason := result.Message
+		if reason == "" {
+			reason = resp.Status
+		}
+		return "", fmt.Errorf("%w: %s: %s", ErrRelayRejected, server, reason)
+	}
+	if decodeErr != nil {
+		return "", fmt.Errorf("%s: invalid upload response: %w", server, decodeErr)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	tags := result.Nip94Event.Tags
+	if x := tags.Find("x"); x != nil && x[1] != hash {
+		return "", fmt.Errorf("%s transformed %s (stored as %s, expected %s)", server, name, x[1], hash)
+	}
+	u := tags.Find("url")
+	if u == nil {
+		return "", fmt.Errorf("%s: upload response has no url", server)
+	}
+	return u[1], nil
+}
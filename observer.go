@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const chunkSize = 64 * 1024
+
+// Observer is notified as operations progress. Implementations must be safe
+// to call from multiple goroutines.
+type Observer interface {
+	// OnPublishStart is called once an event is signed and about to be sent.
+	OnPublishStart(ev *nostr.Event, relays []string)
+	// OnRelayResult reports the outcome of sending an event to one relay.
+	OnRelayResult(ev *nostr.Event, url string, err error)
+	// OnPublishDone is called with every relay's outcome once publishing
+	// has finished.
+	OnPublishDone(ev *nostr.Event, results []RelayResult)
+	// OnChunk reports bytes of a file transferred so far out of total.
+	OnChunk(path string, done, total int64)
+	// OnFetch is called for every event received from a relay.
+	OnFetch(url string, ev *nostr.Event)
+}
+
+// nopObserver ignores all callbacks.
+type nopObserver struct{}
+
+func (nopObserver) OnPublishStart(*nostr.Event, []string)     {}
+func (nopObserver) OnRelayResult(*nostr.Event, string, error) {}
+func (nopObserver) OnPublishDone(*nostr.Event, []RelayResult) {}
+func (nopObserver) OnChunk(string, int64, int64)              {}
+func (nopObserver) OnFetch(string, *nostr.Event)              {}
+
+// logObserver reports relay results as a table on standard error once
+// publishing has finished, unless -q was given. With -vv it also logs every
+// relay round-trip as it happens.
+type logObserver struct {
+	nopObserver
+}
+
+func (logObserver) OnPublishStart(ev *nostr.Event, relays []string) {
+	slog.Debug("Publishing event", "id", ev.ID, "kind", ev.Kind, "relays", len(relays))
+}
+
+func (logObserver) OnRelayResult(ev *nostr.Event, url string, err error) {
+	if err != nil {
+		slog.Log(context.Background(), levelTrace, "EVENT", "relay", url, "id", ev.ID, "err", err)
+		return
+	}
+	slog.Log(context.Background(), levelTrace, "EVENT", "relay", url, "id", ev.ID, "ok", true)
+}
+
+func (logObserver) OnFetch(url string, ev *nostr.Event) {
+	slog.Log(context.Background(), levelTrace, "REQ", "relay", url, "id", ev.ID, "kind", ev.Kind)
+}
+
+func (logObserver) OnPublishDone(ev *nostr.Event, results []RelayResult) {
+	if quiet() {
+		return
+	}
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RELAY\tTIME\tRESULT\t")
+	for _, r := range results {
+		result := "ok"
+		if r.Err != nil {
+			result = "failed: " + r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", r.URL, r.Elapsed.Round(time.Millisecond), result)
+	}
+	w.Flush()
+}
+
+// readFileObserved reads path in chunks, reporting progress to obs.
+func readFileObserved(obs Observer, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	total := info.Size()
+	data := make([]byte, 0, total)
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if n > 0 {
+			obs.OnChunk(path, int64(len(data)), total)
+		}
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -1,25 +1,28 @@
 package main
 
 import (
-	"context"
-	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/bquast/orbi/pkg/orbi"
 )
 
 const (
 	nostrSecretPathEnvVar  = "NOSTR_SECRET_PATH"
+	nostrSecretKeyEnvVar   = "NOSTR_SECRET_KEY"
 	defaultNostrSecretDir  = "~/.nostr"
 	defaultNostrSecretFile = "secret"
 	eventKindFile          = 4444
+	eventKindLatestFile    = 34444
 	defaultRelayTimeout    = 10 * time.Second
 	localOrbiDirName       = ".orbi"
 	trackedFilesFileName   = "tracked_files"
@@ -31,168 +34,554 @@ var defaultRelays = []string{
 	"wss://nos.lol",
 }
 
+// nostrSecretDir returns the directory holding the user's keys: ~/.nostr,
+// or nostr under %APPDATA% on Windows.
+func nostrSecretDir() string {
+	if runtime.GOOS == "windows" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			return filepath.Join(dir, "nostr")
+		}
+	}
+	return expandPath(defaultNostrSecretDir)
+}
+
 func expandPath(path string) string {
-	if strings.HasPrefix(path, "~/") {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
 		home, err := os.UserHomeDir()
 		if err != nil {
-			log.Fatalf("Error getting user home directory: %v", err)
+			fatal(fmt.Errorf("getting user home directory: %w", err))
 		}
-		path = filepath.Join(home, path[2:])
+		path = filepath.Join(home, strings.TrimPrefix(path[1:], string(filepath.Separator)))
 	}
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		log.Fatalf("Error getting absolute path for %s: %v", path, err)
+		fatal(fmt.Errorf("getting absolute path for %s: %w", path, err))
 	}
 	return absPath
 }
 
-func loadNostrSecretKey() (string, string, error) {
-	secretPath := expandPath(filepath.Join(defaultNostrSecretDir, defaultNostrSecretFile))
+// keyFileFlag is the secret key file given with --key-file.
+var keyFileFlag string
+
+// secretKeyPath returns where the user's secret key is read from: --key-file,
+// the active identity profile, $NOSTR_SECRET_PATH or ~/.nostr/secret.
+func secretKeyPath() string {
+	if keyFileFlag != "" {
+		return expandPath(keyFileFlag)
+	}
+	if name := activeProfile(); name != "" {
+		return profileKeyPath(name)
+	}
 	if envPath := os.Getenv(nostrSecretPathEnvVar); envPath != "" {
-		secretPath = expandPath(envPath)
+		return expandPath(envPath)
 	}
-	content, err := ioutil.ReadFile(secretPath)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to read secret key: %w", err)
+	return filepath.Join(nostrSecretDir(), defaultNostrSecretFile)
+}
+
+// envSecretKey returns $NOSTR_SECRET_KEY unless --key-file or --identity,
+// which take precedence, were given. It overrides the key files, so CI can
+// pass a key without writing it to disk.
+func envSecretKey() string {
+	if keyFileFlag != "" || identityFlag != "" {
+		return ""
 	}
-	skStr := strings.TrimSpace(string(content))
-	var sk string
-	if strings.HasPrefix(skStr, "nsec1") {
-		_, decoded, err := nip19.Decode(skStr)
+	return os.Getenv(nostrSecretKeyEnvVar)
+}
+
+// loadNostrSecretKey loads the user's secret key, in order of precedence
+// from --key-file, --identity, $NOSTR_SECRET_KEY, $NOSTR_SECRET_PATH, the
+// repository's identity profile or ~/.nostr/secret.
+func loadNostrSecretKey() (string, string, error) {
+	if s := envSecretKey(); s != "" {
+		sk, pk, err := orbi.ParseSecretKey(s, func() (string, error) {
+			return readPassphrase(fmt.Sprintf("Passphrase for $%s: ", nostrSecretKeyEnvVar))
+		})
 		if err != nil {
-			return "", "", err
+			return "", "", fmt.Errorf("%w: $%s: %v", ErrNoKey, nostrSecretKeyEnvVar, err)
 		}
-		sk = decoded.(string)
-	} else if len(skStr) == 64 {
-		if _, err := hex.DecodeString(skStr); err != nil {
-			return "", "", err
-		}
-		sk = skStr
-	} else {
-		return "", "", fmt.Errorf("invalid key format")
+		return sk, pk, nil
+	}
+	return readSecretKey(secretKeyPath())
+}
+
+// readSecretKey reads an nsec, hex or NIP-49 encrypted secret key from
+// secretPath and returns it in hex along with its public key.
+func readSecretKey(secretPath string) (string, string, error) {
+	content, err := ioutil.ReadFile(secretPath)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: failed to read secret key: %v", ErrNoKey, err)
+	}
+	if isBunkerURI(string(content)) {
+		return "", "", fmt.Errorf("%w: %s points at a remote signer; this needs the secret key itself", ErrNoKey, secretPath)
+	}
+	sk, pk, err := orbi.ParseSecretKey(string(content), func() (string, error) {
+		return readPassphrase(fmt.Sprintf("Passphrase for %s: ", secretPath))
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s: %v", ErrNoKey, secretPath, err)
 	}
-	pk, _ := nostr.GetPublicKey(sk)
 	return sk, pk, nil
 }
 
-func getTrackedFiles() ([]string, error) {
-	orbiDir := filepath.Join(".", localOrbiDirName)
-	trackedFilesPath := filepath.Join(orbiDir, trackedFilesFileName)
+// commands maps subcommand names to their implementations. Anything else on
+// the command line is treated as a file to publish.
+var commands = map[string]func(args []string) error{
+	"__complete":     cmdComplete,
+	"activity":       cmdActivity,
+	"add":            cmdAdd,
+	"am":             cmdAm,
+	"announce":       cmdAnnounce,
+	"approve":        cmdApprove,
+	"bench":          cmdBench,
+	"branch":         cmdBranch,
+	"bridge":         cmdBridge,
+	"changelog":      cmdChangelog,
+	"checkout":       cmdCheckout,
+	"ci-status":      cmdCIStatus,
+	"clone":          cmdClone,
+	"commit":         cmdCommit,
+	"completion":     cmdCompletion,
+	"diff":           cmdDiff,
+	"doctor":         cmdDoctor,
+	"flush":          cmdFlush,
+	"foreach":        cmdForeach,
+	"format-patch":   cmdFormatPatch,
+	"gateway":        cmdGateway,
+	"identity":       cmdIdentity,
+	"import-git":     cmdImportGit,
+	"init":           cmdInit,
+	"key":            cmdKey,
+	"keygen":         cmdKeygen,
+	"log":            cmdLog,
+	"migrate":        cmdMigrate,
+	"migrate-events": cmdMigrateEvents,
+	"policy":         cmdPolicy,
+	"pull":           cmdPull,
+	"push":           cmdPush,
+	"rebase":         cmdRebase,
+	"reflog":         cmdReflog,
+	"relay":          cmdRelay,
+	"release":        cmdRelease,
+	"resolve":        cmdResolve,
+	"rm":             cmdRm,
+	"status":         cmdStatus,
+	"switch":         cmdSwitch,
+	"tag":            cmdTag,
+	"undo":           cmdUndo,
+	"verify":         cmdVerify,
+	"watch":          cmdWatch,
+}
 
-	if _, err := os.Stat(trackedFilesPath); os.IsNotExist(err) {
-		return []string{}, nil
+// synopses are the usage lines of every command, the first one being
+// publishing a file. Shell completion reads its flags from them.
+var synopses = []string{
+	"orbi [-y] [--force] [-m <message>]... [--file <msgfile>] [--created-at <time>] [--charset <name>] [--compress <gzip|zstd>] [--private [--to <npub>]... [--hide-path]] [--json] [--min-relays <n>] [--pow <n>] [--dry-run] <file>",
+	"orbi activity [--author <npub>]... [--weeks <n>]",
+	"orbi add [--force] <file|dir|pattern>...",
+	"orbi am [<patch-file> | <patch-event-id>]...",
+	"orbi announce",
+	"orbi approve [--hash <sha256>] <file>",
+	"orbi bench [--no-size] [relay...]",
+	"orbi branch [create <name> | delete <name>]",
+	"orbi bridge github --repo <owner/name> [--branch <name>] [--no-import] [--no-export] [--issues]",
+	"orbi changelog [--since <event-id|time>] [--conventional] [--publish]",
+	"orbi checkout [--stdout] [--force] <event-id|file[@-n]> | --tag <name> [--force]",
+	"orbi ci-status <event-id> pending|success|failure [--url <logs>] [--context <name>]",
+	"orbi clone [--continue] [--policy <file>] <npub> [<dir>]",
+	"orbi commit -m <message> [--force] [--pow <n>]",
+	"orbi completion bash|zsh|fish",
+	"orbi diff [--color] [<file>...]",
+	"orbi doctor [--offline]",
+	"orbi flush",
+	"orbi foreach [--list] [--prune] [--] <command> [args...] | --shell '<command line>'",
+	"orbi format-patch [-o <dir>] [--stdout | --nostr] <since>[..<until>]",
+	"orbi gateway [--listen <addr>]",
+	"orbi identity add <name> <key-file>",
+	"orbi identity list",
+	"orbi identity use <name>",
+	"orbi import-git [--rev <rev>] [--head-only] <git-dir>",
+	"orbi init [--name <name>] [--relay <url>]... [--identity <name>] [--storage <mode> [--blossom-server <url>]...] [--keygen] [--no-announce] [--template <naddr> [--var key=value]...]",
+	"orbi key encrypt [--logn <n>] [<key-file>]",
+	"orbi key recover [-o <file>] <share-file>...",
+	"orbi key split [--threshold <k>] [--shares <n>] [--trustee <npub>]...",
+	"orbi key subkey [-o <file>]",
+	"orbi key unwrap <share-file>...",
+	"orbi keygen [-o <file>] [--no-encrypt] [--force] [--rotate]",
+	"orbi log [--reverse] [--json] <file>",
+	"orbi migrate",
+	"orbi migrate-events [--dry-run] [--map name=path]...",
+	"orbi policy check [--policy <file>] <event-id>...",
+	"orbi pull [--json]",
+	"orbi push [--all -m <message>] [--min-relays <n>] [--pow <n>] [--dry-run]",
+	"orbi rebase -i [--published [--since <event-id|time>]]",
+	"orbi reflog",
+	"orbi relay add [--global] [--read] <url>...",
+	"orbi relay list",
+	"orbi relay rm [--global] <url>...",
+	"orbi relay test [<url>...]",
+	"orbi release attach <version> [--platform <os/arch>] <file>...",
+	"orbi release download [--author <npub>] [-o <dir>] <version> [name]...",
+	"orbi release vX.Y.Z [--conventional] [--article]",
+	"orbi resolve [--pick <event-id> | --ours] [-m <message>] <file>",
+	"orbi rm [--remote-only | --local-only] <file>...",
+	"orbi status [--offline] [--json]",
+	"orbi switch <branch>",
+	"orbi tag [--allow-dirty] [-m <message>] [<name>]",
+	"orbi undo [<n>]",
+	"orbi verify [--author <npub>]... <file>...",
+	"orbi watch [--debounce <duration>]",
+}
+
+func usage() {
+	for i, s := range synopses {
+		if i == 0 {
+			fmt.Println("Usage: " + s)
+		} else {
+			fmt.Println("       " + s)
+		}
 	}
+	fmt.Println()
+	fmt.Println("Global options, given before the command:")
+	fmt.Println("  --identity <name>         use the named identity profile's key")
+	fmt.Println("  --key-file <file>         read the secret key from file; $NOSTR_SECRET_KEY can hold one too")
+	fmt.Println("  --passphrase-file <file>  read the passphrase of an encrypted (ncryptsec) key from file")
+	fmt.Println("  --proxy <url>             connect through a socks5:// or http:// proxy, such as Tor")
+	fmt.Println("  -q, --quiet               only print errors and the published event ID")
+	fmt.Println("  --reset-health            forget which relays have been failing")
+	fmt.Println("  --signer <bunker-uri>     sign with a NIP-46 remote signer instead of a local key")
+	fmt.Println("  -v, --verbose             print debug output; -vv also logs every relay round-trip")
+}
 
-	content, err := ioutil.ReadFile(trackedFilesPath)
-	if err != nil {
-		return nil, err
+// parseArgs parses flags from args, allowing them to appear before, between
+// or after positional arguments, and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// paragraphs collects repeated -m flags, each one a paragraph of the message.
+type paragraphs []string
+
+func (p *paragraphs) String() string { return strings.Join(*p, "\n\n") }
+
+func (p *paragraphs) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+// messageFlags registers -m/--message and --file on fs. The returned function
+// assembles the message once fs has been parsed.
+func messageFlags(fs *flag.FlagSet) func() (string, error) {
+	var msgs paragraphs
+	fs.Var(&msgs, "m", "message paragraph (repeatable)")
+	fs.Var(&msgs, "message", "message paragraph (repeatable)")
+	msgFile := fs.String("file", "", "read the message from a file (- for stdin)")
+	return func() (string, error) {
+		if *msgFile != "" && len(msgs) > 0 {
+			return "", fmt.Errorf("-m and --file are mutually exclusive")
+		}
+		if *msgFile == "" {
+			return msgs.String(), nil
+		}
+		var content []byte
+		var err error
+		if *msgFile == "-" {
+			content, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			content, err = ioutil.ReadFile(*msgFile)
+		}
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(content)), nil
 	}
+}
 
-	files := strings.Split(strings.TrimSpace(string(content)), "\n")
-	var result []string
-	for _, f := range files {
-		if f != "" {
-			result = append(result, f)
+// parseTime accepts RFC 3339 timestamps, plain dates, "YYYY-MM-DD HH:MM:SS"
+// in local time, or Unix seconds.
+func parseTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
 		}
 	}
-	return result, nil
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
 }
 
-func trackFile(filename string) error {
-	orbiDir := filepath.Join(".", localOrbiDirName)
-	if err := os.MkdirAll(orbiDir, 0755); err != nil {
+func cmdPublish(args []string) error {
+	fs := flag.NewFlagSet("orbi", flag.ContinueOnError)
+	createdAt := fs.String("created-at", "", "timestamp to record on the event instead of now")
+	charset := fs.String("charset", "", "encoding of the file on disk (utf-8, iso-8859-1, utf-16le, utf-16be)")
+	getMessage := messageFlags(fs)
+	var yes bool
+	fs.BoolVar(&yes, "y", false, "publish without asking for confirmation")
+	fs.BoolVar(&yes, "yes", false, "publish without asking for confirmation")
+	force := fs.Bool("force", false, "publish even if the relays have a newer version of the file")
+	private := fs.Bool("private", false, "encrypt the file with NIP-44 so only you and --to recipients can read it")
+	var recipients stringList
+	fs.Var(&recipients, "to", "npub that can decrypt a private file (repeatable; implies --private)")
+	hidePath := fs.Bool("hide-path", false, "publish the file name and message encrypted too (implies --private)")
+	jsonOut := fs.Bool("json", false, "print the published event and relay results as JSON (needs --yes)")
+	compression := fs.String("compress", "", "compress the content with gzip or zstd before publishing")
+	minRelays := fs.Int("min-relays", 0, "fail unless at least this many relays accept the event")
+	pow := fs.Int("pow", 0, "mine the event to this NIP-13 proof-of-work difficulty")
+	dryRun := fs.Bool("dry-run", false, "show the event that would be published without sending it")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
 		return err
 	}
-
-	trackedFilesPath := filepath.Join(orbiDir, trackedFilesFileName)
-	existing, err := getTrackedFiles()
+	if len(positional) == 0 {
+		usage()
+		return nil
+	}
+	message, err := getMessage()
 	if err != nil {
 		return err
 	}
+	if *jsonOut && !yes {
+		return fmt.Errorf("--json needs --yes, since the confirmation prompt would mix with the output")
+	}
 
-	baseFilename := filepath.Base(filename)
-	for _, f := range existing {
-		if f == baseFilename {
-			return nil // Already tracked
+	file := positional[0]
+	switch {
+	case len(positional) > 2:
+		return fmt.Errorf("unexpected arguments %q; quote the message or pass it with -m", positional[1:])
+	case len(positional) == 2 && message != "":
+		return fmt.Errorf("unexpected argument %q; the message was already given with -m or --file", positional[1])
+	case len(positional) == 2:
+		slog.Warn("A positional message is deprecated; use -m", "message", positional[1])
+		message = positional[1]
+	}
+
+	var opts []orbi.EventOption
+	if *createdAt != "" {
+		t, err := parseTime(*createdAt)
+		if err != nil {
+			return err
 		}
+		opts = append(opts, orbi.WithCreatedAt(t))
 	}
 
-	f, err := os.OpenFile(trackedFilesPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	if *charset != "" {
+		opts = append(opts, orbi.WithCharset(*charset))
+	}
+	if *compression != "" {
+		opts = append(opts, orbi.WithCompression(*compression))
 	}
-	defer f.Close()
 
-	if _, err := f.WriteString(baseFilename + "\n"); err != nil {
-		return err
+	if !*jsonOut && !quiet() {
+		fmt.Printf("Committing %s with message: \"%s\"\n", file, message)
 	}
-	return nil
-}
+	file = expandPath(file)
 
-func publishFile(filePath, sk, pk, message string) error {
-	content, err := ioutil.ReadFile(filePath)
+	if *dryRun {
+		skipAutoFlush = true
+	}
+	client, err := newCLIClient()
 	if err != nil {
 		return err
 	}
-
-	ev := nostr.Event{
-		PubKey:    pk,
-		CreatedAt: nostr.Now(),
-		Kind:      eventKindFile,
-		Content:   string(content),
-		Tags:      nostr.Tags{{"f", filepath.Base(filePath)}},
+	if !yes {
+		client.Confirm = promptConfirm
 	}
-	if message != "" {
-		ev.Tags = append(ev.Tags, nostr.Tag{"m", message})
+	client.Force = *force
+	client.DryRun = *dryRun
+	if *minRelays > 0 {
+		client.MinRelays = *minRelays
 	}
-	if err := ev.Sign(sk); err != nil {
+	if err := validPoW(*pow); err != nil {
 		return err
+	} else if *pow > 0 {
+		client.PoW = *pow
 	}
-
-	fmt.Println("Publishing file to relays...")
-	for _, r := range defaultRelays {
-		ctx, cancel := context.WithTimeout(context.Background(), defaultRelayTimeout)
-		defer cancel()
-		relay, err := nostr.RelayConnect(ctx, r)
-		if err != nil {
-			log.Printf("Failed to connect to %s: %v", r, err)
-			continue
+	recorder := &recordingObserver{}
+	if *jsonOut {
+		client.Observer = recorder
+	}
+	client.Quiet = *jsonOut || quiet()
+	if *private || *hidePath || len(recipients) > 0 {
+		// Always include ourselves so the file can be pulled back.
+		keys := []string{client.pk}
+		for _, r := range recipients {
+			pk, err := parsePubkey(r)
+			if err != nil {
+				return err
+			}
+			if !contains(keys, pk) {
+				keys = append(keys, pk)
+			}
+		}
+		opts = append(opts, orbi.WithEncryption(client.sk, keys...))
+		if *hidePath {
+			opts = append(opts, orbi.WithHiddenPath())
+		}
+	}
+	ev, err := client.PublishFile(file, message, opts...)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Println("Dry run: nothing was sent")
+		return nil
+	}
+	if !*jsonOut {
+		if quiet() {
+			fmt.Println(ev.ID)
 		}
-		relay.Publish(ctx, ev)
-		relay.Close()
-		log.Printf("Published to %s", r)
+		return nil
 	}
+	out := struct {
+		eventJSON
+		Hash   string            `json:"sha256"`
+		Relays []relayResultJSON `json:"relays"`
+	}{eventJSON: client.eventJSON(ev), Relays: relayResultsJSON(recorder.Results(ev.ID))}
+	if idx, err := client.Repo.Index(); err == nil {
+		if entry, ok := idx.Files[out.Path]; ok {
+			out.Hash = entry.Hash
+		}
+	}
+	return printJSON(out)
+}
 
-	if err := trackFile(filePath); err != nil {
-		log.Printf("Warning: Failed to track file locally: %v", err)
+// newCLIClient loads the user's key, or the repository's signing subkey when
+// one is configured, or connects to the user's remote signer, and opens the
+// repository in the current directory.
+func newCLIClient() (*Client, error) {
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	var signer Signer
+	var sk, pk, identity string
+	if uri := bunkerURI(); uri != "" && cfg.SigningKey == "" {
+		signer, pk, err = connectSigner(uri)
+	} else {
+		sk, pk, identity, err = loadSigningKey(cfg)
+	}
+	if err != nil {
+		return nil, err
 	}
+	client, err := newConfiguredClient(repo, cfg, sk, pk)
+	if err != nil {
+		return nil, err
+	}
+	client.signer = signer
+	client.identity = identity
+	client.autoFlush()
+	return client, nil
+}
 
-	fmt.Printf("\nSuccessfully published file %s\nEvent ID: %s\n", filepath.Base(filePath), ev.ID)
-	return nil
+// newConfiguredClient returns a client for sk that uses the relay settings
+// from cfg, falling back to the global config and then to the identity's
+// NIP-65 relay list when pk is known. The origin's relay list is consulted
+// for reading.
+func newConfiguredClient(repo *Repo, cfg *Config, sk, pk string) (*Client, error) {
+	cfg, err := withGlobalRelays(cfg)
+	if err != nil {
+		return nil, err
+	}
+	proxy := proxyFlag
+	if proxy == "" {
+		proxy = cfg.Proxy
+	}
+	if err := setupProxy(proxy); err != nil {
+		return nil, err
+	}
+	tlsConfigs, err := relayTLSConfigs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := newClient(repo, sk, pk)
+	client.Transport = orbi.WebsocketTransport{TLS: tlsConfigs, Auth: client.sign, Proxied: proxyURL != nil}
+	client.Timeout = relayTimeout(cfg)
+	client.Retry = cfg.Retry
+	client.MinRelays = cfg.MinRelays
+	client.MaxEventSize = cfg.MaxEventSize
+	client.PoW = cfg.PoW
+	client.RelayPoW = relayPoW(cfg)
+	client.previousKeys = cfg.previousKeys(pk)
+	if client.branch, err = repo.Branch(); err != nil {
+		return nil, err
+	}
+	if len(cfg.RelayGroups) > 0 {
+		client.SetRelayGroups(cfg.RelayGroups)
+	} else if len(cfg.WriteRelays) > 0 {
+		client.SetRelays(cfg.WriteRelays)
+	} else if pk != "" {
+		client.useRelayList = true
+	}
+	client.SetReadRelays(cfg.ReadRelays)
+	if cfg.Origin != "" {
+		origin, _ := parsePubkey(cfg.Origin)
+		client.addOutbox(origin)
+	}
+	return client, nil
 }
 
 func main() {
+	// Global options come before the command.
+	setupLogging()
+globals:
+	for len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "-q", "--quiet":
+			logLevel.Set(slog.LevelError)
+		case "-v", "--verbose":
+			logLevel.Set(slog.LevelDebug)
+		case "-vv":
+			logLevel.Set(levelTrace)
+		case "--reset-health":
+			if err := openRepo(".").ResetRelayHealth(); err != nil {
+				fatal(err)
+			}
+		default:
+			if len(os.Args) < 3 {
+				break globals
+			}
+			switch os.Args[1] {
+			case "--passphrase-file":
+				passphraseFile = os.Args[2]
+			case "--signer":
+				signerURI = os.Args[2]
+			case "--proxy":
+				proxyFlag = os.Args[2]
+			case "--identity":
+				identityFlag = os.Args[2]
+			case "--key-file":
+				keyFileFlag = os.Args[2]
+			default:
+				break globals
+			}
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: orbi <file> [message]")
+		usage()
 		return
 	}
 
-	file := os.Args[1]
-	var message string
-	if len(os.Args) > 2 {
-		message = os.Args[2]
-	}
-
-	fmt.Printf("Committing %s with message: \"%s\"\n", file, message)
-	file = expandPath(file)
-
-	sk, pk, err := loadNostrSecretKey()
-	if err != nil {
-		log.Fatal(err)
+	cmd, ok := commands[os.Args[1]]
+	args := os.Args[2:]
+	if !ok {
+		cmd, args = cmdPublish, os.Args[1:]
 	}
-
-	err = publishFile(file, sk, pk, message)
-	if err != nil {
-		log.Fatal(err)
+	if err := cmd(args); errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	} else if err != nil {
+		fatal(err)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The outbox holds signed events that have been committed locally but not
+// yet published, one JSON file per event in .orbi/outbox. Events that were
+// meant to be published right away but found no relay reachable are also
+// marked deferred, so the next command publishes them.
+const (
+	outboxDirName  = "outbox"
+	deferredSuffix = ".deferred"
+)
+
+func (r *Repo) outboxDir() string {
+	return filepath.Join(r.dir(), outboxDirName)
+}
+
+// Outbox returns the events waiting to be published, oldest first.
+func (r *Repo) Outbox() ([]*nostr.Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.outbox()
+}
+
+func (r *Repo) outbox() ([]*nostr.Event, error) {
+	files, err := ioutil.ReadDir(r.outboxDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var events []*nostr.Event
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(r.outboxDir(), f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		ev := &nostr.Event{}
+		if err := json.Unmarshal(content, ev); err != nil {
+			return nil, fmt.Errorf("invalid outbox entry %s: %w", f.Name(), err)
+		}
+		events = append(events, ev)
+	}
+	sort.Slice(events, func(i, j int) bool { return before(events[i], events[j]) })
+	return events, nil
+}
+
+// outboxIDs returns the ids of the queued events.
+func (r *Repo) outboxIDs() (map[string]bool, error) {
+	events, err := r.Outbox()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, ev := range events {
+		ids[ev.ID] = true
+	}
+	return ids, nil
+}
+
+// AddToOutbox queues a signed event for publishing.
+func (r *Repo) AddToOutbox(ev *nostr.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	return r.addToOutbox(ev)
+}
+
+func (r *Repo) addToOutbox(ev *nostr.Event) error {
+	if err := os.MkdirAll(r.outboxDir(), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.outboxDir(), ev.ID+".json"), content, 0644)
+}
+
+// Defer queues a signed event that could not be published for lack of a
+// reachable relay.
+func (r *Repo) Defer(ev *nostr.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	if err := r.addToOutbox(ev); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.outboxDir(), ev.ID+deferredSuffix), nil, 0644)
+}
+
+// Deferred returns the queued events that were deferred, oldest first.
+func (r *Repo) Deferred() ([]*nostr.Event, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events, err := r.outbox()
+	if err != nil {
+		return nil, err
+	}
+	var deferred []*nostr.Event
+	for _, ev := range events {
+		if _, err := os.Stat(filepath.Join(r.outboxDir(), ev.ID+deferredSuffix)); err == nil {
+			deferred = append(deferred, ev)
+		}
+	}
+	return deferred, nil
+}
+
+// RemoveFromOutbox drops a queued event, typically once it is published.
+func (r *Repo) RemoveFromOutbox(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.removeFromOutbox(id)
+}
+
+func (r *Repo) removeFromOutbox(id string) error {
+	for _, name := range []string{id + ".json", id + deferredSuffix} {
+		if err := os.Remove(filepath.Join(r.outboxDir(), name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceOutbox swaps the queued events for events, as after a rebase, and
+// moves the index entries headed by a replaced event as heads says.
+func (r *Repo) ReplaceOutbox(events []*nostr.Event, heads map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	old, err := r.outbox()
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if err := r.addToOutbox(ev); err != nil {
+			return err
+		}
+	}
+	keep := make(map[string]bool)
+	for _, ev := range events {
+		keep[ev.ID] = true
+	}
+	for _, ev := range old {
+		if !keep[ev.ID] {
+			if err := r.removeFromOutbox(ev.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return r.moveHeads("rebase", heads)
+}
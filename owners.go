@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	ownersFileName = "owners"
+	// eventKindApproval lets an owner approve specific content for a path
+	// they own.
+	eventKindApproval = 4447
+)
+
+// Ownership modes for the "owners_mode" config setting.
+const (
+	ownersWarn    = "warn"
+	ownersEnforce = "enforce"
+)
+
+// ownerRule maps a path pattern to the pubkeys that own it.
+type ownerRule struct {
+	pattern string
+	owners  []string
+}
+
+// Owners reads .orbi/owners, a CODEOWNERS-style file of lines
+//
+//	<pattern> <npub> [<npub>...]
+//
+// A missing file yields no rules.
+func (r *Repo) Owners() ([]ownerRule, error) {
+	f, err := os.Open(filepath.Join(r.dir(), ownersFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ownerRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := ownerRule{pattern: fields[0]}
+		for _, o := range fields[1:] {
+			pk, err := parsePubkey(o)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", ownersFileName, n, err)
+			}
+			rule.owners = append(rule.owners, pk)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ownersOf returns the owners of rel. As with CODEOWNERS the last matching
+// rule wins, and a rule without owners leaves the path unowned.
+func ownersOf(rules []ownerRule, rel string) []string {
+	var owners []string
+	for _, rule := range rules {
+		if matchGlob(rule.pattern, rel) {
+			owners = rule.owners
+		}
+	}
+	return owners
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// approved reports whether one of owners, or a subkey of one, has approved
+// content with the given hash for rel.
+func (c *Client) approved(rel, hash string, owners []string) bool {
+	// Approvals may come from owners' subkeys, so filter by signer after
+	// fetching rather than in the query.
+	approvals := c.query(nostr.Filter{
+		Kinds: []int{c.kinds("").Approval},
+		Tags:  nostr.TagMap{"f": []string{rel}, "x": []string{hash}},
+	})
+	for _, a := range approvals {
+		if ok, _ := a.CheckSignature(); ok && c.signsFor(a.PubKey, owners) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownsContent reports whether content with the given hash for rel may come
+// from author: the path is unowned, author owns it, or an owner approved it.
+// An empty author is someone unknown.
+func (c *Client) ownsContent(rules []ownerRule, rel, hash, author string) bool {
+	owners := ownersOf(rules, rel)
+	return len(owners) == 0 || (author != "" && c.signsFor(author, owners)) || c.approved(rel, hash, owners)
+}
+
+// checkOwnership warns or refuses, depending on the owners_mode setting,
+// when author submits content to a path they don't own without an owner's
+// approval. author is the client's identity for its own publishes, and the
+// submitter of a patch for orbi am.
+func (c *Client) checkOwnership(cfg *Config, rel, hash, author string) error {
+	rules, err := c.Repo.Owners()
+	if err != nil || len(rules) == 0 || c.ownsContent(rules, rel, hash, author) {
+		return err
+	}
+	who := "an unknown author"
+	if author != "" {
+		who = short(author)
+	}
+	if cfg.OwnersMode == ownersEnforce {
+		return fmt.Errorf("%w: %s is not owned by %s and has no owner approval for this content; an owner can give one with orbi approve", ErrUntrusted, rel, who)
+	}
+	slog.Warn("File is not owned by its author and has no owner approval for this content", "file", rel, "author", who)
+	return nil
+}
+
+// publishApproval records that the client's key approves content with hash
+// for rel.
+func (c *Client) publishApproval(rel, hash, message string) (*nostr.Event, error) {
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      c.kinds("").Approval,
+		Content:   message,
+		Tags: nostr.Tags{
+			{"ver", eventFormatVersion},
+			{"f", rel},
+			{"x", hash},
+		},
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func cmdApprove(args []string) error {
+	fs := flag.NewFlagSet("approve", flag.ContinueOnError)
+	hash := fs.String("hash", "", "SHA-256 of the content to approve (default: the local file)")
+	getMessage := messageFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: orbi approve [--hash <sha256>] <file>")
+	}
+	message, err := getMessage()
+	if err != nil {
+		return err
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	rel, err := client.Repo.Rel(positional[0])
+	if err != nil {
+		return err
+	}
+	if *hash == "" {
+		if *hash, err = hashFile(positional[0]); err != nil {
+			return err
+		}
+	}
+	ev, err := client.publishApproval(rel, *hash, message)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Approved %s at %s\nEvent ID: %s\n", rel, *hash, ev.ID)
+	return nil
+}
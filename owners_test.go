@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// ownedTestClient returns a client whose .orbi/owners gives a.txt to owner
+// and whose owners_mode is enforce, plus a stranger and a subkey of owner,
+// all on the same relay.
+func ownedTestClient(t *testing.T, owner *Client) (c, stranger, sub *Client) {
+	t.Helper()
+	c, transport := newTestClient(t)
+	stranger, _ = newTestClient(t)
+	sub, _ = newTestClient(t)
+	owner.Transport, stranger.Transport, sub.Transport = transport, transport, transport
+
+	npub, _ := nip19.EncodePublicKey(owner.pk)
+	if err := ioutil.WriteFile(filepath.Join(c.Repo.dir(), ownersFileName), []byte("a.txt "+npub+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.OwnersMode = ownersEnforce
+	if err := c.Repo.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owner.authorizeSubkey(sub.pk, c.repoName(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	return c, stranger, sub
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestApproved(t *testing.T) {
+	owner, _ := newTestClient(t)
+	c, stranger, sub := ownedTestClient(t, owner)
+	hash := sha256Hex("hello\n")
+	owners := []string{owner.pk}
+
+	if c.approved("a.txt", hash, owners) {
+		t.Error("approved without any approval")
+	}
+	if _, err := stranger.publishApproval("a.txt", hash, ""); err != nil {
+		t.Fatal(err)
+	}
+	if c.approved("a.txt", hash, owners) {
+		t.Error("a stranger's approval counted")
+	}
+	if _, err := sub.publishApproval("a.txt", hash, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !c.approved("a.txt", hash, owners) {
+		t.Error("an owner's subkey's approval didn't count")
+	}
+	if c.approved("a.txt", sha256Hex("other\n"), owners) {
+		t.Error("an approval counted for other content")
+	}
+}
+
+func TestApplyMailPatchChecksSubmitter(t *testing.T) {
+	patch := mailPatch{message: "add a.txt", files: []filePatch{{
+		newName: "a.txt",
+		hunks:   []hunk{{oldStart: 0, ops: []diffOp{{'+', "hello\n"}}}},
+	}}}
+	tests := []struct {
+		name      string
+		submitter string // "owner", "sub", "stranger" or ""
+		approved  bool
+		ok        bool
+	}{
+		{"owner", "owner", false, true},
+		{"owner's subkey", "sub", false, true},
+		{"stranger", "stranger", false, false},
+		{"unknown", "", false, false},
+		{"stranger with approval", "stranger", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The applying client owns a.txt, which mustn't let others'
+			// patches through.
+			owner, _ := newTestClient(t)
+			c, stranger, sub := ownedTestClient(t, owner)
+			c.sk, c.pk = owner.sk, owner.pk
+			keys := map[string]string{"owner": owner.pk, "sub": sub.pk, "stranger": stranger.pk}
+			if tt.approved {
+				if _, err := owner.publishApproval("a.txt", sha256Hex("hello\n"), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cfg, err := c.Repo.Config()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := patch
+			p.submitter = keys[tt.submitter]
+			err = c.applyMailPatch(cfg, p)
+			if (err == nil) != tt.ok {
+				t.Fatalf("got error %v, want ok=%v", err, tt.ok)
+			}
+			if err != nil && !errors.Is(err, ErrUntrusted) {
+				t.Errorf("got %v, want ErrUntrusted", err)
+			}
+		})
+	}
+}
+
+func TestPolicyCheckOwners(t *testing.T) {
+	owner, _ := newTestClient(t)
+	c, stranger, sub := ownedTestClient(t, owner)
+	if _, err := owner.publishApproval("a.txt", sha256Hex("approved\n"), ""); err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{}
+	for _, tt := range []struct {
+		name string
+		ev   *nostr.Event
+		ok   bool
+	}{
+		{"owner", testEvent(t, owner, "a.txt", "one\n", 1700000000), true},
+		{"owner's subkey", testEvent(t, sub, "a.txt", "one\n", 1700000000), true},
+		{"stranger", testEvent(t, stranger, "a.txt", "one\n", 1700000000), false},
+		{"stranger with approval", testEvent(t, stranger, "a.txt", "approved\n", 1700000000), true},
+		{"unowned path", testEvent(t, stranger, "b.txt", "one\n", 1700000000), true},
+		{"hidden path", testEvent(t, stranger, "a.txt", "one\n", 1700000000, orbi.WithEncryption(stranger.sk, stranger.pk, c.pk), orbi.WithHiddenPath()), false},
+	} {
+		err := policy.Check(c, tt.ev)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got error %v, want ok=%v", tt.name, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrUntrusted) {
+			t.Errorf("%s: got %v, want ErrUntrusted", tt.name, err)
+		}
+	}
+}
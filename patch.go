@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// parentContent returns the content of the version ev was built on, or ""
+// for the first version of a file.
+func (c *Client) parentContent(ev *nostr.Event) (string, bool, error) {
+	parents := eventParents(ev)
+	if len(parents) == 0 {
+		return "", false, nil
+	}
+	events := c.query(nostr.Filter{IDs: parents[:1]})
+	if len(events) == 0 {
+		return "", false, fmt.Errorf("parent %s of %s: %w", parents[0], ev.ID, ErrNotFound)
+	}
+	content, err := c.eventContent(events[0])
+	return string(content), true, err
+}
+
+// formatPatch renders ev as message i of n in git's mailbox patch format.
+func (c *Client) formatPatch(ev *nostr.Event, i, n int) (string, error) {
+	content, err := c.eventContent(ev)
+	if err != nil {
+		return "", err
+	}
+	if !orbi.IsText(content) {
+		return "", fmt.Errorf("%s (%s) is binary", eventPath(ev), short(ev.ID))
+	}
+	old, existed, err := c.parentContent(ev)
+	if err != nil {
+		return "", err
+	}
+	oldName := eventPath(ev)
+	if !existed {
+		oldName = ""
+	}
+
+	message := eventMessage(ev)
+	if message == "" {
+		message = "Update " + eventPath(ev)
+	}
+	parts := strings.SplitN(message, "\n", 2)
+	npub, _ := nip19.EncodePublicKey(ev.PubKey)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s Mon Sep 17 00:00:00 2001\n", ev.ID)
+	fmt.Fprintf(&b, "From: %s <%s@nostr>\n", short(npub[5:]), npub)
+	fmt.Fprintf(&b, "Date: %s\n", ev.CreatedAt.Time().Format(time.RFC1123Z))
+	if n > 1 {
+		fmt.Fprintf(&b, "Subject: [PATCH %d/%d] %s\n\n", i, n, parts[0])
+	} else {
+		fmt.Fprintf(&b, "Subject: [PATCH] %s\n\n", parts[0])
+	}
+	if len(parts) == 2 {
+		b.WriteString(strings.TrimSpace(parts[1]) + "\n")
+	}
+	b.WriteString("---\n\n")
+	b.WriteString(unifiedDiff(oldName, eventPath(ev), old, string(content)))
+	b.WriteString("-- \norbi\n\n")
+	return b.String(), nil
+}
+
+// patchRange resolves "<from>..<to>", "<from>.." or "<from>" (event ids or
+// times, from exclusive) to the client's commits in that range, oldest first.
+func (c *Client) patchRange(r string) ([]*nostr.Event, error) {
+	from, to := r, ""
+	if i := strings.Index(r, ".."); i >= 0 {
+		from, to = r[:i], r[i+2:]
+	}
+	since, err := c.resolveSince(from)
+	if err != nil {
+		return nil, err
+	}
+	events := c.History("", since)
+	if to == "" {
+		return events, nil
+	}
+	for i, ev := range events {
+		if ev.ID == to {
+			return events[:i+1], nil
+		}
+	}
+	return nil, fmt.Errorf("event %s in range: %w", to, ErrNotFound)
+}
+
+var nonSlug = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func patchFileName(i int, subject string) string {
+	slug := strings.Trim(nonSlug.ReplaceAllString(subject, "-"), "-")
+	if len(slug) > 52 {
+		slug = strings.TrimRight(slug[:52], "-")
+	}
+	return fmt.Sprintf("%04d-%s.patch", i, slug)
+}
+
+func cmdFormatPatch(args []string) error {
+	fs := flag.NewFlagSet("format-patch", flag.ContinueOnError)
+	outDir := fs.String("o", ".", "directory to write the patch files to")
+	stdout := fs.Bool("stdout", false, "print all patches as one mailbox instead of writing files")
+	publish := fs.Bool("nostr", false, "publish the patches as NIP-34 patch events instead of writing files")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: orbi format-patch [-o <dir>] [--stdout | --nostr] <since>[..<until>]")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	events, err := client.patchRange(positional[0])
+	if err != nil {
+		return err
+	}
+	if *publish {
+		patches, err := client.publishPatches(events)
+		for _, p := range patches {
+			note, _ := nip19.EncodeNote(p.ID)
+			fmt.Println(note)
+		}
+		return err
+	}
+	for i, ev := range events {
+		patch, err := client.formatPatch(ev, i+1, len(events))
+		if err != nil {
+			slog.Warn("Skipping event", "id", short(ev.ID), "err", err)
+			continue
+		}
+		if *stdout {
+			fmt.Print(patch)
+			continue
+		}
+		subject := strings.SplitN(eventMessage(ev), "\n", 2)[0]
+		if subject == "" {
+			subject = "Update " + eventPath(ev)
+		}
+		name := filepath.Join(*outDir, patchFileName(i+1, subject))
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(name, []byte(patch), 0644); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+// mailPatch is one message of a mailbox of patches.
+type mailPatch struct {
+	from string
+	// submitter is the pubkey that signed the patch event the mailbox came
+	// from. It is empty for mailboxes read from files, whose From header
+	// anyone can forge.
+	submitter string
+	date      time.Time
+	message   string
+	files     []filePatch
+}
+
+// filePatch is the part of a patch touching one file.
+type filePatch struct {
+	oldName, newName string
+	hunks            []hunk
+}
+
+var (
+	mboxFromLine  = regexp.MustCompile(`^From [0-9a-f]{40,64} `)
+	patchPrefix   = regexp.MustCompile(`^\[PATCH[^\]]*\]\s*`)
+	hunkHeaderExp = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+)
+
+// parseMailbox splits a mailbox into patches.
+func parseMailbox(content string) ([]mailPatch, error) {
+	var messages []string
+	var cur strings.Builder
+	for _, line := range splitLines(content) {
+		if mboxFromLine.MatchString(line) && cur.Len() > 0 {
+			messages = append(messages, cur.String())
+			cur.Reset()
+		}
+		if !mboxFromLine.MatchString(line) {
+			cur.WriteString(line)
+		}
+	}
+	if strings.TrimSpace(cur.String()) != "" {
+		messages = append(messages, cur.String())
+	}
+
+	var patches []mailPatch
+	for i, raw := range messages {
+		msg, err := mail.ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("patch %d: %w", i+1, err)
+		}
+		body, err := ioutil.ReadAll(msg.Body)
+		if err != nil {
+			return nil, err
+		}
+		p := mailPatch{from: msg.Header.Get("From")}
+		if d, err := msg.Header.Date(); err == nil {
+			p.date = d
+		}
+		subject := patchPrefix.ReplaceAllString(msg.Header.Get("Subject"), "")
+		text, diff := string(body), ""
+		if i := strings.Index(text, "\n---\n"); i >= 0 {
+			text, diff = text[:i], text[i+5:]
+		} else if strings.HasPrefix(text, "---\n") {
+			text, diff = "", text[4:]
+		}
+		p.message = strings.TrimSpace(subject + "\n\n" + strings.TrimSpace(text))
+		if p.files, err = parseDiff(diff); err != nil {
+			return nil, fmt.Errorf("patch %d: %w", i+1, err)
+		}
+		patches = append(patches, p)
+	}
+	return patches, nil
+}
+
+// parseDiff reads the file sections of a git-style unified diff.
+func parseDiff(diff string) ([]filePatch, error) {
+	var files []filePatch
+	var cur *filePatch
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files = append(files, filePatch{})
+			cur = &files[len(files)-1]
+		case cur == nil:
+		case strings.HasPrefix(line, "--- ") && len(cur.hunks) == 0:
+			cur.oldName = diffName(line[4:], "a/")
+		case strings.HasPrefix(line, "+++ ") && len(cur.hunks) == 0:
+			cur.newName = diffName(line[4:], "b/")
+		case strings.HasPrefix(line, "@@ "):
+			m := hunkHeaderExp.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("invalid hunk header %q", line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			cur.hunks = append(cur.hunks, hunk{oldStart: start})
+		case len(cur.hunks) > 0 && line == "-- ":
+			cur = nil
+		case len(cur.hunks) > 0 && strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file" applies to the previous line.
+			h := &cur.hunks[len(cur.hunks)-1]
+			if len(h.ops) > 0 {
+				last := &h.ops[len(h.ops)-1]
+				last.line = strings.TrimSuffix(last.line, "\n")
+			}
+		case len(cur.hunks) > 0 && line != "" && strings.ContainsRune(" -+", rune(line[0])):
+			h := &cur.hunks[len(cur.hunks)-1]
+			h.ops = append(h.ops, diffOp{line[0], line[1:] + "\n"})
+		case len(cur.hunks) > 0 && line == "":
+			// Some mailers strip the space from empty context lines.
+			h := &cur.hunks[len(cur.hunks)-1]
+			h.ops = append(h.ops, diffOp{' ', "\n"})
+		}
+	}
+	return files, scanner.Err()
+}
+
+func diffName(s, prefix string) string {
+	s = strings.TrimSpace(strings.SplitN(s, "\t", 2)[0])
+	if s == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(s, prefix)
+}
+
+// applyMailPatch applies p to the working copy and publishes every file it
+// touches. Files the submitter doesn't own need an owner's approval, as the
+// owners_mode setting says, even when the client's key owns them.
+func (c *Client) applyMailPatch(cfg *Config, p mailPatch) error {
+	var opts []orbi.EventOption
+	if !p.date.IsZero() {
+		opts = append(opts, orbi.WithCreatedAt(p.date))
+	}
+	npub, _ := nip19.EncodePublicKey(c.Identity())
+	if p.from != "" && !strings.Contains(p.from, npub) {
+		opts = append(opts, orbi.WithExtraTags(nostr.Tag{"author", p.from}))
+	}
+	for _, f := range p.files {
+		if f.newName == "" {
+			return fmt.Errorf("deleting %s is not supported", f.oldName)
+		}
+		if err := checkRel(f.newName); err != nil {
+			return err
+		}
+		var old []byte
+		if f.oldName != "" {
+			var err error
+			if old, err = ioutil.ReadFile(c.Repo.Abs(f.oldName)); err != nil {
+				return err
+			}
+		}
+		updated, err := applyHunks(string(old), f.hunks)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.newName, err)
+		}
+		sum := sha256.Sum256([]byte(updated))
+		if err := c.checkOwnership(cfg, f.newName, hex.EncodeToString(sum[:]), p.submitter); err != nil {
+			return err
+		}
+		if err := c.Repo.WriteFile(f.newName, []byte(updated), 0644); err != nil {
+			return err
+		}
+		if _, err := c.PublishFile(c.Repo.Abs(f.newName), p.message, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdAm(args []string) error {
+	fs := flag.NewFlagSet("am", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		positional = []string{"-"}
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	for _, name := range positional {
+		var content []byte
+		var submitter string
+		if name == "-" {
+			content, err = ioutil.ReadAll(os.Stdin)
+		} else if id, ok := parseEventID(name); ok {
+			var patch string
+			patch, submitter, err = client.patchEvent(id)
+			content = []byte(patch)
+		} else {
+			content, err = ioutil.ReadFile(name)
+		}
+		if err != nil {
+			return err
+		}
+		patches, err := parseMailbox(string(content))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, p := range patches {
+			p.submitter = submitter
+			fmt.Printf("Applying: %s\n", strings.SplitN(p.message, "\n", 2)[0])
+			if err := client.applyMailPatch(cfg, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
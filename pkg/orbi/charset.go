@@ -0,0 +1,93 @@
+package orbi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Charsets orbi can convert to and from UTF-8. Event content is always UTF-8;
+// the "charset" tag records what the file was originally encoded in.
+const (
+	CharsetUTF8    = "utf-8"
+	CharsetLatin1  = "iso-8859-1"
+	CharsetUTF16LE = "utf-16le"
+	CharsetUTF16BE = "utf-16be"
+)
+
+// CanonicalCharset maps common aliases to the names used in tags.
+func CanonicalCharset(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "utf-8", "utf8":
+		return CharsetUTF8, nil
+	case "iso-8859-1", "latin1", "latin-1":
+		return CharsetLatin1, nil
+	case "utf-16le", "utf16le":
+		return CharsetUTF16LE, nil
+	case "utf-16be", "utf16be":
+		return CharsetUTF16BE, nil
+	}
+	return "", fmt.Errorf("unsupported charset %q", name)
+}
+
+// ToUTF8 decodes content from charset into UTF-8.
+func ToUTF8(content []byte, charset string) ([]byte, error) {
+	switch charset {
+	case CharsetUTF8:
+		if !utf8.Valid(content) {
+			return nil, fmt.Errorf("content is not valid UTF-8")
+		}
+		return content, nil
+	case CharsetLatin1:
+		runes := make([]rune, len(content))
+		for i, b := range content {
+			runes[i] = rune(b)
+		}
+		return []byte(string(runes)), nil
+	case CharsetUTF16LE, CharsetUTF16BE:
+		if len(content)%2 != 0 {
+			return nil, fmt.Errorf("odd number of bytes in %s content", charset)
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if charset == CharsetUTF16BE {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, len(content)/2)
+		for i := range units {
+			units[i] = order.Uint16(content[2*i:])
+		}
+		return []byte(string(utf16.Decode(units))), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
+
+// FromUTF8 encodes UTF-8 content back into charset.
+func FromUTF8(content []byte, charset string) ([]byte, error) {
+	switch charset {
+	case CharsetUTF8:
+		return content, nil
+	case CharsetLatin1:
+		out := make([]byte, 0, len(content))
+		for _, r := range string(content) {
+			if r > 0xff {
+				return nil, fmt.Errorf("character %q cannot be represented in %s", r, charset)
+			}
+			out = append(out, byte(r))
+		}
+		return out, nil
+	case CharsetUTF16LE, CharsetUTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		if charset == CharsetUTF16BE {
+			order = binary.BigEndian
+		}
+		units := utf16.Encode([]rune(string(content)))
+		out := make([]byte, 2*len(units))
+		for i, u := range units {
+			order.PutUint16(out[2*i:], u)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
@@ -0,0 +1,65 @@
+package orbi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CheckCompression returns an error unless alg is a supported value of the
+// "compression" tag.
+func CheckCompression(alg string) error {
+	switch alg {
+	case CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unsupported compression %q: must be gzip or zstd", alg)
+}
+
+// compress compresses data with alg.
+func compress(alg string, data []byte) ([]byte, error) {
+	switch alg {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		return enc.EncodeAll(data, nil), nil
+	}
+	return nil, CheckCompression(alg)
+}
+
+// decompress reverses compress.
+func decompress(alg string, data []byte) ([]byte, error) {
+	switch alg {
+	case CompressionGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return ioutil.ReadAll(zr)
+	case CompressionZstd:
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(data, nil)
+	}
+	return nil, CheckCompression(alg)
+}
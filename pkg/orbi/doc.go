@@ -0,0 +1,8 @@
+// Package orbi implements the orbi event format and the pieces needed to
+// work with it from other tools: building file events with their tags,
+// compression, encryption and hidden paths, decoding them again, reading the
+// secret keys that sign them, publishing to and fetching from relays through
+// a Transport, and the tracking Index of a working copy. Choosing relays,
+// retries, the outbox and the rest of the .orbi directory stay in the orbi
+// command, which is built on this package.
+package orbi
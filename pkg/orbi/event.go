@@ -0,0 +1,618 @@
+package orbi
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// FormatVersion is stamped on every event in a "ver" tag.
+const FormatVersion = "1"
+
+// DefaultBranch is the branch of events without a "branch" tag.
+const DefaultBranch = "main"
+
+// Values of the "compression", "encrypted" and "encoding" tags.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	EncryptionNIP44 = "nip44"
+	EncodingBase64  = "base64"
+)
+
+// Storage backends for file content, recorded in the "storage" tag of
+// events whose content lives off-relay.
+const (
+	StorageInline  = "inline"
+	StorageBlossom = "blossom"
+	StorageNIP96   = "nip96"
+)
+
+// eventBuilder accumulates the settings applied by EventOptions.
+type eventBuilder struct {
+	path        string
+	message     string
+	sk          string
+	recipients  []string
+	compression string
+	hidePath    bool
+	storage     string
+	blobHash    string
+	blobServers []string
+	blobURL     string
+	mode        os.FileMode
+	modTime     int64
+	branch      string
+	deltaBase   string
+	delta       string
+	deltaDepth  int
+	parents     []string
+	createdAt   nostr.Timestamp
+	charset     string
+	extra       nostr.Tags
+}
+
+// EventOption configures an event built by BuildEvent.
+type EventOption func(*eventBuilder) error
+
+// WithPath sets the repo-relative path recorded in the "f" tag.
+func WithPath(path string) EventOption {
+	return func(b *eventBuilder) error {
+		if path == "" {
+			return fmt.Errorf("empty path")
+		}
+		b.path = path
+		return nil
+	}
+}
+
+// WithMessage sets the commit message recorded in the "m" tag.
+func WithMessage(message string) EventOption {
+	return func(b *eventBuilder) error {
+		b.message = message
+		return nil
+	}
+}
+
+// WithEncryption encrypts the content with NIP-44 so that only the given
+// recipients (hex pubkeys) can read it. sk is the author's secret key.
+func WithEncryption(sk string, recipients ...string) EventOption {
+	return func(b *eventBuilder) error {
+		if sk == "" {
+			return fmt.Errorf("encryption needs the secret key, which a remote signer doesn't share")
+		}
+		if len(recipients) == 0 {
+			return fmt.Errorf("encryption requires at least one recipient")
+		}
+		for _, r := range recipients {
+			if !nostr.IsValidPublicKey(r) {
+				return fmt.Errorf("invalid recipient pubkey %q", r)
+			}
+		}
+		b.sk = sk
+		b.recipients = recipients
+		return nil
+	}
+}
+
+// WithHiddenPath publishes the path as a keyed hash and moves the real path
+// and message into the encrypted envelope, leaving no file metadata in the
+// clear. It requires WithEncryption.
+func WithHiddenPath() EventOption {
+	return func(b *eventBuilder) error {
+		b.hidePath = true
+		return nil
+	}
+}
+
+// WithBlob leaves the content out of the event and points to the blob with
+// the given SHA-256 in storage instead: on the given Blossom servers, or at
+// a URL added with WithBlobURL for NIP-96. Encrypted events ignore it and
+// keep their content inline.
+func WithBlob(storage, hash string, servers []string) EventOption {
+	return func(b *eventBuilder) error {
+		switch storage {
+		case StorageBlossom:
+			if len(servers) == 0 {
+				return fmt.Errorf("blossom storage requires at least one server")
+			}
+		case StorageNIP96:
+		default:
+			return fmt.Errorf("unknown storage %q", storage)
+		}
+		b.storage = storage
+		b.blobHash = hash
+		b.blobServers = servers
+		return nil
+	}
+}
+
+// WithBlobURL records where a blob was stored.
+func WithBlobURL(url string) EventOption {
+	return func(b *eventBuilder) error {
+		b.blobURL = url
+		return nil
+	}
+}
+
+// WithDelta publishes diff, a unified diff from the content of the version
+// with id base to this one, instead of the content when that is smaller.
+// depth counts the deltas in the chain ending with this one.
+func WithDelta(base, diff string, depth int) EventOption {
+	return func(b *eventBuilder) error {
+		if !nostr.IsValid32ByteHex(base) {
+			return fmt.Errorf("invalid delta base event id %q", base)
+		}
+		if depth < 1 {
+			return fmt.Errorf("invalid delta depth %d", depth)
+		}
+		b.deltaBase = base
+		b.delta = diff
+		b.deltaDepth = depth
+		return nil
+	}
+}
+
+// WithCompression compresses the content with the named algorithm, gzip or
+// zstd. It is skipped when it wouldn't make the event smaller.
+func WithCompression(alg string) EventOption {
+	return func(b *eventBuilder) error {
+		if err := CheckCompression(alg); err != nil {
+			return err
+		}
+		b.compression = alg
+		return nil
+	}
+}
+
+// WithFileInfo records the permission bits and modification time of the
+// file, so they can be restored when it is written back.
+func WithFileInfo(mode os.FileMode, modTime time.Time) EventOption {
+	return func(b *eventBuilder) error {
+		b.mode = mode.Perm()
+		b.modTime = modTime.Unix()
+		return nil
+	}
+}
+
+// WithBranch tags the event with the branch it was committed on.
+func WithBranch(name string) EventOption {
+	return func(b *eventBuilder) error {
+		b.branch = name
+		return nil
+	}
+}
+
+// EventBranch returns the branch ev was committed on.
+func EventBranch(ev *nostr.Event) string {
+	if tag := ev.Tags.Find("branch"); tag != nil && tag[1] != "" {
+		return tag[1]
+	}
+	return DefaultBranch
+}
+
+// WithParent links the event to the previous version of the same file.
+// Giving several parents records a merge of divergent versions.
+func WithParent(id string) EventOption {
+	return func(b *eventBuilder) error {
+		if !nostr.IsValid32ByteHex(id) {
+			return fmt.Errorf("invalid parent event id %q", id)
+		}
+		for _, p := range b.parents {
+			if p == id {
+				return nil
+			}
+		}
+		b.parents = append(b.parents, id)
+		return nil
+	}
+}
+
+// WithCreatedAt overrides the event timestamp, so that history imported from
+// git or backups keeps its original dates.
+func WithCreatedAt(t time.Time) EventOption {
+	return func(b *eventBuilder) error {
+		if t.After(time.Now().Add(time.Hour)) {
+			return fmt.Errorf("created-at %s is in the future", t.Format(time.RFC3339))
+		}
+		b.createdAt = nostr.Timestamp(t.Unix())
+		return nil
+	}
+}
+
+// WithCharset declares the encoding content is stored in on disk. It is
+// converted to UTF-8 for publishing and back when restored.
+func WithCharset(name string) EventOption {
+	return func(b *eventBuilder) error {
+		charset, err := CanonicalCharset(name)
+		if err != nil {
+			return err
+		}
+		b.charset = charset
+		return nil
+	}
+}
+
+// WithExtraTags appends tags that have no dedicated option.
+func WithExtraTags(tags ...nostr.Tag) EventOption {
+	return func(b *eventBuilder) error {
+		b.extra = append(b.extra, tags...)
+		return nil
+	}
+}
+
+// BuildEvent constructs an unsigned event of the given kind from content,
+// applying opts in order. Compression happens before encryption, and any
+// transformation is recorded in tags so DecodeContent can reverse it.
+func BuildEvent(pk string, kind int, content []byte, opts ...EventOption) (nostr.Event, error) {
+	var b eventBuilder
+	for _, opt := range opts {
+		if err := opt(&b); err != nil {
+			return nostr.Event{}, err
+		}
+	}
+	if b.hidePath && len(b.recipients) == 0 {
+		return nostr.Event{}, fmt.Errorf("hiding the path requires encryption")
+	}
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: nostr.Now(),
+		Kind:      kind,
+		Tags:      nostr.Tags{{"ver", FormatVersion}},
+	}
+	if b.createdAt != 0 {
+		ev.CreatedAt = b.createdAt
+	}
+	// Addressable events replace each other per "d" tag, so branches other
+	// than the default one get their own.
+	dTag := func(f string) nostr.Tag {
+		if b.branch != "" && b.branch != DefaultBranch {
+			f += "@" + b.branch
+		}
+		return nostr.Tag{"d", f}
+	}
+	if b.hidePath {
+		tag, err := HiddenPathTag(b.sk, b.path)
+		if err != nil {
+			return nostr.Event{}, err
+		}
+		ev.Tags = append(ev.Tags, nostr.Tag{"f", tag})
+		if nostr.IsAddressableKind(kind) {
+			ev.Tags = append(ev.Tags, dTag(tag))
+		}
+	} else {
+		if b.path != "" {
+			ev.Tags = append(ev.Tags, nostr.Tag{"f", b.path})
+			if nostr.IsAddressableKind(kind) {
+				ev.Tags = append(ev.Tags, dTag(b.path))
+			}
+		}
+		if b.message != "" {
+			ev.Tags = append(ev.Tags, nostr.Tag{"m", b.message})
+		}
+	}
+	if b.branch != "" {
+		ev.Tags = append(ev.Tags, nostr.Tag{"branch", b.branch})
+	}
+	for _, parent := range b.parents {
+		ev.Tags = append(ev.Tags, nostr.Tag{"e", parent, "", "parent"})
+	}
+	ev.Tags = append(ev.Tags, b.extra...)
+
+	var meta FileMetadata
+	if b.path != "" {
+		meta = DescribeFile(b.path, content)
+		meta.Mode, meta.MTime = b.mode, b.modTime
+		if !b.hidePath {
+			ev.Tags = append(ev.Tags, meta.Tags()...)
+		}
+	}
+	// The hash of the original bytes lets readers detect corrupted or
+	// truncated content. Blobs carry it already, and encrypted events leave
+	// it out since it would let anyone confirm a guess at the plaintext.
+	if b.blobHash == "" && len(b.recipients) == 0 {
+		ev.Tags = append(ev.Tags, nostr.Tag{"x", ContentHash(content)})
+	}
+
+	// Blobs are stored byte for byte, so none of the transformations below
+	// apply.
+	if b.blobHash != "" && len(b.recipients) == 0 {
+		ev.Tags = append(ev.Tags, nostr.Tag{"storage", b.storage}, nostr.Tag{"x", b.blobHash})
+		if b.blobURL != "" {
+			ev.Tags = append(ev.Tags, nostr.Tag{"url", b.blobURL})
+		}
+		for _, s := range b.blobServers {
+			ev.Tags = append(ev.Tags, nostr.Tag{"blossom", s})
+		}
+		return ev, nil
+	}
+
+	if b.charset != "" {
+		converted, err := ToUTF8(content, b.charset)
+		if err != nil {
+			return nostr.Event{}, fmt.Errorf("%s: %w", b.path, err)
+		}
+		content = converted
+	}
+
+	// Deltas only cover UTF-8 text, and are only worth it when the diff is
+	// smaller than the file; an empty diff means the file didn't change.
+	if b.deltaBase != "" && b.charset == "" && IsText(content) && len(b.delta) < len(content) {
+		content = []byte(b.delta)
+		ev.Tags = append(ev.Tags, nostr.Tag{"delta", b.deltaBase, strconv.Itoa(b.deltaDepth)})
+	}
+
+	// Binary content is base64-encoded; the "encoding" tag tells readers to
+	// reverse it. Text is tagged with the charset it is restored to.
+	encoded := !IsText(content)
+	if !encoded {
+		charset := b.charset
+		if charset == "" {
+			charset = CharsetUTF8
+		}
+		ev.Tags = append(ev.Tags, nostr.Tag{"charset", charset})
+	}
+	if b.compression != "" {
+		compressed, err := compress(b.compression, content)
+		if err != nil {
+			return nostr.Event{}, err
+		}
+		// Compressed content is always base64-encoded, so it only pays off
+		// when it beats the content as it would otherwise be stored.
+		size := len(content)
+		if encoded {
+			size = base64.StdEncoding.EncodedLen(size)
+		}
+		if base64.StdEncoding.EncodedLen(len(compressed)) < size {
+			content = compressed
+			ev.Tags = append(ev.Tags, nostr.Tag{"compression", b.compression})
+			encoded = true
+		}
+	}
+
+	if len(b.recipients) > 0 {
+		ciphertext, contentKey, tags, err := encryptContent(b.sk, b.recipients, content, encoded)
+		if err != nil {
+			return nostr.Event{}, err
+		}
+		ev.Content = ciphertext
+		ev.Tags = append(ev.Tags, nostr.Tag{"encrypted", EncryptionNIP44})
+		ev.Tags = append(ev.Tags, tags...)
+		if b.hidePath {
+			sealed, err := sealMeta(SealedMeta{Path: b.path, Message: b.message, FileMetadata: meta}, contentKey)
+			if err != nil {
+				return nostr.Event{}, err
+			}
+			ev.Tags = append(ev.Tags, nostr.Tag{"sealed", sealed})
+		}
+	} else if encoded {
+		ev.Content = base64.StdEncoding.EncodeToString(content)
+		ev.Tags = append(ev.Tags, nostr.Tag{"encoding", EncodingBase64})
+	} else {
+		ev.Content = string(content)
+	}
+	return ev, nil
+}
+
+// encryptContent encrypts content under a random content key and wraps that
+// key for every recipient in a "p" tag, so one event serves all of them. The
+// content key is returned for sealing other fields of the same event.
+func encryptContent(sk string, recipients []string, content []byte, binary bool) (string, [32]byte, nostr.Tags, error) {
+	var contentKey [32]byte
+	if _, err := rand.Read(contentKey[:]); err != nil {
+		return "", contentKey, nil, err
+	}
+	plaintext := string(content)
+	if binary {
+		plaintext = base64.StdEncoding.EncodeToString(content)
+	}
+	ciphertext, err := encryptChunks(plaintext, contentKey)
+	if err != nil {
+		return "", contentKey, nil, err
+	}
+
+	var tags nostr.Tags
+	for _, r := range recipients {
+		ck, err := nip44.GenerateConversationKey(r, sk)
+		if err != nil {
+			return "", contentKey, nil, err
+		}
+		wrapped, err := nip44.Encrypt(hex.EncodeToString(contentKey[:]), ck)
+		if err != nil {
+			return "", contentKey, nil, err
+		}
+		tags = append(tags, nostr.Tag{"p", r, wrapped})
+	}
+	if binary {
+		tags = append(tags, nostr.Tag{"encoding", EncodingBase64})
+	}
+	return ciphertext, contentKey, tags, nil
+}
+
+// DecodeContent returns ev's inline payload with encryption, encoding,
+// compression and charset conversion reversed. sk is only needed for
+// encrypted events. For a delta the payload is the diff against its base,
+// and events with a "storage" tag have no inline payload at all.
+func DecodeContent(ev *nostr.Event, sk string) ([]byte, error) {
+	content := ev.Content
+	if ev.Tags.Find("encrypted") != nil {
+		plaintext, err := decryptContent(ev, sk)
+		if err != nil {
+			return nil, err
+		}
+		content = plaintext
+	}
+
+	data := []byte(content)
+	if tag := ev.Tags.Find("encoding"); tag != nil {
+		if tag[1] != EncodingBase64 {
+			return nil, fmt.Errorf("unsupported encoding %q", tag[1])
+		}
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+
+	if tag := ev.Tags.Find("compression"); tag != nil {
+		decompressed, err := decompress(tag[1], data)
+		if err != nil {
+			return nil, err
+		}
+		data = decompressed
+	}
+
+	if tag := ev.Tags.Find("charset"); tag != nil {
+		if !utf8.Valid(data) {
+			return nil, fmt.Errorf("event %s is tagged %s but its content is not valid UTF-8", ev.ID, tag[1])
+		}
+		if tag[1] != CharsetUTF8 {
+			return FromUTF8(data, tag[1])
+		}
+	}
+	return data, nil
+}
+
+// ExpandDelta returns an unsigned copy of the delta event ev carrying
+// content, the version the delta rebuilds to, in full instead of the diff.
+// Encrypted events keep their content key, so the wrapped keys and sealed
+// metadata stay valid.
+func ExpandDelta(ev *nostr.Event, sk string, content []byte) (nostr.Event, error) {
+	out := nostr.Event{PubKey: ev.PubKey, CreatedAt: ev.CreatedAt, Kind: ev.Kind}
+	var alg string
+	for _, tag := range ev.Tags {
+		switch tag[0] {
+		case "compression":
+			alg = tag[1]
+		case "delta", "charset", "encoding":
+		default:
+			out.Tags = append(out.Tags, tag)
+		}
+	}
+
+	encoded := !IsText(content)
+	if !encoded {
+		out.Tags = append(out.Tags, nostr.Tag{"charset", CharsetUTF8})
+	}
+	if alg != "" {
+		compressed, err := compress(alg, content)
+		if err != nil {
+			return nostr.Event{}, err
+		}
+		size := len(content)
+		if encoded {
+			size = base64.StdEncoding.EncodedLen(size)
+		}
+		if base64.StdEncoding.EncodedLen(len(compressed)) < size {
+			content = compressed
+			out.Tags = append(out.Tags, nostr.Tag{"compression", alg})
+			encoded = true
+		}
+	}
+
+	payload := string(content)
+	if encoded {
+		payload = base64.StdEncoding.EncodeToString(content)
+		out.Tags = append(out.Tags, nostr.Tag{"encoding", EncodingBase64})
+	}
+	if ev.Tags.Find("encrypted") != nil {
+		contentKey, err := UnwrapContentKey(ev, sk)
+		if err != nil {
+			return nostr.Event{}, err
+		}
+		if payload, err = encryptChunks(payload, contentKey); err != nil {
+			return nostr.Event{}, err
+		}
+	}
+	out.Content = payload
+	return out, nil
+}
+
+func decryptContent(ev *nostr.Event, sk string) (string, error) {
+	contentKey, err := UnwrapContentKey(ev, sk)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := decryptChunks(ev.Content, contentKey)
+	if err != nil {
+		return "", fmt.Errorf("event %s: %w", ev.ID, err)
+	}
+	return plaintext, nil
+}
+
+// encryptChunks encrypts plaintext with NIP-44, which takes 1 to 65535
+// bytes at a time: longer plaintext is split into chunks whose payloads
+// are joined with newlines, and empty plaintext is an empty string.
+func encryptChunks(plaintext string, key [32]byte) (string, error) {
+	var payloads []string
+	for len(plaintext) > 0 {
+		n := len(plaintext)
+		if n > nip44.MaxPlaintextSize {
+			n = nip44.MaxPlaintextSize
+		}
+		payload, err := nip44.Encrypt(plaintext[:n], key)
+		if err != nil {
+			return "", err
+		}
+		payloads = append(payloads, payload)
+		plaintext = plaintext[n:]
+	}
+	return strings.Join(payloads, "\n"), nil
+}
+
+// decryptChunks reverses encryptChunks.
+func decryptChunks(content string, key [32]byte) (string, error) {
+	if content == "" {
+		return "", nil
+	}
+	var b strings.Builder
+	for _, payload := range strings.Split(content, "\n") {
+		chunk, err := nip44.Decrypt(payload, key)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(chunk)
+	}
+	return b.String(), nil
+}
+
+// UnwrapContentKey recovers the content key of an encrypted event from the
+// "p" tag addressed to sk.
+func UnwrapContentKey(ev *nostr.Event, sk string) ([32]byte, error) {
+	var contentKey [32]byte
+	if sk == "" {
+		return contentKey, fmt.Errorf("event %s is encrypted", ev.ID)
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return contentKey, err
+	}
+	for tag := range ev.Tags.FindAll("p") {
+		if tag[1] != pk || len(tag) < 3 {
+			continue
+		}
+		ck, err := nip44.GenerateConversationKey(ev.PubKey, sk)
+		if err != nil {
+			return contentKey, err
+		}
+		keyHex, err := nip44.Decrypt(tag[2], ck)
+		if err != nil {
+			return contentKey, err
+		}
+		if _, err := hex.Decode(contentKey[:], []byte(keyHex)); err != nil {
+			return contentKey, err
+		}
+		return contentKey, nil
+	}
+	return contentKey, fmt.Errorf("event %s is not encrypted to %s", ev.ID, pk)
+}
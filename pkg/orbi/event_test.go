@@ -0,0 +1,201 @@
+package orbi
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBuildEventRoundTrip(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	other := nostr.GeneratePrivateKey()
+	otherPK, _ := nostr.GetPublicKey(other)
+	text := []byte(strings.Repeat("hello, world\n", 100))
+	binary := []byte{0, 1, 2, 3, 0xff, 0xfe, 0, 'x'}
+
+	tests := []struct {
+		name     string
+		content  []byte
+		opts     []EventOption
+		readAs   string // secret key to decode with
+		wantTags []string
+	}{
+		{"text", text, nil, "", []string{"f", "m", "charset", "x"}},
+		{"binary", binary, nil, "", []string{"encoding", "x"}},
+		{"empty", nil, nil, "", []string{"charset"}},
+		{"gzip", text, []EventOption{WithCompression(CompressionGzip)}, "", []string{"compression", "encoding"}},
+		{"zstd", text, []EventOption{WithCompression(CompressionZstd)}, "", []string{"compression", "encoding"}},
+		{"latin1", []byte("caf\xe9\n"), []EventOption{WithCharset(CharsetLatin1)}, "", []string{"charset"}},
+		{"utf-16le", []byte{'h', 0, 'i', 0, '\n', 0}, []EventOption{WithCharset(CharsetUTF16LE)}, "", []string{"charset"}},
+		{"encrypted", text, []EventOption{WithEncryption(sk, pk)}, sk, []string{"encrypted", "p"}},
+		{"encrypted binary", binary, []EventOption{WithEncryption(sk, pk)}, sk, []string{"encrypted", "encoding"}},
+		{"encrypted to another key", text, []EventOption{WithEncryption(sk, pk, otherPK)}, other, []string{"encrypted"}},
+		{"encrypted and compressed", text, []EventOption{WithEncryption(sk, pk), WithCompression(CompressionGzip)}, sk, []string{"encrypted", "compression"}},
+		{"hidden path", text, []EventOption{WithEncryption(sk, pk), WithHiddenPath()}, sk, []string{"sealed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]EventOption{WithPath("dir/file.txt"), WithMessage("a message")}, tt.opts...)
+			ev, err := BuildEvent(pk, 1063, tt.content, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ev.Sign(sk); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.wantTags {
+				if ev.Tags.Find(name) == nil {
+					t.Errorf("missing %q tag in %v", name, ev.Tags)
+				}
+			}
+			got, err := DecodeContent(&ev, tt.readAs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.content) {
+				t.Errorf("decoded %q, want %q", got, tt.content)
+			}
+			if ev.Tags.Find("encrypted") != nil {
+				if _, err := DecodeContent(&ev, nostr.GeneratePrivateKey()); err == nil {
+					t.Error("a stranger decoded an encrypted event")
+				}
+				if strings.Contains(ev.String(), "hello") {
+					t.Error("encrypted event contains the plaintext")
+				}
+			}
+		})
+	}
+}
+
+func TestBuildEventEncryptedSizes(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	// NIP-44 encrypts 1 to 65535 bytes at a time.
+	for _, size := range []int{0, 1, 65535, 65536, 3*65535 + 7} {
+		for _, binary := range []bool{false, true} {
+			content := bytes.Repeat([]byte("x"), size)
+			if binary && size > 0 {
+				content[0] = 0
+			}
+			ev, err := BuildEvent(pk, 1063, content, WithPath("big"), WithEncryption(sk, pk))
+			if err != nil {
+				t.Fatalf("%d bytes, binary=%v: %v", size, binary, err)
+			}
+			if err := ev.Sign(sk); err != nil {
+				t.Fatal(err)
+			}
+			got, err := DecodeContent(&ev, sk)
+			if err != nil {
+				t.Fatalf("%d bytes, binary=%v: %v", size, binary, err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("%d bytes, binary=%v: decoded %d bytes", size, binary, len(got))
+			}
+		}
+	}
+}
+
+func TestBuildEventHiddenPath(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	ev, err := BuildEvent(pk, 1063, []byte("secret\n"), WithPath("private/plans.txt"), WithMessage("plans"), WithEncryption(sk, pk), WithHiddenPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ev.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	if s := ev.String(); strings.Contains(s, "plans") {
+		t.Errorf("hidden event leaks its path or message: %s", s)
+	}
+	tag, err := HiddenPathTag(sk, "private/plans.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := ev.Tags.Find("f"); f == nil || f[1] != tag {
+		t.Errorf("f tag is %v, want %s", f, tag)
+	}
+	meta, err := UnsealMeta(&ev, sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Path != "private/plans.txt" || meta.Message != "plans" {
+		t.Errorf("sealed metadata is %q %q", meta.Path, meta.Message)
+	}
+
+	resealed, err := ResealMessage(&ev, sk, "new plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev.Tags = append(ev.Tags.FilterOut([]string{"sealed"}), resealed)
+	if meta, err = UnsealMeta(&ev, sk); err != nil || meta.Message != "new plans" || meta.Path != "private/plans.txt" {
+		t.Errorf("resealed metadata is %+v, %v", meta, err)
+	}
+
+	if _, err := BuildEvent(pk, 1063, []byte("x"), WithPath("a"), WithHiddenPath()); err == nil {
+		t.Error("hiding a path without encryption succeeded")
+	}
+}
+
+func TestExpandDelta(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	full := []byte(strings.Repeat("line\n", 50))
+	for _, tt := range []struct {
+		name string
+		opts []EventOption
+	}{
+		{"plain", nil},
+		{"compressed", []EventOption{WithCompression(CompressionGzip)}},
+		{"encrypted", []EventOption{WithEncryption(sk, pk)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := strings.Repeat("0", 64)
+			opts := append([]EventOption{WithPath("a.txt"), WithDelta(parent, "@@ -1 +1 @@\n-x\n+y\n", 1)}, tt.opts...)
+			ev, err := BuildEvent(pk, 1063, full, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ev.Tags.Find("delta") == nil {
+				t.Fatal("event is not a delta")
+			}
+			expanded, err := ExpandDelta(&ev, sk, full)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expanded.Tags.Find("delta") != nil {
+				t.Error("expanded event still has a delta tag")
+			}
+			got, err := DecodeContent(&expanded, sk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, full) {
+				t.Errorf("expanded event decodes to %q", got)
+			}
+		})
+	}
+}
+
+func TestBuildEventErrors(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	for _, tt := range []struct {
+		name string
+		opts []EventOption
+	}{
+		{"empty path", []EventOption{WithPath("")}},
+		{"no recipients", []EventOption{WithPath("a"), WithEncryption(sk)}},
+		{"bad recipient", []EventOption{WithPath("a"), WithEncryption(sk, "npub")}},
+		{"unknown compression", []EventOption{WithPath("a"), WithCompression("lzma")}},
+		{"bad parent", []EventOption{WithPath("a"), WithParent("abc")}},
+		{"unknown charset", []EventOption{WithPath("a"), WithCharset("ebcdic")}},
+	} {
+		if _, err := BuildEvent(pk, 1063, []byte("x"), tt.opts...); err == nil {
+			t.Errorf("%s: building succeeded", tt.name)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package orbi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// IndexEntry is what is known about a tracked file as of its last publish.
+type IndexEntry struct {
+	Path      string `json:"path"`
+	EventID   string `json:"event_id,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	ModTime   int64  `json:"mtime,omitempty"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	// PathTag is the "f" tag the file is published under when that isn't
+	// its path, as for files with hidden paths.
+	PathTag string `json:"path_tag,omitempty"`
+	// Conflict is set when a pull left conflict markers in the file. It is
+	// cleared by publishing it.
+	Conflict bool `json:"conflict,omitempty"`
+}
+
+// Index is the tracking state of a working copy, keyed by slash-separated
+// repo-relative path. The orbi command keeps it in .orbi/index.json.
+type Index struct {
+	Files map[string]*IndexEntry `json:"files"`
+	// Staged lists the paths the next commit will record, sorted.
+	Staged []string `json:"staged,omitempty"`
+}
+
+// Paths returns the tracked paths in sorted order.
+func (idx *Index) Paths() []string {
+	paths := make([]string, 0, len(idx.Files))
+	for p := range idx.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ReadIndex reads the index stored at path. A missing file is an empty
+// index. Paths written with backslashes, as on Windows, are converted.
+func ReadIndex(path string) (*Index, error) {
+	idx := &Index{Files: make(map[string]*IndexEntry)}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, idx); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]*IndexEntry)
+	}
+	for p, entry := range idx.Files {
+		if norm := strings.ReplaceAll(p, "\\", "/"); norm != p {
+			delete(idx.Files, p)
+			entry.Path = norm
+			idx.Files[norm] = entry
+		}
+	}
+	for i, p := range idx.Staged {
+		idx.Staged[i] = strings.ReplaceAll(p, "\\", "/")
+	}
+	return idx, nil
+}
+
+// WriteIndex replaces the index at path atomically so a crash can't leave
+// it truncated.
+func WriteIndex(path string, idx *Index) error {
+	content, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(content, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
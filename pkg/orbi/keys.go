@@ -0,0 +1,58 @@
+package orbi
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip49"
+)
+
+// ErrInvalidKey is returned for text that isn't a secret key.
+var ErrInvalidKey = errors.New("invalid secret key")
+
+// IsEncryptedKey reports whether s is a NIP-49 ncryptsec.
+func IsEncryptedKey(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), "ncryptsec1")
+}
+
+// ParseSecretKey reads a secret key written as an nsec, 64 hex digits or a
+// NIP-49 ncryptsec and returns it in hex along with its public key.
+// passphrase is only called for an ncryptsec, and may be nil if the caller
+// doesn't accept them.
+func ParseSecretKey(s string, passphrase func() (string, error)) (sk, pk string, err error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case IsEncryptedKey(s):
+		if passphrase == nil {
+			return "", "", fmt.Errorf("%w: the key is encrypted", ErrInvalidKey)
+		}
+		p, err := passphrase()
+		if err != nil {
+			return "", "", fmt.Errorf("the key is encrypted: %w", err)
+		}
+		if sk, err = nip49.Decrypt(s, p); err != nil {
+			return "", "", fmt.Errorf("failed to decrypt the key (wrong passphrase?): %w", err)
+		}
+	case strings.HasPrefix(s, "nsec1"):
+		_, decoded, err := nip19.Decode(s)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		sk = decoded.(string)
+	case len(s) == 64:
+		if _, err := hex.DecodeString(s); err != nil {
+			return "", "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+		sk = s
+	default:
+		return "", "", fmt.Errorf("%w: unrecognized format", ErrInvalidKey)
+	}
+	if pk, err = nostr.GetPublicKey(sk); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return sk, pk, nil
+}
@@ -0,0 +1,116 @@
+package orbi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DetectMIME guesses the MIME type of a file from its extension, falling back
+// to sniffing its content.
+func DetectMIME(name string, content []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return "text/markdown; charset=utf-8"
+	case ".go", ".rs", ".py", ".sh", ".toml", ".yaml", ".yml":
+		return "text/plain; charset=utf-8"
+	}
+	return http.DetectContentType(content)
+}
+
+// ContentHash returns the hex SHA-256 of content, as used in "x" tags.
+func ContentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// FileMetadata describes a file's original bytes so readers can decide how
+// to render or decode it without sniffing: its MIME type, size and, for
+// images, dimensions as "<width>x<height>". Mode and MTime, when known, are
+// the permission bits and modification time (in Unix seconds) to restore
+// the file with.
+type FileMetadata struct {
+	MIME  string      `json:"mime,omitempty"`
+	Size  int         `json:"size,omitempty"`
+	Dim   string      `json:"dim,omitempty"`
+	Mode  os.FileMode `json:"mode,omitempty"`
+	MTime int64       `json:"mtime,omitempty"`
+}
+
+// DescribeFile returns the metadata of the file name with the given content.
+func DescribeFile(name string, content []byte) FileMetadata {
+	meta := FileMetadata{MIME: DetectMIME(name, content), Size: len(content)}
+	if strings.HasPrefix(meta.MIME, "image/") {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+			meta.Dim = fmt.Sprintf("%dx%d", cfg.Width, cfg.Height)
+		}
+	}
+	return meta
+}
+
+// Tags returns the metadata as NIP-94 style tags.
+//
+// NIP-94 puts the MIME type in an "m" tag, but orbi has used "m" for commit
+// messages since its first release, so the type goes in "mime" instead.
+func (m FileMetadata) Tags() nostr.Tags {
+	tags := nostr.Tags{
+		{"mime", m.MIME},
+		{"size", strconv.Itoa(m.Size)},
+	}
+	if m.Dim != "" {
+		tags = append(tags, nostr.Tag{"dim", m.Dim})
+	}
+	if m.Mode != 0 {
+		tags = append(tags, nostr.Tag{"mode", fmt.Sprintf("%04o", m.Mode.Perm())})
+	}
+	if m.MTime != 0 {
+		tags = append(tags, nostr.Tag{"mtime", strconv.FormatInt(m.MTime, 10)})
+	}
+	return tags
+}
+
+// FileMetadataTags returns the metadata tags of the file name with the given
+// content.
+func FileMetadataTags(name string, content []byte) nostr.Tags {
+	return DescribeFile(name, content).Tags()
+}
+
+// EventMetadata reads the metadata tags of ev. Events whose path is hidden
+// keep their metadata sealed instead; see UnsealMeta.
+func EventMetadata(ev *nostr.Event) FileMetadata {
+	var meta FileMetadata
+	if tag := ev.Tags.Find("mime"); tag != nil {
+		meta.MIME = tag[1]
+	}
+	if tag := ev.Tags.Find("size"); tag != nil {
+		meta.Size, _ = strconv.Atoi(tag[1])
+	}
+	if tag := ev.Tags.Find("dim"); tag != nil {
+		meta.Dim = tag[1]
+	}
+	if tag := ev.Tags.Find("mode"); tag != nil {
+		if mode, err := strconv.ParseUint(tag[1], 8, 32); err == nil {
+			meta.Mode = os.FileMode(mode).Perm()
+		}
+	}
+	if tag := ev.Tags.Find("mtime"); tag != nil {
+		meta.MTime, _ = strconv.ParseInt(tag[1], 10, 64)
+	}
+	return meta
+}
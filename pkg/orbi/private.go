@@ -0,0 +1,81 @@
+package orbi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// SealedMeta is what a hidden-path event keeps in its encrypted "sealed"
+// tag instead of the clear "f" and "m" tags.
+type SealedMeta struct {
+	Path    string `json:"path"`
+	Message string `json:"message,omitempty"`
+	FileMetadata
+}
+
+// HiddenPathTag returns the "f" tag value standing in for path: an HMAC
+// keyed by the author's secret key, so every version of a file gets the same
+// tag but nobody else can tell which file it is.
+func HiddenPathTag(sk, path string) (string, error) {
+	key, err := hex.DecodeString(sk)
+	if err != nil || len(key) != 32 {
+		return "", fmt.Errorf("hiding the path needs the secret key")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("orbi-path\x00" + path))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func sealMeta(meta SealedMeta, contentKey [32]byte) (string, error) {
+	plaintext, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Encrypt(string(plaintext), contentKey)
+}
+
+// ResealMessage returns the "sealed" tag of ev with its message replaced,
+// encrypted under the same content key so the recipients can still read it.
+func ResealMessage(ev *nostr.Event, sk, message string) (nostr.Tag, error) {
+	meta, err := UnsealMeta(ev, sk)
+	if err != nil {
+		return nil, err
+	}
+	contentKey, err := UnwrapContentKey(ev, sk)
+	if err != nil {
+		return nil, err
+	}
+	meta.Message = message
+	sealed, err := sealMeta(meta, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return nostr.Tag{"sealed", sealed}, nil
+}
+
+// UnsealMeta decrypts the "sealed" tag of ev with sk.
+func UnsealMeta(ev *nostr.Event, sk string) (SealedMeta, error) {
+	var meta SealedMeta
+	tag := ev.Tags.Find("sealed")
+	if tag == nil {
+		return meta, fmt.Errorf("event %s has no sealed metadata", ev.ID)
+	}
+	contentKey, err := UnwrapContentKey(ev, sk)
+	if err != nil {
+		return meta, err
+	}
+	plaintext, err := nip44.Decrypt(tag[1], contentKey)
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal([]byte(plaintext), &meta); err != nil {
+		return meta, fmt.Errorf("event %s: invalid sealed metadata: %w", ev.ID, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,268 @@
+package orbi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// ErrRelayRejected means a relay refused an event or no relay accepted it.
+var ErrRelayRejected = errors.New("rejected by relay")
+
+// Publisher sends a signed event to a single relay.
+type Publisher interface {
+	Publish(ctx context.Context, url string, ev nostr.Event) error
+}
+
+// Fetcher retrieves the events matching filter from a single relay.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string, filter nostr.Filter) ([]*nostr.Event, error)
+}
+
+// Transport is everything needed from the relay network to publish and
+// read file events.
+type Transport interface {
+	Publisher
+	Fetcher
+}
+
+// InfoFetcher is implemented by transports that can retrieve a relay's NIP-11
+// information document.
+type InfoFetcher interface {
+	RelayInfo(ctx context.Context, url string) (nip11.RelayInformationDocument, error)
+}
+
+// WebsocketTransport talks to relays over their websocket endpoints, opening
+// a fresh connection for every call.
+type WebsocketTransport struct {
+	// TLS holds per-relay TLS settings keyed by normalized relay URL. Relays
+	// without an entry use the system defaults.
+	TLS map[string]*tls.Config
+
+	// Auth signs NIP-42 authentication events for relays that refuse to
+	// serve or accept events until the client has authenticated. Nil means
+	// never authenticate.
+	Auth func(ev *nostr.Event) error
+
+	// Proxied is set when connections go through a proxy, which the TLS
+	// settings would bypass.
+	Proxied bool
+}
+
+// Connect opens a relay connection that logs the relay's NOTICE messages.
+func (t WebsocketTransport) Connect(ctx context.Context, url string) (*nostr.Relay, error) {
+	tlsConfig := t.TLS[nostr.NormalizeURL(url)]
+	if tlsConfig != nil && t.Proxied {
+		// The websocket library dials these directly, which would bypass
+		// the proxy.
+		return nil, fmt.Errorf("relay_tls settings can't be used through a proxy")
+	}
+	relay := nostr.NewRelay(context.Background(), url, nostr.WithNoticeHandler(func(notice string) {
+		slog.Info("Relay notice", "relay", url, "notice", notice)
+	}))
+	if err := relay.ConnectWithTLS(ctx, tlsConfig); err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return relay, nil
+}
+
+// authenticate answers the relay's NIP-42 challenge after it refused an
+// operation with rej. It reports whether the operation is worth retrying.
+func (t WebsocketTransport) authenticate(ctx context.Context, relay *nostr.Relay, rej *Rejection) bool {
+	if t.Auth == nil || rej.Prefix != "auth-required" {
+		return false
+	}
+	if err := relay.Auth(ctx, t.Auth); err != nil {
+		slog.Warn("Relay authentication failed", "relay", relay.URL, "err", err)
+		return false
+	}
+	slog.Debug("Authenticated to relay", "relay", relay.URL)
+	return true
+}
+
+func (t WebsocketTransport) Publish(ctx context.Context, url string, ev nostr.Event) error {
+	relay, err := t.Connect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+	err = relay.Publish(ctx, ev)
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	rej := ParseRejection(err.Error())
+	if !t.authenticate(ctx, relay, rej) {
+		return rej
+	}
+	if err := relay.Publish(ctx, ev); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return ParseRejection(err.Error())
+	}
+	return nil
+}
+
+func (t WebsocketTransport) Fetch(ctx context.Context, url string, filter nostr.Filter) ([]*nostr.Event, error) {
+	relay, err := t.Connect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer relay.Close()
+
+	events, err := t.subscribe(ctx, relay, filter)
+	if rej, ok := err.(*Rejection); ok && len(events) == 0 && t.authenticate(ctx, relay, rej) {
+		return t.subscribe(ctx, relay, filter)
+	}
+	return events, err
+}
+
+func (WebsocketTransport) RelayInfo(ctx context.Context, url string) (nip11.RelayInformationDocument, error) {
+	return nip11.Fetch(ctx, url)
+}
+
+// subscribe collects the stored events matching filter from relay.
+func (t WebsocketTransport) subscribe(ctx context.Context, relay *nostr.Relay, filter nostr.Filter) ([]*nostr.Event, error) {
+	sub, err := relay.Subscribe(ctx, nostr.Filters{filter})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsub()
+	var events []*nostr.Event
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return events, nil
+			}
+			events = append(events, ev)
+		case <-sub.EndOfStoredEvents:
+			// Events sent before the EOSE may still be buffered.
+			for {
+				select {
+				case ev, ok := <-sub.Events:
+					if !ok {
+						return events, nil
+					}
+					events = append(events, ev)
+				default:
+					return events, nil
+				}
+			}
+		case reason := <-sub.ClosedReason:
+			return events, ParseRejection(reason)
+		case <-ctx.Done():
+			return events, ctx.Err()
+		}
+	}
+}
+
+// MemoryTransport is an in-process relay network keyed by relay URL, for
+// testing code built on this package without a network. It verifies
+// signatures on publish and applies filters on fetch the way a real relay
+// would.
+type MemoryTransport struct {
+	mu     sync.Mutex
+	relays map[string][]nostr.Event
+}
+
+// NewMemoryTransport returns a MemoryTransport with no events.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{relays: make(map[string][]nostr.Event)}
+}
+
+func (m *MemoryTransport) Publish(ctx context.Context, url string, ev nostr.Event) error {
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return &Rejection{Prefix: "invalid", Reason: "bad signature"}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.relays[url] {
+		if existing.ID == ev.ID {
+			return nil
+		}
+	}
+	m.relays[url] = append(m.relays[url], ev)
+	return nil
+}
+
+func (m *MemoryTransport) Fetch(ctx context.Context, url string, filter nostr.Filter) ([]*nostr.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*nostr.Event
+	for i := range m.relays[url] {
+		ev := m.relays[url][i]
+		if filter.Matches(&ev) {
+			result = append(result, &ev)
+		}
+	}
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt > result[j].CreatedAt })
+		result = result[:filter.Limit]
+	}
+	return result, nil
+}
+
+// Rejection is a relay's refusal of an event or subscription, split into the
+// machine-readable prefix from NIP-01 ("rate-limited", "auth-required",
+// "restricted", ...) and the human-readable rest.
+type Rejection struct {
+	Prefix string
+	Reason string
+}
+
+// ParseRejection splits an OK or CLOSED message such as
+// "rate-limited: slow down" into its prefix and reason.
+func ParseRejection(msg string) *Rejection {
+	msg = strings.TrimPrefix(msg, "msg: ")
+	prefix, reason, ok := strings.Cut(msg, ":")
+	if !ok || strings.ContainsAny(prefix, " \t") {
+		return &Rejection{Reason: msg}
+	}
+	return &Rejection{Prefix: prefix, Reason: strings.TrimSpace(reason)}
+}
+
+func (r *Rejection) Error() string {
+	var hint string
+	switch r.Prefix {
+	case "rate-limited":
+		hint = "rate limited"
+	case "auth-required":
+		hint = "relay requires authentication (NIP-42)"
+	case "payment-required":
+		hint = "relay requires payment"
+	case "restricted":
+		if strings.Contains(strings.ToLower(r.Reason), "pay") {
+			hint = "relay requires payment"
+		} else {
+			hint = "relay only accepts events from allowed users"
+		}
+	case "pow":
+		hint = "relay requires proof of work"
+	case "blocked":
+		hint = "relay has blocked this key or IP"
+	case "invalid":
+		hint = "relay considers the event invalid"
+	case "":
+		return "rejected: " + r.Reason
+	default:
+		hint = r.Prefix
+	}
+	if r.Reason == "" {
+		return hint
+	}
+	return hint + ": " + r.Reason
+}
+
+// Is lets errors.Is(err, ErrRelayRejected) match rejections.
+func (r *Rejection) Is(target error) bool {
+	return target == ErrRelayRejected
+}
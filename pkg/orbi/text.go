@@ -0,0 +1,24 @@
+package orbi
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is inspected when deciding whether it is
+// text, the same window git uses.
+const sniffLen = 8000
+
+// IsText reports whether content can be published verbatim as an event's
+// content. Anything containing NUL bytes or invalid UTF-8 is treated as
+// binary, since relays store content as a JSON string.
+func IsText(content []byte) bool {
+	head := content
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	return utf8.Valid(content)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Policy is a verification policy consumers pin to decide which events they
+// accept on clone and pull. It is stored as JSON, conventionally in a
+// policy.orbi file at the repository root.
+type Policy struct {
+	// Signers are the npubs allowed to author content. Empty allows anyone.
+	Signers []string `json:"signers,omitempty"`
+	// Maintainers are the npubs whose approvals count towards MinSignatures.
+	Maintainers []string `json:"maintainers,omitempty"`
+	// MinSignatures is how many distinct maintainers must have signed a file
+	// version, either by authoring it or by publishing an approval.
+	MinSignatures int `json:"min_signatures,omitempty"`
+	// Kinds lists the event kinds that may be applied. Empty allows all.
+	Kinds []int `json:"kinds,omitempty"`
+
+	signers, maintainers []string
+}
+
+// loadPolicy reads a policy file, checking it against the pinned SHA-256
+// when one is given.
+func loadPolicy(path, pin string) (*Policy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if pin != "" {
+		sum := sha256.Sum256(content)
+		if got := hex.EncodeToString(sum[:]); got != pin {
+			return nil, fmt.Errorf("%w: %s has hash %s but %s is pinned", ErrUntrusted, path, got, pin)
+		}
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(content, p); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	for _, s := range p.Signers {
+		pk, err := parsePubkey(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: signers: %w", path, err)
+		}
+		p.signers = append(p.signers, pk)
+	}
+	for _, s := range p.Maintainers {
+		pk, err := parsePubkey(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: maintainers: %w", path, err)
+		}
+		p.maintainers = append(p.maintainers, pk)
+	}
+	if p.MinSignatures > len(p.maintainers) {
+		return nil, fmt.Errorf("%s: min_signatures is %d but only %d maintainers are listed", path, p.MinSignatures, len(p.maintainers))
+	}
+	return p, nil
+}
+
+// repoPolicy loads the policy pinned in the repository config, or nil if
+// none is configured.
+func (c *Client) repoPolicy(cfg *Config) (*Policy, error) {
+	if cfg.Policy == "" {
+		return nil, nil
+	}
+	return loadPolicy(c.Repo.Abs(cfg.Policy), cfg.PolicySHA256)
+}
+
+// Check returns an ErrUntrusted error if ev violates the policy. File
+// versions must also satisfy .orbi/owners: signed by an owner of the path or
+// approved by one.
+func (p *Policy) Check(c *Client, ev *nostr.Event) error {
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("%w: event %s has an invalid signature", ErrUntrusted, ev.ID)
+	}
+	if len(p.Kinds) > 0 && !containsInt(p.Kinds, ev.Kind) {
+		return fmt.Errorf("%w: event %s has kind %d, which the policy doesn't allow", ErrUntrusted, ev.ID, ev.Kind)
+	}
+	if len(p.signers) > 0 && !c.signsFor(ev.PubKey, p.signers) {
+		return fmt.Errorf("%w: event %s is signed by %s, who is not a policy signer", ErrUntrusted, ev.ID, ev.PubKey)
+	}
+	if !containsInt(c.kinds(ev.PubKey).files(), ev.Kind) {
+		return nil
+	}
+	rules, err := c.Repo.Owners()
+	if err != nil {
+		return err
+	}
+	if p.MinSignatures == 0 && len(rules) == 0 {
+		return nil
+	}
+
+	content, err := c.eventContent(ev)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	if rel := c.filePath(ev); len(rules) > 0 && !c.ownsContent(rules, rel, hex.EncodeToString(sum[:]), ev.PubKey) {
+		return fmt.Errorf("%w: %s (event %s) is signed by %s, who doesn't own it, and has no owner approval",
+			ErrUntrusted, rel, ev.ID, short(ev.PubKey))
+	}
+	if p.MinSignatures == 0 {
+		return nil
+	}
+	signed := make(map[string]bool)
+	if c.signsFor(ev.PubKey, p.maintainers) {
+		signed[c.identityOf(ev.PubKey)] = true
+	}
+	// Approvals may come from maintainers' subkeys, so filter by signer
+	// after fetching rather than in the query.
+	approvals := c.query(nostr.Filter{
+		Kinds: []int{c.kinds(ev.PubKey).Approval},
+		Tags:  nostr.TagMap{"f": []string{eventPath(ev)}, "x": []string{hex.EncodeToString(sum[:])}},
+	})
+	for _, a := range approvals {
+		if ok, _ := a.CheckSignature(); ok && c.signsFor(a.PubKey, p.maintainers) {
+			signed[c.identityOf(a.PubKey)] = true
+		}
+	}
+	if len(signed) < p.MinSignatures {
+		return fmt.Errorf("%w: %s (event %s) has %d of %d required maintainer signatures",
+			ErrUntrusted, eventPath(ev), ev.ID, len(signed), p.MinSignatures)
+	}
+	return nil
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdPolicy(args []string) error {
+	if len(args) < 1 || args[0] != "check" {
+		return fmt.Errorf("usage: orbi policy check [--policy <file>] <event-id>...")
+	}
+	fs := flag.NewFlagSet("policy check", flag.ContinueOnError)
+	path := fs.String("policy", "", "policy file to check against (default: the one pinned in .orbi/config)")
+	ids, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	var policy *Policy
+	if *path != "" {
+		policy, err = loadPolicy(*path, "")
+	} else {
+		policy, err = client.repoPolicy(cfg)
+	}
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return fmt.Errorf("no policy pinned; set \"policy\" in .orbi/config or pass --policy")
+	}
+
+	events := client.query(nostr.Filter{IDs: ids})
+	if len(events) < len(ids) {
+		return fmt.Errorf("only found %d of %d events: %w", len(events), len(ids), ErrNotFound)
+	}
+	var failed error
+	for _, ev := range events {
+		if err := policy.Check(client, ev); err != nil {
+			fmt.Printf("FAIL %s: %v\n", ev.ID, err)
+			failed = err
+			continue
+		}
+		fmt.Printf("ok   %s\n", ev.ID)
+	}
+	return failed
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// powProgressDifficulty is the difficulty from which mining takes long
+// enough to show progress.
+const powProgressDifficulty = 20
+
+// maxPoW bounds the difficulty that can be requested; anything higher would
+// not finish.
+const maxPoW = 64
+
+func validPoW(difficulty int) error {
+	if difficulty < 0 || difficulty > maxPoW {
+		return fmt.Errorf("invalid proof-of-work difficulty %d: must be between 0 and %d", difficulty, maxPoW)
+	}
+	return nil
+}
+
+// relayPoW returns the per-relay difficulties of cfg keyed by normalized
+// URL.
+func relayPoW(cfg *Config) map[string]int {
+	if len(cfg.RelayPoW) == 0 {
+		return nil
+	}
+	difficulties := make(map[string]int, len(cfg.RelayPoW))
+	for url, d := range cfg.RelayPoW {
+		difficulties[nostr.NormalizeURL(url)] = d
+	}
+	return difficulties
+}
+
+// powDifficulty returns the NIP-13 difficulty events must be mined to: the
+// highest of the client's own and those required by its relays.
+func (c *Client) powDifficulty() int {
+	difficulty := c.PoW
+	if len(c.RelayPoW) == 0 {
+		return difficulty
+	}
+	for _, r := range c.Relays() {
+		if d := c.RelayPoW[nostr.NormalizeURL(r)]; d > difficulty {
+			difficulty = d
+		}
+	}
+	return difficulty
+}
+
+// mine adds a NIP-13 nonce tag to ev giving its id at least difficulty
+// leading zero bits, using every CPU core. It must run before signing, and
+// again if ev changes.
+func mine(ev *nostr.Event, difficulty int, quiet bool) error {
+	tags := ev.Tags[:0:0]
+	for _, tag := range ev.Tags {
+		if len(tag) == 0 || tag[0] != "nonce" {
+			tags = append(tags, tag)
+		}
+	}
+	ev.Tags = tags
+
+	start := time.Now()
+	done := make(chan struct{})
+	defer close(done)
+	if difficulty >= powProgressDifficulty && !quiet {
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					fmt.Fprintf(os.Stderr, "\rMining proof of work (difficulty %d, ~%.0f hashes expected)... %s",
+						difficulty, float64(uint64(1)<<uint(difficulty)), time.Since(start).Round(time.Second))
+				}
+			}
+		}()
+	}
+	tag, err := nip13.DoWork(context.Background(), *ev, difficulty)
+	if err != nil {
+		return fmt.Errorf("proof of work: %w", err)
+	}
+	ev.Tags = append(ev.Tags, tag)
+	if time.Since(start) >= time.Second && difficulty >= powProgressDifficulty && !quiet {
+		fmt.Fprintln(os.Stderr)
+	}
+	slog.Debug("Mined proof of work", "difficulty", difficulty, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
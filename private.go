@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// filePath returns the path of a file event, decrypting it when hidden. A
+// hidden path the client can't decrypt yields "".
+func (c *Client) filePath(ev *nostr.Event) string {
+	if ev.Tags.Find("sealed") == nil {
+		return eventPath(ev)
+	}
+	meta, err := orbi.UnsealMeta(ev, c.sk)
+	if err != nil {
+		return ""
+	}
+	return meta.Path
+}
+
+// fileMessage returns the message of a file event, decrypting it when
+// hidden.
+func (c *Client) fileMessage(ev *nostr.Event) string {
+	if ev.Tags.Find("sealed") == nil {
+		return eventMessage(ev)
+	}
+	meta, _ := orbi.UnsealMeta(ev, c.sk)
+	return meta.Message
+}
+
+// fileMetadata returns the MIME type, size and dimensions recorded for a
+// file event, decrypting them when its path is hidden.
+func (c *Client) fileMetadata(ev *nostr.Event) orbi.FileMetadata {
+	if ev.Tags.Find("sealed") == nil {
+		return orbi.EventMetadata(ev)
+	}
+	meta, _ := orbi.UnsealMeta(ev, c.sk)
+	return meta.FileMetadata
+}
+
+// pathTag returns the "f" tag value the versions of rel are published under,
+// which differs from rel when its path is hidden.
+func (c *Client) pathTag(rel string) string {
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return rel
+	}
+	if entry, ok := idx.Files[rel]; ok && entry.PathTag != "" {
+		return entry.PathTag
+	}
+	return rel
+}
+
+// privateOptions encrypts the next version of rel the way entry's version
+// was: to the same recipients, and with the path hidden if it was. Versions
+// committed without --private, as by orbi commit, stay private this way.
+func (c *Client) privateOptions(rel string, entry *orbi.IndexEntry) ([]orbi.EventOption, error) {
+	prev, err := c.eventByID(entry.EventID)
+	if err != nil {
+		return nil, fmt.Errorf("%s was published privately but its recipients are unknown (%w); pass --private", rel, err)
+	}
+	keys := []string{c.pk}
+	for tag := range prev.Tags.FindAll("p") {
+		if len(tag) >= 3 && !contains(keys, tag[1]) {
+			keys = append(keys, tag[1])
+		}
+	}
+	opts := []orbi.EventOption{orbi.WithEncryption(c.sk, keys...)}
+	if entry.PathTag != "" {
+		opts = append(opts, orbi.WithHiddenPath())
+	}
+	return opts, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// progressMinBytes is the size from which a file's transfer is shown.
+const progressMinBytes = 1 << 20
+
+// progressBarWidth is the number of cells in a drawn progress bar.
+const progressBarWidth = 24
+
+// progressObserver adds progress reporting to logObserver: bytes of large
+// files read and uploaded, relays confirming each event, and files done out
+// of the whole operation. On a terminal it redraws a status line on
+// standard error; otherwise it logs plain lines at milestones.
+type progressObserver struct {
+	logObserver
+	tty bool
+
+	mu sync.Mutex
+	// file and fileDone/fileTotal track the current transfer in bytes.
+	file                string
+	fileDone, fileTotal int64
+	// confirmed of relays have accepted the current event.
+	confirmed, relays int
+	// done of total files of the whole operation are finished.
+	done, total int
+	// milestone is the last quarter of a transfer logged without a
+	// terminal.
+	milestone int64
+	drawn     bool
+}
+
+func newProgressObserver() *progressObserver {
+	return &progressObserver{tty: isTerminal(os.Stdout) && isTerminal(os.Stderr)}
+}
+
+func (p *progressObserver) OnChunk(path string, done, total int64) {
+	if quiet() || total < progressMinBytes {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if path != p.file {
+		p.file, p.milestone = path, -1
+	}
+	p.fileDone, p.fileTotal = done, total
+	if p.tty {
+		p.draw()
+		return
+	}
+	if quarter := done * 4 / total; quarter > p.milestone {
+		p.milestone = quarter
+		slog.Info("Transferring", "file", path, "done", formatBytes(done), "total", formatBytes(total))
+	}
+}
+
+func (p *progressObserver) OnPublishStart(ev *nostr.Event, relays []string) {
+	p.logObserver.OnPublishStart(ev, relays)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.confirmed, p.relays = 0, len(relays)
+	if p.tty && p.active() {
+		p.draw()
+	}
+}
+
+func (p *progressObserver) OnRelayResult(ev *nostr.Event, url string, err error) {
+	p.logObserver.OnRelayResult(ev, url, err)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.confirmed++
+	if p.tty && p.active() {
+		p.draw()
+	}
+}
+
+func (p *progressObserver) OnPublishDone(ev *nostr.Event, results []RelayResult) {
+	p.mu.Lock()
+	p.clear()
+	p.file, p.fileDone, p.fileTotal = "", 0, 0
+	// In the middle of a larger operation the per-event table would drown
+	// the progress.
+	single := p.total <= 1
+	p.mu.Unlock()
+	if single {
+		p.logObserver.OnPublishDone(ev, results)
+	}
+}
+
+// OnFileDone reports that done of total files of an operation such as a
+// clone are finished.
+func (p *progressObserver) OnFileDone(path string, done, total int) {
+	if quiet() || total <= 1 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done, p.total = done, total
+	p.file, p.fileDone, p.fileTotal = "", 0, 0
+	if p.tty {
+		if done == total {
+			p.clear()
+		} else {
+			p.draw()
+		}
+		return
+	}
+	if done == total || done%10 == 0 {
+		slog.Info("Progress", "files", fmt.Sprintf("%d/%d", done, total))
+	}
+}
+
+// active reports whether there is anything to show.
+func (p *progressObserver) active() bool {
+	return p.fileTotal > 0 || p.total > 1
+}
+
+// draw redraws the status line. p.mu must be held.
+func (p *progressObserver) draw() {
+	var parts []string
+	if p.total > 1 {
+		parts = append(parts, fmt.Sprintf("%s %d/%d files", bar(int64(p.done), int64(p.total)), p.done, p.total))
+	}
+	if p.fileTotal > 0 {
+		parts = append(parts, fmt.Sprintf("%s %s %s/%s", p.file, bar(p.fileDone, p.fileTotal),
+			formatBytes(p.fileDone), formatBytes(p.fileTotal)))
+	}
+	if p.relays > 0 && p.fileTotal > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d relays", p.confirmed, p.relays))
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K%s", strings.Join(parts, "  "))
+	p.drawn = true
+}
+
+// clear erases the status line. p.mu must be held.
+func (p *progressObserver) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// fileObserver is implemented by observers that track progress through a
+// set of files.
+type fileObserver interface {
+	OnFileDone(path string, done, total int)
+}
+
+// fileDone reports progress through a set of files to obs if it tracks it.
+func fileDone(obs Observer, path string, done, total int) {
+	if fo, ok := obs.(fileObserver); ok {
+		fo.OnFileDone(path, done, total)
+	}
+}
+
+func bar(done, total int64) string {
+	filled := 0
+	if total > 0 {
+		filled = int(done * progressBarWidth / total)
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "]"
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// progressReader reports the bytes read through it to an observer.
+type progressReader struct {
+	r     io.Reader
+	obs   Observer
+	path  string
+	done  int64
+	total int64
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.done += int64(n)
+		pr.obs.OnChunk(pr.path, pr.done, pr.total)
+	}
+	return n, err
+}
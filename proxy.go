@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// proxyFlag is the proxy given with --proxy.
+var proxyFlag string
+
+// proxyURL is the proxy every connection goes through, or nil.
+var proxyURL *url.URL
+
+// parseProxy checks that s names a proxy Go can dial through.
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q", s)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+		return u, nil
+	}
+	return nil, fmt.Errorf("invalid proxy %q: must be a socks5://, socks5h://, http:// or https:// URL", s)
+}
+
+// setupProxy routes relay connections and every other HTTP request through
+// proxy, falling back to $ALL_PROXY when it is empty. Without either, Go's
+// usual $HTTPS_PROXY handling applies.
+func setupProxy(proxy string) error {
+	if proxy == "" {
+		if proxy = os.Getenv("ALL_PROXY"); proxy == "" {
+			proxy = os.Getenv("all_proxy")
+		}
+	}
+	if proxy == "" {
+		return nil
+	}
+	u, err := parseProxy(proxy)
+	if err != nil {
+		return err
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("cannot configure a proxy for this HTTP transport")
+	}
+	transport.Proxy = http.ProxyURL(u)
+	proxyURL = u
+	slog.Debug("Using proxy", "proxy", u.Redacted())
+	return nil
+}
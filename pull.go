@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// Pull outcomes for a single file.
+const (
+	pullUpdated  = "updated"
+	pullUpToDate = "up-to-date"
+	pullAhead    = "ahead"
+	pullModified = "modified"
+	pullForked   = "forked"
+	pullRejected = "rejected"
+	pullMissing  = "missing"
+	pullMerged   = "merged"
+	pullConflict = "conflict"
+)
+
+// pullAuthors are the keys whose versions pull accepts: the client's own
+// keys, the repository's origin and its collaborators.
+func (c *Client) pullAuthors(cfg *Config) []string {
+	authors := c.headAuthors()
+	for _, s := range append([]string{cfg.Origin}, cfg.Collaborators...) {
+		if pk, err := parsePubkey(s); err == nil && !contains(authors, pk) {
+			authors = append(authors, pk)
+		}
+	}
+	return authors
+}
+
+// fileKinds returns the file event kinds used by authors.
+func (c *Client) fileKinds(authors []string) []int {
+	var kinds []int
+	for _, a := range authors {
+		for _, k := range c.kinds(a).files() {
+			if !containsInt(kinds, k) {
+				kinds = append(kinds, k)
+			}
+		}
+	}
+	return kinds
+}
+
+// versions fetches every validly signed version of rel by authors or their
+// subkeys.
+func (c *Client) versions(rel string, authors []string) []*nostr.Event {
+	var valid []*nostr.Event
+	for _, ev := range c.query(nostr.Filter{Kinds: c.fileKinds(authors), Authors: c.withSubkeys(authors), Tags: nostr.TagMap{"f": []string{c.pathTag(rel)}}}) {
+		if ok, _ := ev.CheckSignature(); ok && c.signsFor(ev.PubKey, authors) && c.onBranch(ev) {
+			valid = append(valid, ev)
+		}
+	}
+	return valid
+}
+
+// modifiedLocally reports whether the working copy of rel differs from what
+// the index recorded.
+func (c *Client) modifiedLocally(entry *orbi.IndexEntry) (bool, error) {
+	content, err := ioutil.ReadFile(c.Repo.Abs(entry.Path))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(content)
+	return entry.Hash != "" && hex.EncodeToString(sum[:]) != entry.Hash, nil
+}
+
+// pullFile brings rel up to date with the newest remote version and returns
+// the outcome. Local changes are merged with the remote ones.
+func (c *Client) pullFile(entry *orbi.IndexEntry, authors []string, policy *Policy, cfg *Config) (string, error) {
+	versions := c.versions(entry.Path, authors)
+	if len(versions) == 0 {
+		return pullMissing, nil
+	}
+	if heads := forkHeads(versions); len(heads) > 1 {
+		return pullForked, nil
+	}
+	var head, local *nostr.Event
+	for _, ev := range versions {
+		if head == nil || before(head, ev) {
+			head = ev
+		}
+		if ev.ID == entry.EventID {
+			local = ev
+		}
+	}
+	if head.ID == entry.EventID {
+		return pullUpToDate, nil
+	}
+	if local != nil && before(head, local) {
+		return pullAhead, nil
+	}
+	if policy != nil {
+		if err := policy.Check(c, head); err != nil {
+			return pullRejected, nil
+		}
+	}
+	if modified, err := c.modifiedLocally(entry); err != nil {
+		return "", err
+	} else if modified {
+		if local == nil || entry.Conflict {
+			return pullModified, nil
+		}
+		status, err := c.mergePulled(entry, local, head, cfg)
+		if err == nil && status != pullModified {
+			c.runPostHook(hookPostPull, entry.Path, head)
+		}
+		return status, err
+	}
+	previous, err := c.restoreVersion(entry.Path, head)
+	if err != nil {
+		return "", err
+	}
+	if err := c.Repo.LogHead("pull", entry.Path, previous, head.ID, c.fileMessage(head)); err != nil {
+		return "", err
+	}
+	c.runPostHook(hookPostPull, entry.Path, head)
+	return pullUpdated, nil
+}
+
+func cmdPull(args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ContinueOnError)
+	jsonOut := fs.Bool("json", false, "print the outcome for every file as JSON")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	policy, err := client.repoPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	idx, err := client.Repo.Index()
+	if err != nil {
+		return err
+	}
+	authors := client.pullAuthors(cfg)
+
+	type fileJSON struct {
+		Path   string `json:"path"`
+		Result string `json:"result"`
+	}
+	results := []fileJSON{}
+	counts := make(map[string]int)
+	for _, rel := range idx.Paths() {
+		status, err := client.pullFile(idx.Files[rel], authors, policy, cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		counts[status]++
+		results = append(results, fileJSON{rel, status})
+		switch {
+		case *jsonOut:
+		case status == pullUpToDate:
+		case status == pullModified:
+			fmt.Printf("%-10s %s (has local changes that can't be merged; not updated)\n", status, rel)
+		case status == pullConflict:
+			fmt.Printf("%-10s %s (fix the conflict markers, then publish)\n", status, rel)
+		case status == pullForked:
+			fmt.Printf("%-10s %s (run orbi resolve %s)\n", status, rel, rel)
+		case status == pullRejected:
+			fmt.Printf("%-10s %s (newest version fails the pinned policy)\n", status, rel)
+		default:
+			fmt.Printf("%-10s %s\n", status, rel)
+		}
+	}
+	if *jsonOut {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%d updated, %d merged, %d up to date, %d ahead, %d with local changes, %d conflicted, %d forked\n",
+			counts[pullUpdated], counts[pullMerged], counts[pullUpToDate], counts[pullAhead], counts[pullModified], counts[pullConflict], counts[pullForked])
+	}
+	if n := counts[pullModified] + counts[pullConflict] + counts[pullForked]; n > 0 {
+		return fmt.Errorf("%w: %d file(s) could not be updated", ErrConflict, n)
+	}
+	if counts[pullRejected] > 0 {
+		return fmt.Errorf("%w: %d file(s) failed the policy", ErrUntrusted, counts[pullRejected])
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// rebaseStep is one line of an edited rebase todo list.
+type rebaseStep struct {
+	action  string
+	event   *nostr.Event
+	message string
+}
+
+const rebaseHelp = `
+# Commands:
+#  p, pick <id> = use commit
+#  r, reword <id> = use commit with the message as edited on this line
+#  s, squash <id> = fold into the previous commit of the same file,
+#                   keeping this content and both messages
+#  d, drop <id> = remove commit
+#
+# Lines are "<command> <id> <path>: <message>" and run top to bottom.
+# Removing a line drops that commit.
+`
+
+// rebaseTodo renders events as the todo list shown to the user.
+func (c *Client) rebaseTodo(events []*nostr.Event) string {
+	var b strings.Builder
+	for _, ev := range events {
+		msg := strings.SplitN(c.fileMessage(ev), "\n", 2)[0]
+		fmt.Fprintf(&b, "pick %s %s: %s\n", ev.ID[:8], c.filePath(ev), msg)
+	}
+	b.WriteString(rebaseHelp)
+	return b.String()
+}
+
+// parseRebaseTodo reads an edited todo list back into steps.
+func parseRebaseTodo(todo string, events []*nostr.Event) ([]rebaseStep, error) {
+	var steps []rebaseStep
+	used := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(todo))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected \"<command> <id>\"", n)
+		}
+		var step rebaseStep
+		switch fields[0] {
+		case "p", "pick":
+			step.action = "pick"
+		case "r", "reword":
+			step.action = "reword"
+		case "s", "squash":
+			step.action = "squash"
+		case "d", "drop":
+			step.action = "drop"
+		default:
+			return nil, fmt.Errorf("line %d: unknown command %q", n, fields[0])
+		}
+		for _, ev := range events {
+			if !strings.HasPrefix(ev.ID, fields[1]) {
+				continue
+			}
+			if step.event != nil {
+				return nil, fmt.Errorf("line %d: commit id %s is ambiguous; give more of it", n, fields[1])
+			}
+			step.event = ev
+		}
+		if step.event == nil {
+			return nil, fmt.Errorf("line %d: no commit %s", n, fields[1])
+		}
+		if used[step.event.ID] {
+			return nil, fmt.Errorf("line %d: commit %s is listed twice", n, fields[1])
+		}
+		used[step.event.ID] = true
+		if step.action == "reword" && len(fields) == 3 {
+			if i := strings.Index(fields[2], ": "); i >= 0 {
+				step.message = strings.TrimSpace(fields[2][i+2:])
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, scanner.Err()
+}
+
+// applyRebase rebuilds and re-signs the commits described by steps. The
+// rewritten chain of each file starts from the parent of that file's oldest
+// commit in events, and timestamps are reassigned so the new order holds.
+// heads maps the ID of every commit in events to the rewritten head of its
+// file, empty if all of the file's commits were dropped. Messages of hidden
+// files are sealed again rather than written in the clear, and deltas are
+// rewritten in full since the versions they are based on are replaced.
+func (c *Client) applyRebase(steps []rebaseStep, events []*nostr.Event) (result []*nostr.Event, heads map[string]string, err error) {
+	type commit struct {
+		base     *nostr.Event
+		messages []string
+	}
+	var commits []*commit
+	for _, step := range steps {
+		switch step.action {
+		case "drop":
+		case "squash":
+			if len(commits) == 0 || eventPath(commits[len(commits)-1].base) != eventPath(step.event) {
+				return nil, nil, fmt.Errorf("cannot squash %s: the previous commit is not of %s", step.event.ID[:8], eventPath(step.event))
+			}
+			last := commits[len(commits)-1]
+			last.base = step.event
+			if msg := c.fileMessage(step.event); msg != "" {
+				last.messages = append(last.messages, msg)
+			}
+		default:
+			msg := c.fileMessage(step.event)
+			if step.action == "reword" {
+				msg = step.message
+			}
+			commits = append(commits, &commit{base: step.event, messages: []string{msg}})
+		}
+	}
+
+	start := events[0].CreatedAt
+	first := make(map[string]*nostr.Event)
+	for _, ev := range events {
+		if ev.CreatedAt < start {
+			start = ev.CreatedAt
+		}
+		if f, ok := first[eventPath(ev)]; !ok || before(ev, f) {
+			first[eventPath(ev)] = ev
+		}
+	}
+	last := make(map[string][]string)
+	for path, ev := range first {
+		last[path] = eventParents(ev)
+	}
+
+	for i, cm := range commits {
+		path := eventPath(cm.base)
+		if cm.base.PubKey != c.pk {
+			return nil, nil, fmt.Errorf("commit %s was signed by another key", cm.base.ID[:8])
+		}
+		src := cm.base
+		if src.Tags.Find("delta") != nil {
+			content, err := c.eventContent(src)
+			if err != nil {
+				return nil, nil, fmt.Errorf("commit %s: %w", src.ID[:8], err)
+			}
+			full, err := orbi.ExpandDelta(src, c.sk, content)
+			if err != nil {
+				return nil, nil, fmt.Errorf("commit %s: %w", src.ID[:8], err)
+			}
+			src = &full
+		}
+		ev := &nostr.Event{
+			PubKey:    c.pk,
+			CreatedAt: start + nostr.Timestamp(i),
+			Kind:      src.Kind,
+			Content:   src.Content,
+		}
+		msg := strings.Join(cm.messages, "\n\n")
+		sealed := src.Tags.Find("sealed") != nil
+		for _, tag := range src.Tags {
+			if tag[0] == "m" || (tag[0] == "e" && len(tag) >= 4 && tag[3] == "parent") {
+				continue
+			}
+			if tag[0] == "sealed" {
+				if tag, err = orbi.ResealMessage(cm.base, c.sk, msg); err != nil {
+					return nil, nil, fmt.Errorf("commit %s: %w", cm.base.ID[:8], err)
+				}
+			}
+			ev.Tags = append(ev.Tags, tag)
+			if tag[0] == "f" {
+				if !sealed && msg != "" {
+					ev.Tags = append(ev.Tags, nostr.Tag{"m", msg})
+				}
+				for _, p := range last[path] {
+					ev.Tags = append(ev.Tags, nostr.Tag{"e", p, "", "parent"})
+				}
+			}
+		}
+		if err := c.sign(ev); err != nil {
+			return nil, nil, err
+		}
+		last[path] = []string{ev.ID}
+		result = append(result, ev)
+	}
+	heads = make(map[string]string)
+	for _, ev := range events {
+		if parents := last[eventPath(ev)]; len(parents) > 0 {
+			heads[ev.ID] = parents[0]
+		} else {
+			heads[ev.ID] = ""
+		}
+	}
+	return result, heads, nil
+}
+
+// editText lets the user edit text in $VISUAL or $EDITOR.
+func editText(text string) (string, error) {
+	f, err := ioutil.TempFile("", "orbi-rebase-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor failed: %w", err)
+	}
+	content, err := ioutil.ReadFile(f.Name())
+	return string(content), err
+}
+
+// rewritePublished replaces published commits old with rewritten ones: the
+// new events are published, the old ones deleted, and the index moved to the
+// new heads.
+func (c *Client) rewritePublished(old, rewritten []*nostr.Event, heads map[string]string) error {
+	for _, ev := range rewritten {
+		if err := c.publish(ev); err != nil {
+			return err
+		}
+	}
+	deletion := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindDeletion,
+		Content:   "history rewritten by orbi rebase",
+	}
+	for _, ev := range old {
+		deletion.Tags = append(deletion.Tags, nostr.Tag{"e", ev.ID})
+	}
+	if err := c.sign(&deletion); err != nil {
+		return err
+	}
+	if err := c.publish(&deletion); err != nil {
+		return err
+	}
+	return c.Repo.MoveHeads("rebase", heads)
+}
+
+func cmdRebase(args []string) error {
+	fs := flag.NewFlagSet("rebase", flag.ContinueOnError)
+	interactive := fs.Bool("i", false, "edit the commit list interactively")
+	published := fs.Bool("published", false, "rewrite already published history (encrypted repositories only)")
+	since := fs.String("since", "", "with --published, rewrite commits after this event id or since this time")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if !*interactive || len(positional) != 0 {
+		return fmt.Errorf("usage: orbi rebase -i [--published [--since <event-id|time>]]")
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	var events []*nostr.Event
+	if *published {
+		from, err := client.resolveSince(*since)
+		if err != nil {
+			return err
+		}
+		events = client.History("", from)
+		for _, ev := range events {
+			if ev.Tags.Find("encrypted") == nil {
+				return fmt.Errorf("%s (%s) is public; published history can only be rewritten in encrypted repositories", eventPath(ev), ev.ID[:8])
+			}
+		}
+	} else if events, err = client.Repo.Outbox(); err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		fmt.Println("Nothing to rebase")
+		return nil
+	}
+
+	todo, err := editText(client.rebaseTodo(events))
+	if err != nil {
+		return err
+	}
+	steps, err := parseRebaseTodo(todo, events)
+	if err != nil {
+		return err
+	}
+	rewritten, heads, err := client.applyRebase(steps, events)
+	if err != nil {
+		return err
+	}
+	if *published {
+		err = client.rewritePublished(events, rewritten, heads)
+	} else {
+		err = client.Repo.ReplaceOutbox(rewritten, heads)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rebased %d commits into %d\n", len(events), len(rewritten))
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+func TestApplyRebase(t *testing.T) {
+	c, _ := newTestClient(t)
+	a1 := testEvent(t, c, "a.txt", "a one\n", 1700000000, orbi.WithMessage("one"))
+	a2 := testEvent(t, c, "a.txt", "a two\n", 1700000001, orbi.WithMessage("two"), orbi.WithParent(a1.ID))
+	b1 := testEvent(t, c, "b.txt", "b one\n", 1700000002, orbi.WithMessage("three"))
+	events := []*nostr.Event{a1, a2, b1}
+
+	type version struct{ path, content, message string }
+	tests := []struct {
+		name  string
+		steps []rebaseStep
+		want  []version
+		err   string
+	}{
+		{
+			name:  "pick",
+			steps: []rebaseStep{{action: "pick", event: a1}, {action: "pick", event: a2}, {action: "pick", event: b1}},
+			want:  []version{{"a.txt", "a one\n", "one"}, {"a.txt", "a two\n", "two"}, {"b.txt", "b one\n", "three"}},
+		},
+		{
+			name:  "reword",
+			steps: []rebaseStep{{action: "reword", event: a1, message: "first"}, {action: "pick", event: a2}, {action: "pick", event: b1}},
+			want:  []version{{"a.txt", "a one\n", "first"}, {"a.txt", "a two\n", "two"}, {"b.txt", "b one\n", "three"}},
+		},
+		{
+			name:  "squash",
+			steps: []rebaseStep{{action: "pick", event: a1}, {action: "squash", event: a2}, {action: "pick", event: b1}},
+			want:  []version{{"a.txt", "a two\n", "one\n\ntwo"}, {"b.txt", "b one\n", "three"}},
+		},
+		{
+			name:  "drop",
+			steps: []rebaseStep{{action: "pick", event: a1}, {action: "drop", event: a2}, {action: "pick", event: b1}},
+			want:  []version{{"a.txt", "a one\n", "one"}, {"b.txt", "b one\n", "three"}},
+		},
+		{
+			name:  "reorder",
+			steps: []rebaseStep{{action: "pick", event: b1}, {action: "pick", event: a1}, {action: "pick", event: a2}},
+			want:  []version{{"b.txt", "b one\n", "three"}, {"a.txt", "a one\n", "one"}, {"a.txt", "a two\n", "two"}},
+		},
+		{
+			name:  "squash into another file",
+			steps: []rebaseStep{{action: "pick", event: a1}, {action: "squash", event: b1}},
+			err:   "cannot squash",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, heads, err := c.applyRebase(tt.steps, events)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d commits, want %d", len(got), len(tt.want))
+			}
+			last := make(map[string]string)
+			for i, ev := range got {
+				if ok, _ := ev.CheckSignature(); !ok {
+					t.Errorf("commit %d has a bad signature", i)
+				}
+				content, err := c.eventContent(ev)
+				if err != nil {
+					t.Fatal(err)
+				}
+				w := tt.want[i]
+				if eventPath(ev) != w.path || string(content) != w.content || eventMessage(ev) != w.message {
+					t.Errorf("commit %d is %s %q %q, want %s %q %q", i, eventPath(ev), content, eventMessage(ev), w.path, w.content, w.message)
+				}
+				var wantParents []string
+				if p, ok := last[w.path]; ok {
+					wantParents = []string{p}
+				}
+				if parents := eventParents(ev); strings.Join(parents, ",") != strings.Join(wantParents, ",") {
+					t.Errorf("commit %d has parents %v, want %v", i, parents, wantParents)
+				}
+				if i > 0 && !before(got[i-1], ev) {
+					t.Errorf("commit %d is not newer than commit %d", i, i-1)
+				}
+				last[w.path] = ev.ID
+			}
+			for _, ev := range events {
+				if heads[ev.ID] != last[eventPath(ev)] {
+					t.Errorf("head of %s after %s is %s, want %s", eventPath(ev), short(ev.ID), short(heads[ev.ID]), short(last[eventPath(ev)]))
+				}
+			}
+		})
+	}
+}
+
+func TestApplyRebaseSealsHiddenMessages(t *testing.T) {
+	c, _ := newTestClient(t)
+	hidden := []orbi.EventOption{orbi.WithEncryption(c.sk, c.pk), orbi.WithHiddenPath()}
+	e1 := testEvent(t, c, "secret.txt", "one\n", 1700000000, append(hidden, orbi.WithMessage("one"))...)
+
+	got, _, err := c.applyRebase([]rebaseStep{{action: "reword", event: e1, message: "private"}}, []*nostr.Event{e1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag := got[0].Tags.Find("m"); tag != nil {
+		t.Errorf("hidden commit got a clear message tag %v", tag)
+	}
+	meta, err := orbi.UnsealMeta(got[0], c.sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Path != "secret.txt" || meta.Message != "private" {
+		t.Errorf("sealed metadata is %q %q, want secret.txt private", meta.Path, meta.Message)
+	}
+}
+
+func TestApplyRebaseExpandsDeltas(t *testing.T) {
+	c, _ := newTestClient(t)
+	v1 := strings.Repeat("a line of text\n", 20)
+	v2 := strings.Replace(v1, "a line", "one line", 1)
+	e1 := testEvent(t, c, "a.txt", v1, 1700000000)
+	e2 := testEvent(t, c, "a.txt", v2, 1700000001, orbi.WithParent(e1.ID), orbi.WithDelta(e1.ID, unifiedDiff("a.txt", "a.txt", v1, v2), 1))
+	if e2.Tags.Find("delta") == nil {
+		t.Fatal("test event is not a delta")
+	}
+	for _, ev := range []*nostr.Event{e1, e2} {
+		if err := c.Repo.addToOutbox(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, heads, err := c.applyRebase([]rebaseStep{{action: "drop", event: e1}, {action: "pick", event: e2}}, []*nostr.Event{e1, e2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Repo.ReplaceOutbox(got, heads); err != nil {
+		t.Fatal(err)
+	}
+	if tag := got[0].Tags.Find("delta"); tag != nil {
+		t.Errorf("rewritten commit still has %v", tag)
+	}
+	content, err := c.eventContent(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != v2 {
+		t.Errorf("rewritten commit has %q, want %q", content, v2)
+	}
+}
+
+func TestParseRebaseTodo(t *testing.T) {
+	c, _ := newTestClient(t)
+	a1 := testEvent(t, c, "a.txt", "a one\n", 1700000000, orbi.WithMessage("one"))
+	b1 := testEvent(t, c, "b.txt", "b one\n", 1700000001, orbi.WithMessage("two"))
+	events := []*nostr.Event{a1, b1}
+
+	steps, err := parseRebaseTodo(c.rebaseTodo(events), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].event != a1 || steps[1].event != b1 || steps[0].action != "pick" {
+		t.Errorf("the unedited todo list parses to %+v", steps)
+	}
+
+	todo := "r " + a1.ID[:8] + " a.txt: first\n# comment\n\ns " + b1.ID[:8] + "\n"
+	steps, err = parseRebaseTodo(todo, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].action != "reword" || steps[0].message != "first" || steps[1].action != "squash" {
+		t.Errorf("the edited todo list parses to %+v", steps)
+	}
+
+	for _, tt := range []struct {
+		name, todo, err string
+	}{
+		{"unknown command", "edit " + a1.ID[:8], "unknown command"},
+		{"missing id", "pick", "expected"},
+		{"unknown id", "pick 00000000", "no commit"},
+		{"listed twice", "pick " + a1.ID[:8] + "\npick " + a1.ID[:8], "listed twice"},
+	} {
+		if _, err := parseRebaseTodo(tt.todo, events); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+
+	// Find two commits whose ids share a first digit.
+	seen := make(map[byte]*nostr.Event)
+	for i := int64(0); ; i++ {
+		ev := testEvent(t, c, "c.txt", "c\n", 1700000002+i)
+		if other, ok := seen[ev.ID[0]]; ok {
+			_, err := parseRebaseTodo("pick "+ev.ID[:1], []*nostr.Event{other, ev})
+			if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+				t.Errorf("got error %v for a prefix of two commits, want ambiguous", err)
+			}
+			break
+		}
+		seen[ev.ID[0]] = ev
+	}
+}
+
+func TestReplaceOutbox(t *testing.T) {
+	c, _ := newTestClient(t)
+	a1 := testEvent(t, c, "a.txt", "a one\n", 1700000000)
+	a2 := testEvent(t, c, "a.txt", "a two\n", 1700000001)
+	b1 := testEvent(t, c, "b.txt", "b one\n", 1700000002)
+	for _, ev := range []*nostr.Event{a1, a2} {
+		if err := c.Repo.AddToOutbox(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Repo.ReplaceOutbox([]*nostr.Event{a2, b1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	queued, err := c.Repo.Outbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, ev := range queued {
+		ids = append(ids, ev.ID)
+	}
+	if len(ids) != 2 || !strings.Contains(strings.Join(ids, ","), a2.ID) || !strings.Contains(strings.Join(ids, ","), b1.ID) {
+		t.Errorf("outbox holds %v, want %s and %s", ids, a2.ID, b1.ID)
+	}
+}
+
+func TestReplaceOutboxMovesHeads(t *testing.T) {
+	c, _ := newTestClient(t)
+	a1 := testEvent(t, c, "a.txt", "a one\n", 1700000000, orbi.WithMessage("one"))
+	a2 := testEvent(t, c, "a.txt", "a two\n", 1700000001, orbi.WithMessage("two"), orbi.WithParent(a1.ID))
+	events := []*nostr.Event{a1, a2}
+	for _, ev := range events {
+		if err := c.Repo.addToOutbox(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := c.Repo.UpdateIndex(func(idx *orbi.Index) error {
+		idx.Files["a.txt"] = &orbi.IndexEntry{Path: "a.txt", EventID: a2.ID}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, heads, err := c.applyRebase([]rebaseStep{{action: "pick", event: a1}, {action: "squash", event: a2}}, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Repo.ReplaceOutbox(got, heads); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := c.Repo.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := idx.Files["a.txt"].EventID; id != got[0].ID {
+		t.Errorf("index head is %s, want %s", short(id), short(got[0].ID))
+	}
+	queued, err := c.Repo.Outbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].ID != got[0].ID {
+		t.Errorf("outbox holds %d events, want only the rewritten one", len(queued))
+	}
+	entries, err := c.Repo.Reflog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Op != "rebase" || entries[0].From != a2.ID || entries[0].To != got[0].ID {
+		t.Errorf("reflog is %+v, want one rebase from %s to %s", entries, short(a2.ID), short(got[0].ID))
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+const reflogFileName = "reflog"
+
+// ReflogEntry records one movement of a file's local head.
+type ReflogEntry struct {
+	Time    int64  `json:"time"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Message string `json:"message,omitempty"`
+}
+
+// LogHead appends a head movement of rel from one event to another to
+// .orbi/reflog, one JSON object per line.
+func (r *Repo) LogHead(op, rel, from, to, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	return r.logHead(op, rel, from, to, message)
+}
+
+func (r *Repo) logHead(op, rel, from, to, message string) error {
+	line, err := json.Marshal(ReflogEntry{Time: time.Now().Unix(), Op: op, Path: rel, From: from, To: to, Message: message})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(r.dir(), reflogFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// MoveHeads points every index entry whose event is a key of heads at the
+// corresponding value, logging each move under op.
+func (r *Repo) MoveHeads(op string, heads map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	return r.moveHeads(op, heads)
+}
+
+func (r *Repo) moveHeads(op string, heads map[string]string) error {
+	idx, err := r.index()
+	if err != nil {
+		return err
+	}
+	moved := make(map[string]string)
+	for path, entry := range idx.Files {
+		if to, ok := heads[entry.EventID]; ok && entry.EventID != "" && to != entry.EventID {
+			moved[path] = entry.EventID
+			entry.EventID = to
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	if err := r.writeIndex(idx); err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(moved))
+	for path := range moved {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := r.logHead(op, path, moved[path], idx.Files[path].EventID, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reflog returns the recorded head movements, newest first.
+func (r *Repo) Reflog() ([]ReflogEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, err := os.Open(filepath.Join(r.dir(), reflogFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e ReflogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", filepath.Join(localOrbiDirName, reflogFileName), err)
+		}
+		entries = append([]ReflogEntry{e}, entries...)
+	}
+	return entries, scanner.Err()
+}
+
+// restoreVersion writes the content of ev to rel, with line endings per the
+// eol setting, and makes ev the local head of rel. It returns the previous
+// head.
+func (c *Client) restoreVersion(rel string, ev *nostr.Event) (string, error) {
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return "", fmt.Errorf("%w: event %s has an invalid signature", ErrUntrusted, ev.ID)
+	}
+	content, err := c.eventContent(ev)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		return "", err
+	}
+	content = applyEOL(content, cfg.EOL)
+	meta := c.fileMetadata(ev)
+	if err := c.Repo.WriteFile(rel, content, fileMode(meta)); err != nil {
+		return "", err
+	}
+	// WriteFile leaves the mode of an existing file alone.
+	if meta.Mode != 0 {
+		if err := os.Chmod(c.Repo.Abs(rel), meta.Mode); err != nil {
+			return "", err
+		}
+	}
+	if meta.MTime != 0 && !cfg.IgnoreMtime {
+		mtime := time.Unix(meta.MTime, 0)
+		if err := os.Chtimes(c.Repo.Abs(rel), time.Now(), mtime); err != nil {
+			return "", err
+		}
+	}
+	info, err := os.Stat(c.Repo.Abs(rel))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	var previous string
+	err = c.Repo.UpdateIndex(func(idx *orbi.Index) error {
+		if entry, ok := idx.Files[rel]; ok {
+			previous = entry.EventID
+		}
+		idx.Files[rel] = &orbi.IndexEntry{
+			Path:      rel,
+			EventID:   ev.ID,
+			Hash:      hex.EncodeToString(sum[:]),
+			Size:      info.Size(),
+			ModTime:   info.ModTime().Unix(),
+			Encrypted: ev.Tags.Find("encrypted") != nil,
+			PathTag:   indexPathTag(rel, ev),
+		}
+		return nil
+	})
+	return previous, err
+}
+
+// fileMode returns the permissions to write a file with: those recorded in
+// meta, or 0644.
+func fileMode(meta orbi.FileMetadata) os.FileMode {
+	if meta.Mode != 0 {
+		return meta.Mode
+	}
+	return 0644
+}
+
+func cmdReflog(args []string) error {
+	fs := flag.NewFlagSet("reflog", flag.ContinueOnError)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	entries, err := openRepo(".").Reflog()
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		fmt.Printf("@{%d} %s %-7s %s %s", i, time.Unix(e.Time, 0).Format("2006-01-02 15:04:05"), e.Op, short(e.To), e.Path)
+		if e.Message != "" {
+			fmt.Printf(": %s", e.Message)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// short abbreviates an event id for display.
+func short(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func cmdUndo(args []string) error {
+	fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	n := 0
+	if len(positional) == 1 {
+		if n, err = strconv.Atoi(positional[0]); err != nil || n < 0 {
+			return fmt.Errorf("invalid reflog entry %q", positional[0])
+		}
+	} else if len(positional) > 1 {
+		return fmt.Errorf("usage: orbi undo [<n>]")
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	entries, err := client.Repo.Reflog()
+	if err != nil {
+		return err
+	}
+	if n >= len(entries) {
+		return fmt.Errorf("reflog entry @{%d}: %w", n, ErrNotFound)
+	}
+	e := entries[n]
+	if e.From == "" {
+		return fmt.Errorf("%s had no earlier version before @{%d}", e.Path, n)
+	}
+	events := client.query(nostr.Filter{IDs: []string{e.From}})
+	if len(events) == 0 {
+		return fmt.Errorf("event %s: %w", e.From, ErrNotFound)
+	}
+	previous, err := client.restoreVersion(e.Path, events[0])
+	if err != nil {
+		return err
+	}
+	if err := client.Repo.LogHead("undo", e.Path, previous, e.From, fmt.Sprintf("undo @{%d} %s", n, e.Op)); err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s to %s\n", e.Path, e.From)
+	if e.Op == "commit" || e.Op == "merge" {
+		fmt.Printf("%s stays published; publishing again will need --force.\n", short(e.To))
+	}
+	return nil
+}
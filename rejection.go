@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// rateLimitBackoff is how long to wait before each retry of a publish a relay
+// refused with "rate-limited:".
+var rateLimitBackoff = []time.Duration{2 * time.Second, 10 * time.Second, 30 * time.Second}
+
+// rejectionPrefix returns the NIP-01 prefix of err if it is a rejection.
+func rejectionPrefix(err error) string {
+	var rej *orbi.Rejection
+	if errors.As(err, &rej) {
+		return rej.Prefix
+	}
+	return ""
+}
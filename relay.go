@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// saveGlobalConfig writes cfg to the global config.
+func saveGlobalConfig(cfg *Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	path := globalConfigPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+// parseRelayURL checks that s is a websocket URL and normalizes it.
+func parseRelayURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "wss" && u.Scheme != "ws") || u.Host == "" {
+		return "", fmt.Errorf("invalid relay URL %q: must start with wss:// or ws://", s)
+	}
+	return nostr.NormalizeURL(s), nil
+}
+
+// relayConfig loads the repository's config, or the global one, for editing
+// its relays. It returns the config and a function saving it.
+func relayConfig(global bool) (*Config, func(*Config) error, error) {
+	if global {
+		cfg, err := readGlobalConfig()
+		return cfg, saveGlobalConfig, err
+	}
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	return cfg, repo.SaveConfig, err
+}
+
+// removeRelay drops every entry of relays equal to r once normalized.
+func removeRelay(relays []string, r string) ([]string, bool) {
+	var kept []string
+	for _, s := range relays {
+		if nostr.NormalizeURL(s) != r {
+			kept = append(kept, s)
+		}
+	}
+	return kept, len(kept) != len(relays)
+}
+
+func cmdRelay(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "add":
+			return cmdRelayAdd(args[1:])
+		case "rm":
+			return cmdRelayRm(args[1:])
+		case "list":
+			return cmdRelayList(args[1:])
+		case "test":
+			return cmdRelayTest(args[1:])
+		}
+	}
+	return fmt.Errorf("usage: orbi relay add|rm|list|test")
+}
+
+func cmdRelayAdd(args []string) error {
+	fs := flag.NewFlagSet("relay add", flag.ContinueOnError)
+	global := fs.Bool("global", false, "edit the global config instead of the repository's")
+	read := fs.Bool("read", false, "add read relays instead of write relays")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: orbi relay add [--global] [--read] <url>...")
+	}
+	cfg, save, err := relayConfig(*global)
+	if err != nil {
+		return err
+	}
+	if !*read && len(cfg.RelayGroups) > 0 {
+		return fmt.Errorf("relay_groups are configured; edit them in the config file instead")
+	}
+	for _, s := range positional {
+		r, err := parseRelayURL(s)
+		if err != nil {
+			return err
+		}
+		list := &cfg.WriteRelays
+		if *read {
+			list = &cfg.ReadRelays
+		}
+		if _, found := removeRelay(*list, r); found {
+			fmt.Printf("%s is already configured\n", r)
+			continue
+		}
+		*list = append(*list, r)
+		fmt.Printf("Added %s\n", r)
+	}
+	return save(cfg)
+}
+
+func cmdRelayRm(args []string) error {
+	fs := flag.NewFlagSet("relay rm", flag.ContinueOnError)
+	global := fs.Bool("global", false, "edit the global config instead of the repository's")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: orbi relay rm [--global] <url>...")
+	}
+	cfg, save, err := relayConfig(*global)
+	if err != nil {
+		return err
+	}
+	for _, s := range positional {
+		r := nostr.NormalizeURL(s)
+		var inWrite, inRead bool
+		cfg.WriteRelays, inWrite = removeRelay(cfg.WriteRelays, r)
+		cfg.ReadRelays, inRead = removeRelay(cfg.ReadRelays, r)
+		inGroup := false
+		for _, g := range cfg.RelayGroups {
+			if _, found := removeRelay(g.Relays, r); found {
+				inGroup = true
+			}
+		}
+		switch {
+		case inGroup:
+			return fmt.Errorf("%s is in relay_groups; edit them in the config file instead", r)
+		case !inWrite && !inRead:
+			return fmt.Errorf("relay %s: %w", r, ErrNotFound)
+		}
+		fmt.Printf("Removed %s\n", r)
+	}
+	return save(cfg)
+}
+
+func cmdRelayList(args []string) error {
+	fs := flag.NewFlagSet("relay list", flag.ContinueOnError)
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	if cfg, err = withGlobalRelays(cfg); err != nil {
+		return err
+	}
+	health, err := repo.RelayHealth()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RELAY\tROLE\tSUCCESS\tLATENCY\t")
+	row := func(r, role string) {
+		h, ok := health[r]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t\n", r, role)
+			return
+		}
+		status := fmt.Sprintf("%d/%d", h.Successes, h.Successes+h.Failures)
+		if h.unhealthy() {
+			status += " (failing)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%dms\t\n", r, role, status, int(h.LatencyMS))
+	}
+	switch {
+	case len(cfg.RelayGroups) > 0:
+		for i, g := range cfg.RelayGroups {
+			for _, r := range g.Relays {
+				row(r, g.label(i))
+			}
+		}
+	case len(cfg.WriteRelays) > 0:
+		for _, r := range cfg.WriteRelays {
+			row(r, "write")
+		}
+	default:
+		for _, r := range defaultRelays {
+			row(r, "default (or your NIP-65 relay list)")
+		}
+	}
+	for _, r := range cfg.ReadRelays {
+		row(r, "read")
+	}
+	return w.Flush()
+}
+
+// relayTest is the outcome of testing one relay.
+type relayTest struct {
+	latency time.Duration
+	// accepted is whether the relay stored a file event; err explains why
+	// not.
+	accepted bool
+	err      error
+}
+
+// testRelay connects to url, timing the connection, and publishes a file
+// event of kind from a throwaway key, deleting it again if accepted.
+func testRelay(t orbi.WebsocketTransport, url string, kind int) relayTest {
+	var res relayTest
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRelayTimeout)
+	defer cancel()
+	start := time.Now()
+	relay, err := t.Connect(ctx, url)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer relay.Close()
+	res.latency = time.Since(start)
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	ev := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: nostr.Now(),
+		Kind:      kind,
+		Tags:      nostr.Tags{{"ver", eventFormatVersion}, {"f", ".orbi-relay-test"}, {"t", "orbi-relay-test"}},
+	}
+	ev.Sign(sk)
+	if err := relay.Publish(ctx, ev); err != nil {
+		res.err = orbi.ParseRejection(err.Error())
+		return res
+	}
+	res.accepted = true
+	deletion := nostr.Event{
+		PubKey:    pk,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindDeletion,
+		Tags:      nostr.Tags{{"e", ev.ID}},
+	}
+	deletion.Sign(sk)
+	relay.Publish(ctx, deletion)
+	return res
+}
+
+func cmdRelayTest(args []string) error {
+	fs := flag.NewFlagSet("relay test", flag.ContinueOnError)
+	relays, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	if cfg, err = withGlobalRelays(cfg); err != nil {
+		return err
+	}
+	tlsConfigs, err := relayTLSConfigs(cfg)
+	if err != nil {
+		return err
+	}
+	if len(relays) == 0 {
+		relays = configuredRelays(cfg)
+	}
+	kind := eventKindFile
+	if cfg.Kinds != nil {
+		kind = cfg.Kinds.withDefaults().File
+	}
+
+	t := orbi.WebsocketTransport{TLS: tlsConfigs, Proxied: proxyURL != nil}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "RELAY\tLATENCY\tKIND %d\t\n", kind)
+	failed := 0
+	for _, url := range relays {
+		res := testRelay(t, url, kind)
+		switch {
+		case res.latency == 0:
+			failed++
+			fmt.Fprintf(w, "%s\tunreachable\t%v\t\n", url, res.err)
+		case !res.accepted:
+			failed++
+			fmt.Fprintf(w, "%s\t%s\trejected: %v\t\n", url, res.latency.Round(time.Millisecond), res.err)
+		default:
+			fmt.Fprintf(w, "%s\t%s\taccepted\t\n", url, res.latency.Round(time.Millisecond))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d relays failed", ErrRelayRejected, failed, len(relays))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// globalConfigPath returns the user-wide config whose relay settings apply to
+// repositories that don't set their own.
+func globalConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" && runtime.GOOS == "windows" {
+		// %APPDATA%
+		dir, _ = os.UserConfigDir()
+	}
+	if dir == "" {
+		dir = expandPath("~/.config")
+	}
+	return filepath.Join(dir, "orbi", configFileName)
+}
+
+// readGlobalConfig reads the global config. A missing file yields the zero
+// Config.
+func readGlobalConfig() (*Config, error) {
+	path := globalConfigPath()
+	cfg := &Config{}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// withGlobalRelays returns a copy of cfg whose unset relay settings are taken
+// from the global config. Write relays and relay groups count as one setting.
+func withGlobalRelays(cfg *Config) (*Config, error) {
+	global, err := readGlobalConfig()
+	if err != nil {
+		return nil, err
+	}
+	merged := *cfg
+	if len(merged.WriteRelays) == 0 && len(merged.RelayGroups) == 0 {
+		merged.WriteRelays = global.WriteRelays
+		merged.RelayGroups = global.RelayGroups
+	}
+	if len(merged.ReadRelays) == 0 {
+		merged.ReadRelays = global.ReadRelays
+	}
+	if merged.Timeout == "" {
+		merged.Timeout = global.Timeout
+	}
+	if merged.MinRelays == 0 {
+		merged.MinRelays = global.MinRelays
+	}
+	if merged.Retry == nil {
+		merged.Retry = global.Retry
+	}
+	if merged.Proxy == "" {
+		merged.Proxy = global.Proxy
+	}
+	if merged.PoW == 0 {
+		merged.PoW = global.PoW
+	}
+	if len(global.RelayPoW) > 0 {
+		merged.RelayPoW = make(map[string]int)
+		for url, d := range global.RelayPoW {
+			merged.RelayPoW[url] = d
+		}
+		for url, d := range cfg.RelayPoW {
+			merged.RelayPoW[url] = d
+		}
+	}
+	if len(global.RelayTLS) > 0 {
+		merged.RelayTLS = make(map[string]*RelayTLS)
+		for url, rt := range global.RelayTLS {
+			merged.RelayTLS[url] = rt
+		}
+		for url, rt := range cfg.RelayTLS {
+			merged.RelayTLS[url] = rt
+		}
+	}
+	return &merged, nil
+}
+
+// relayTimeout returns the configured per-relay timeout.
+func relayTimeout(cfg *Config) time.Duration {
+	if d, err := time.ParseDuration(cfg.Timeout); err == nil {
+		return d
+	}
+	return defaultRelayTimeout
+}
+
+// SetReadRelays makes queries go to relays instead of the publishing relays.
+// An empty list reads from the publishing relays again.
+func (c *Client) SetReadRelays(relays []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readRelays = append([]string(nil), relays...)
+}
+
+// ReadRelays returns a copy of the relays queries go to.
+func (c *Client) ReadRelays() []string {
+	c.discoverRelays()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.readRelays) > 0 {
+		return append([]string(nil), c.readRelays...)
+	}
+	return append([]string(nil), c.relays...)
+}
+
+// readGroups returns the groups queries go through: the read relays as a
+// single group when set, otherwise the publishing groups.
+func (c *Client) readGroups() []RelayGroup {
+	c.discoverRelays()
+	c.mu.RLock()
+	read := append([]string(nil), c.readRelays...)
+	c.mu.RUnlock()
+	if len(read) > 0 {
+		return []RelayGroup{{Relays: read}}
+	}
+	return c.relayGroups()
+}
+
+// timeout bounds a single operation on one relay.
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return defaultRelayTimeout
+}
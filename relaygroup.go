@@ -0,0 +1,86 @@
+package main
+
+import "fmt"
+
+// RelayGroup is an ordered tier of relays. Events go to every relay in the
+// first group; later groups are only used when fewer than Min relays have
+// succeeded so far.
+type RelayGroup struct {
+	Name   string   `json:"name,omitempty"`
+	Relays []string `json:"relays"`
+	// Min is how many relays must succeed before later groups are skipped.
+	// Zero means one.
+	Min int `json:"min,omitempty"`
+}
+
+func (g RelayGroup) min() int {
+	if g.Min <= 0 {
+		return 1
+	}
+	return g.Min
+}
+
+func (g RelayGroup) label(i int) string {
+	if g.Name != "" {
+		return g.Name
+	}
+	return fmt.Sprintf("relay group %d", i+1)
+}
+
+func validateRelayGroups(groups []RelayGroup) error {
+	for i, g := range groups {
+		if len(g.Relays) == 0 {
+			return fmt.Errorf("%s has no relays", g.label(i))
+		}
+		if g.Min < 0 {
+			return fmt.Errorf("%s: min must not be negative", g.label(i))
+		}
+	}
+	return nil
+}
+
+// SetRelayGroups replaces the client's relays with ordered failover groups.
+func (c *Client) SetRelayGroups(groups []RelayGroup) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups = append([]RelayGroup(nil), groups...)
+	c.useRelayList = false
+	c.relays = nil
+	for _, g := range groups {
+		c.relays = append(c.relays, g.Relays...)
+	}
+}
+
+// relayGroups returns the failover groups, treating a plain relay list as a
+// single group.
+func (c *Client) relayGroups() []RelayGroup {
+	c.discoverRelays()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.groups) == 0 {
+		return []RelayGroup{{Relays: append([]string(nil), c.relays...)}}
+	}
+	return append([]RelayGroup(nil), c.groups...)
+}
+
+// configuredRelays lists every relay cfg uses, in group order followed by
+// any separate read relays, or the default relays.
+func configuredRelays(cfg *Config) []string {
+	var relays []string
+	switch {
+	case len(cfg.RelayGroups) > 0:
+		for _, g := range cfg.RelayGroups {
+			relays = append(relays, g.Relays...)
+		}
+	case len(cfg.WriteRelays) > 0:
+		relays = append(relays, cfg.WriteRelays...)
+	default:
+		relays = append(relays, defaultRelays...)
+	}
+	for _, r := range cfg.ReadRelays {
+		if !contains(relays, r) {
+			relays = append(relays, r)
+		}
+	}
+	return relays
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var semverRe = regexp.MustCompile(`^v(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// ReleaseConfig is the signing policy enforced by 'orbi release'.
+type ReleaseConfig struct {
+	// Signers lists the npubs (or hex keys) allowed to cut releases. Empty
+	// means anyone holding the repository key.
+	Signers []string `json:"signers,omitempty"`
+	// AllowDirty permits releasing with unpublished local changes.
+	AllowDirty bool `json:"allow_dirty,omitempty"`
+}
+
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+func parseSemver(v string) (semver, error) {
+	m := semverRe.FindStringSubmatch(v)
+	if m == nil {
+		return semver{}, fmt.Errorf("invalid version %q: expected vMAJOR.MINOR.PATCH", v)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	patch, _ := strconv.Atoi(m[3])
+	return semver{major, minor, patch, m[4]}, nil
+}
+
+// less orders versions by precedence, with pre-releases before the release
+// they lead up to.
+func (a semver) less(b semver) bool {
+	if a.major != b.major {
+		return a.major < b.major
+	}
+	if a.minor != b.minor {
+		return a.minor < b.minor
+	}
+	if a.patch != b.patch {
+		return a.patch < b.patch
+	}
+	if a.pre == "" || b.pre == "" {
+		return a.pre != "" && b.pre == ""
+	}
+	return a.pre < b.pre
+}
+
+// latestRelease returns the highest released version among the client's tag
+// events, or nil if there are none.
+func (c *Client) latestRelease() (*nostr.Event, semver) {
+	var latest *nostr.Event
+	var latestV semver
+	for _, ev := range c.TagEvents("") {
+		if ev.Tags.FindWithValue("t", "release") == nil {
+			continue
+		}
+		v, err := parseSemver(tagName(ev))
+		if err != nil {
+			continue
+		}
+		if latest == nil || latestV.less(v) {
+			latest, latestV = ev, v
+		}
+	}
+	return latest, latestV
+}
+
+// checkSigner enforces the configured release signers.
+func (c *Client) checkSigner(cfg *ReleaseConfig) error {
+	if cfg == nil || len(cfg.Signers) == 0 {
+		return nil
+	}
+	for _, s := range cfg.Signers {
+		if pk, err := parsePubkey(s); err == nil && pk == c.Identity() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: this key is not an allowed release signer", ErrUntrusted)
+}
+
+// findRelease returns author's release tag event for version.
+func (c *Client) findRelease(author, version string) (*nostr.Event, error) {
+	for _, ev := range c.TagEvents(author) {
+		if ev.Tags.FindWithValue("t", "release") != nil && tagName(ev) == version {
+			return ev, nil
+		}
+	}
+	return nil, fmt.Errorf("release %s: %w", version, ErrNotFound)
+}
+
+func cmdRelease(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "attach":
+			return cmdReleaseAttach(args[1:])
+		case "download":
+			return cmdReleaseDownload(args[1:])
+		}
+	}
+
+	fs := flag.NewFlagSet("release", flag.ContinueOnError)
+	conventional := fs.Bool("conventional", false, "group conventional-commit messages in the changelog")
+	article := fs.Bool("article", false, "also publish the changelog as a NIP-23 article")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: orbi release vX.Y.Z | attach <version> <file>... | download <version> [name]...")
+	}
+	version := positional[0]
+	v, err := parseSemver(version)
+	if err != nil {
+		return err
+	}
+
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	if err := client.checkSigner(cfg.Release); err != nil {
+		return err
+	}
+	clean := cfg.Release == nil || !cfg.Release.AllowDirty
+	entries, err := client.manifest(clean)
+	if err != nil {
+		return err
+	}
+
+	var since nostr.Timestamp
+	previous, previousV := client.latestRelease()
+	if previous != nil {
+		if !previousV.less(v) {
+			return fmt.Errorf("%w: %s is not newer than the latest release %s", ErrConflict, version, tagName(previous))
+		}
+		since = previous.CreatedAt + 1
+	}
+	title := "Release " + version
+	changelog := renderChangelog(title, changelogEntries(client.History("", since), *conventional), *conventional)
+
+	extra := []nostr.Tag{{"t", "release"}}
+	if previous != nil {
+		extra = append(extra, nostr.Tag{"e", previous.ID, "", "previous"})
+	}
+	ev, err := client.publishTag(version, changelog, entries, extra...)
+	if err != nil {
+		return err
+	}
+	fmt.Print(changelog)
+	fmt.Printf("\nReleased %s (%d files)\nEvent ID: %s\n", version, len(entries), ev.ID)
+
+	if *article {
+		slug := "release-" + strings.TrimPrefix(version, "v")
+		if _, err := client.publishArticle(slug, title, changelog); err != nil {
+			return fmt.Errorf("release published but changelog article failed: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// Repo is the local state kept in a working directory's .orbi directory. It
+// is safe for concurrent use.
+type Repo struct {
+	mu   sync.Mutex
+	root string
+}
+
+// openRepo returns the repository rooted at root. The .orbi directory is
+// created lazily on first write.
+func openRepo(root string) *Repo {
+	return &Repo{root: root}
+}
+
+func (r *Repo) dir() string {
+	return filepath.Join(r.root, localOrbiDirName)
+}
+
+// Rel returns path relative to the repository root in slash-separated form,
+// which is how files are identified in tracking state and in "f" tags.
+func (r *Repo) Rel(path string) (string, error) {
+	root, err := filepath.Abs(r.root)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the repository at %s", path, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// slashPath converts a path recorded with Windows separators, as older
+// Windows builds did, to the slash-separated form used in the index and in
+// "f" tags.
+func slashPath(p string) string {
+	return strings.ReplaceAll(p, "\\", "/")
+}
+
+// checkInside returns an error unless dir is the repository root or inside
+// it.
+func (r *Repo) checkInside(dir string) error {
+	root, err := filepath.Abs(r.root)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if abs == root {
+		return nil
+	}
+	_, err = r.Rel(dir)
+	return err
+}
+
+// Abs returns the absolute on-disk path of a repo-relative path.
+func (r *Repo) Abs(rel string) string {
+	root, _ := filepath.Abs(r.root)
+	return filepath.Join(root, filepath.FromSlash(rel))
+}
+
+// TrackedFiles returns the repo-relative paths of all tracked files in
+// sorted order.
+func (r *Repo) TrackedFiles() ([]string, error) {
+	idx, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	return idx.Paths(), nil
+}
+
+// Track adds the repo-relative path rel to the index if it isn't already
+// there.
+func (r *Repo) Track(rel string) error {
+	return r.UpdateIndex(func(idx *orbi.Index) error {
+		if _, ok := idx.Files[rel]; !ok {
+			idx.Files[rel] = &orbi.IndexEntry{Path: rel}
+		}
+		return nil
+	})
+}
+
+// checkRel rejects repo-relative paths taken from events that would escape
+// the repository or write into .orbi.
+func checkRel(rel string) error {
+	clean := path.Clean(rel)
+	if rel == "" || path.IsAbs(rel) || filepath.VolumeName(filepath.FromSlash(rel)) != "" || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") ||
+		clean == localOrbiDirName || strings.HasPrefix(clean, localOrbiDirName+"/") || strings.Contains(rel, "\\") {
+		return fmt.Errorf("unsafe path %q", rel)
+	}
+	return nil
+}
+
+// WriteFile writes data to the repo-relative path rel, creating parent
+// directories as needed.
+func (r *Repo) WriteFile(rel string, data []byte, perm os.FileMode) error {
+	if err := checkRel(rel); err != nil {
+		return err
+	}
+	abs := r.Abs(rel)
+	if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(abs, data, perm)
+}
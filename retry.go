@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 500 * time.Millisecond
+	defaultRetryJitter   = 0.2
+)
+
+// RetryConfig controls how often an operation on a relay is retried after a
+// connection failure or timeout. Relays that explicitly reject an event are
+// not retried.
+type RetryConfig struct {
+	// Attempts is the total number of tries per relay; 1 disables retries.
+	Attempts int `json:"attempts,omitempty"`
+	// Backoff is the wait before the first retry, as a Go duration. It
+	// doubles after every further failure.
+	Backoff string `json:"backoff,omitempty"`
+	// Jitter randomizes each wait by up to this fraction of it, between 0
+	// and 1, so clients don't retry in lockstep.
+	Jitter *float64 `json:"jitter,omitempty"`
+}
+
+func (rc *RetryConfig) validate() error {
+	if rc.Attempts < 0 {
+		return fmt.Errorf("invalid retry attempts %d: must be at least 1", rc.Attempts)
+	}
+	if rc.Backoff != "" {
+		if d, err := time.ParseDuration(rc.Backoff); err != nil || d < 0 {
+			return fmt.Errorf("invalid retry backoff %q: must be a duration such as 500ms", rc.Backoff)
+		}
+	}
+	if rc.Jitter != nil && (*rc.Jitter < 0 || *rc.Jitter > 1) {
+		return fmt.Errorf("invalid retry jitter %v: must be between 0 and 1", *rc.Jitter)
+	}
+	return nil
+}
+
+func (rc *RetryConfig) attempts() int {
+	if rc != nil && rc.Attempts > 0 {
+		return rc.Attempts
+	}
+	return defaultRetryAttempts
+}
+
+// wait returns how long to wait before retry number n, counting from zero.
+func (rc *RetryConfig) wait(n int) time.Duration {
+	base, jitter := defaultRetryBackoff, defaultRetryJitter
+	if rc != nil {
+		if d, err := time.ParseDuration(rc.Backoff); err == nil {
+			base = d
+		}
+		if rc.Jitter != nil {
+			jitter = *rc.Jitter
+		}
+	}
+	d := base << n
+	return d + time.Duration((rand.Float64()*2-1)*jitter*float64(d))
+}
+
+// withRetry calls op until it succeeds, fails with a relay's explicit
+// rejection, or the configured attempts are used up, and returns its last
+// error.
+func (c *Client) withRetry(url string, op func() error) error {
+	attempts := c.Retry.attempts()
+	for n := 0; ; n++ {
+		err := op()
+		if err == nil || rejectionPrefix(err) != "" || n+1 >= attempts {
+			return err
+		}
+		wait := c.Retry.wait(n)
+		slog.Debug("Relay operation failed; retrying", "relay", url, "attempt", n+1, "wait", wait, "err", err)
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// requestDeletion publishes a NIP-09 deletion request for every version of
+// rel the client published, plus known if it is set, and returns how many
+// it covered.
+func (c *Client) requestDeletion(rel, known string) (int, error) {
+	ids := make(map[string]bool)
+	if known != "" {
+		ids[known] = true
+	}
+	kinds := make(map[int]bool)
+	addrs := make(map[string]bool)
+	for _, ev := range c.versions(rel, []string{c.pk}) {
+		ids[ev.ID] = true
+		kinds[ev.Kind] = true
+		if nostr.IsAddressableKind(ev.Kind) {
+			addrs[fmt.Sprintf("%d:%s:%s", ev.Kind, ev.PubKey, ev.Tags.GetD())] = true
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	// The content is public, so it doesn't name paths that may be hidden.
+	deletion := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      nostr.KindDeletion,
+		Content:   "file removed by orbi rm",
+	}
+	for id := range ids {
+		deletion.Tags = append(deletion.Tags, nostr.Tag{"e", id})
+	}
+	// Addressable versions are also deleted by address, which covers
+	// versions the relays replaced.
+	for a := range addrs {
+		deletion.Tags = append(deletion.Tags, nostr.Tag{"a", a})
+	}
+	for k := range kinds {
+		deletion.Tags = append(deletion.Tags, nostr.Tag{"k", strconv.Itoa(k)})
+	}
+	if err := c.sign(&deletion); err != nil {
+		return 0, err
+	}
+	if err := c.publish(&deletion); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// untrack drops rel from the index, leaving the working copy alone, and
+// returns the event it was at.
+func (r *Repo) untrack(rel string) (string, error) {
+	var head string
+	err := r.UpdateIndex(func(idx *orbi.Index) error {
+		entry, ok := idx.Files[rel]
+		if !ok {
+			return fmt.Errorf("%s is not tracked: %w", rel, ErrNotFound)
+		}
+		head = entry.EventID
+		delete(idx.Files, rel)
+		for i, p := range idx.Staged {
+			if p == rel {
+				idx.Staged = append(idx.Staged[:i], idx.Staged[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+	return head, err
+}
+
+func cmdRm(args []string) error {
+	fs := flag.NewFlagSet("rm", flag.ContinueOnError)
+	remoteOnly := fs.Bool("remote-only", false, "ask relays to delete the file's events but keep tracking it")
+	localOnly := fs.Bool("local-only", false, "stop tracking the file without asking relays to delete anything")
+	files, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 || (*remoteOnly && *localOnly) {
+		return fmt.Errorf("usage: orbi rm [--remote-only | --local-only] <file>...")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	idx, err := client.Repo.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		rel, err := client.Repo.Rel(f)
+		if err != nil {
+			return err
+		}
+		entry, tracked := idx.Files[rel]
+		if !tracked && !*remoteOnly {
+			return fmt.Errorf("%s is not tracked: %w", rel, ErrNotFound)
+		}
+		if !*localOnly {
+			var head string
+			if tracked {
+				head = entry.EventID
+			}
+			n, err := client.requestDeletion(rel, head)
+			if err != nil {
+				return fmt.Errorf("%s: %w", rel, err)
+			}
+			fmt.Printf("Requested deletion of %d version(s) of %s\n", n, rel)
+		}
+		if !*remoteOnly {
+			head, err := client.Repo.untrack(rel)
+			if err != nil {
+				return err
+			}
+			if err := client.Repo.LogHead("rm", rel, head, "", ""); err != nil {
+				return err
+			}
+			fmt.Printf("Stopped tracking %s; the file stays on disk\n", rel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Shamir secret sharing over GF(2^8), applied to each byte of the secret
+// independently. Share x coordinates run from 1 to n.
+
+var (
+	gfExp [510]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		// Multiply by the generator 3 modulo the AES polynomial x^8+x^4+x^3+x+1.
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// shamirShare is one point of the sharing polynomials.
+type shamirShare struct {
+	X byte
+	Y []byte
+}
+
+// shamirSplit splits secret into n shares, any threshold of which recover it.
+func shamirSplit(secret []byte, n, threshold int) ([]shamirShare, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("need 2 <= threshold <= shares <= 255, got threshold %d and %d shares", threshold, n)
+	}
+	shares := make([]shamirShare, n)
+	for i := range shares {
+		shares[i] = shamirShare{X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+	coeffs := make([]byte, threshold-1)
+	for b, s := range secret {
+		if _, err := rand.Read(coeffs); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			// Horner's rule, highest coefficient first.
+			y := byte(0)
+			for j := len(coeffs) - 1; j >= 0; j-- {
+				y = gfMul(y, shares[i].X) ^ coeffs[j]
+			}
+			shares[i].Y[b] = gfMul(y, shares[i].X) ^ s
+		}
+	}
+	return shares, nil
+}
+
+// shamirCombine interpolates shares at zero to recover the secret.
+func shamirCombine(shares []shamirShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no shares")
+	}
+	size := len(shares[0].Y)
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.X == 0 || seen[s.X] {
+			return nil, fmt.Errorf("duplicate or invalid share %d", s.X)
+		}
+		if len(s.Y) != size {
+			return nil, fmt.Errorf("shares have different lengths")
+		}
+		seen[s.X] = true
+	}
+	secret := make([]byte, size)
+	for i, si := range shares {
+		// Lagrange basis polynomial for share i evaluated at zero.
+		l := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				l = gfMul(l, gfDiv(sj.X, sj.X^si.X))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(si.Y[b], l)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShamir(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	tests := []struct {
+		name      string
+		n, k      int
+		use       []int // indexes of the shares given to shamirCombine
+		recovered bool
+	}{
+		{"2 of 2", 2, 2, []int{0, 1}, true},
+		{"2 of 3 first", 3, 2, []int{0, 1}, true},
+		{"2 of 3 last", 3, 2, []int{2, 1}, true},
+		{"3 of 5", 5, 3, []int{4, 0, 2}, true},
+		{"all of 5", 5, 3, []int{0, 1, 2, 3, 4}, true},
+		{"too few", 5, 3, []int{1, 3}, false},
+		{"255 shares", 255, 10, []int{254, 0, 100, 7, 8, 9, 200, 31, 64, 128}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shares, err := shamirSplit(secret, tt.n, tt.k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shares) != tt.n {
+				t.Fatalf("got %d shares, want %d", len(shares), tt.n)
+			}
+			var subset []shamirShare
+			for _, i := range tt.use {
+				subset = append(subset, shares[i])
+			}
+			got, err := shamirCombine(subset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(got, secret) != tt.recovered {
+				t.Errorf("combining %v recovered %x, want recovered=%v", tt.use, got, tt.recovered)
+			}
+		})
+	}
+}
+
+func TestShamirErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		n, k int
+	}{
+		{"threshold 1", 3, 1},
+		{"threshold above shares", 2, 3},
+		{"too many shares", 256, 2},
+	} {
+		if _, err := shamirSplit([]byte("secret"), tt.n, tt.k); err == nil {
+			t.Errorf("%s: split succeeded", tt.name)
+		}
+	}
+
+	shares, err := shamirSplit([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		shares []shamirShare
+		err    string
+	}{
+		{"none", nil, "no shares"},
+		{"duplicate", []shamirShare{shares[0], shares[0]}, "duplicate"},
+		{"zero x", []shamirShare{{X: 0, Y: shares[0].Y}, shares[1]}, "invalid share"},
+		{"lengths", []shamirShare{shares[0], {X: 9, Y: []byte("x")}}, "different lengths"},
+	} {
+		if _, err := shamirCombine(tt.shares); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
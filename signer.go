@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip46"
+)
+
+// remoteSignTimeout is how long a remote signer has to answer, which
+// includes the user approving the request in their signer app.
+const remoteSignTimeout = 2 * time.Minute
+
+// bunkerClientKeyFile holds the key orbi uses to talk to remote signers, so a
+// signer that was approved once recognizes it next time.
+const bunkerClientKeyFile = "bunker-client"
+
+// signerURI is the bunker:// URI given with --signer.
+var signerURI string
+
+// Signer signs events with a key that orbi doesn't hold itself.
+type Signer interface {
+	SignEvent(ctx context.Context, ev *nostr.Event) error
+}
+
+// isBunkerURI reports whether a secret key file's contents point at a NIP-46
+// remote signer instead of holding a key.
+func isBunkerURI(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), "bunker://")
+}
+
+// sign signs ev with the client's key, or asks its remote signer to.
+func (c *Client) sign(ev *nostr.Event) error {
+	// Authorization events are never stored, so they need no work.
+	if d := c.powDifficulty(); d > 0 && ev.Kind != eventKindBlossomAuth && ev.Kind != eventKindHTTPAuth && ev.Kind != nostr.KindClientAuthentication {
+		if err := mine(ev, d, c.Quiet || quiet()); err != nil {
+			return err
+		}
+	}
+	if c.signer == nil {
+		return ev.Sign(c.sk)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignTimeout)
+	defer cancel()
+	if err := c.signer.SignEvent(ctx, ev); err != nil {
+		return fmt.Errorf("remote signer: %w", err)
+	}
+	if ev.PubKey != c.pk {
+		return fmt.Errorf("remote signer signed as %s instead of %s", ev.PubKey, c.pk)
+	}
+	return nil
+}
+
+// bunkerURI returns the remote signer to use: --signer, or the secret key
+// file when it holds a bunker:// URI. It is empty when keys are local.
+func bunkerURI() string {
+	if signerURI != "" {
+		return signerURI
+	}
+	if envSecretKey() != "" {
+		return ""
+	}
+	content, err := ioutil.ReadFile(secretKeyPath())
+	if err == nil && isBunkerURI(string(content)) {
+		return strings.TrimSpace(string(content))
+	}
+	return ""
+}
+
+// bunkerClientKey returns the persistent key for NIP-46 sessions, creating it
+// next to the user's secret key on first use.
+func bunkerClientKey() (string, error) {
+	path := filepath.Join(nostrSecretDir(), bunkerClientKeyFile)
+	if sk, _, err := readSecretKey(path); err == nil {
+		return sk, nil
+	} else if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+		return "", err
+	}
+	sk := nostr.GeneratePrivateKey()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	return sk, ioutil.WriteFile(path, []byte(sk+"\n"), 0600)
+}
+
+// connectSigner opens a NIP-46 session with the signer at uri and returns it
+// with the public key it signs for.
+func connectSigner(uri string) (Signer, string, error) {
+	if !strings.HasPrefix(uri, "bunker://") {
+		return nil, "", fmt.Errorf("%w: signer %q must be a bunker:// URI", ErrNoKey, uri)
+	}
+	clientKey, err := bunkerClientKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrNoKey, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remoteSignTimeout)
+	defer cancel()
+	bunker, err := nip46.ConnectBunker(ctx, clientKey, uri, nil, func(url string) {
+		slog.Warn("The remote signer asks you to approve orbi", "url", url)
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: connecting to remote signer: %v", ErrNoKey, err)
+	}
+	pk, err := bunker.GetPublicKey(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: remote signer: %v", ErrNoKey, err)
+	}
+	return bunker, pk, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// defaultMaxEventSize is used when neither the client nor any relay sets a
+// tighter limit. Most public relays reject events well above this.
+const defaultMaxEventSize = 128 * 1024
+
+// eventSize returns the number of bytes ev will occupy on the wire once
+// signed and wrapped in an EVENT envelope. The envelope is encoded the way
+// it is sent, without escaping <, > and & as encoding/json would.
+func eventSize(ev *nostr.Event) int {
+	signed := *ev
+	signed.ID = fmt.Sprintf("%064x", 0)
+	signed.Sig = fmt.Sprintf("%0128x", 0)
+	b, _ := nostr.EventEnvelope{Event: signed}.MarshalJSON()
+	return len(b)
+}
+
+// sizeLimit returns the smallest limit that applies to the client's relays
+// and where it comes from.
+func (c *Client) sizeLimit() (int, string) {
+	limit, source := defaultMaxEventSize, "orbi's default"
+	if c.MaxEventSize > 0 {
+		limit, source = c.MaxEventSize, "the configured maximum"
+	}
+	fetcher, ok := c.Transport.(orbi.InfoFetcher)
+	if !ok {
+		return limit, source
+	}
+	for _, r := range c.Relays() {
+		if l := c.relayLimit(fetcher, r); l > 0 && l < limit {
+			limit, source = l, r
+		}
+	}
+	return limit, source
+}
+
+// relayLimit returns the maximum message length relay r advertises in its
+// NIP-11 document, or zero. Each relay is asked once per client.
+func (c *Client) relayLimit(fetcher orbi.InfoFetcher, r string) int {
+	c.mu.RLock()
+	l, ok := c.limitCache[r]
+	c.mu.RUnlock()
+	if ok {
+		return l
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	info, err := fetcher.RelayInfo(ctx, r)
+	cancel()
+	if err == nil && info.Limitation != nil {
+		l = info.Limitation.MaxMessageLength
+	}
+	c.mu.Lock()
+	if c.limitCache == nil {
+		c.limitCache = make(map[string]int)
+	}
+	c.limitCache[r] = l
+	c.mu.Unlock()
+	return l
+}
+
+// checkSize refuses events that relays would reject for being too large.
+func (c *Client) checkSize(ev *nostr.Event, path string) error {
+	size := eventSize(ev)
+	limit, source := c.sizeLimit()
+	if size > limit {
+		hint := "split the file into smaller pieces or set storage in .orbi/config to keep it on a Blossom or NIP-96 server"
+		if ev.Tags.Find("encrypted") != nil {
+			hint = "private files are always published inline, so split the file into smaller pieces or compress it with --compress"
+		}
+		return fmt.Errorf("%w: event for %s is %d bytes but %s allows at most %d; %s",
+			ErrTooLarge, path, size, source, limit, hint)
+	}
+	return nil
+}
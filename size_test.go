@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+func TestEventSize(t *testing.T) {
+	c, _ := newTestClient(t)
+	for _, content := range []string{
+		"",
+		"plain text\n",
+		"<html> & <body>\n",
+		"quotes \" and \\ backslashes\n",
+		"tabs\tand\nnewlines\r\n",
+		"unicode é ✓\n",
+		strings.Repeat("<&>", 1000),
+	} {
+		ev := testEvent(t, c, "a.txt", content, 1700000000)
+		b, err := nostr.EventEnvelope{Event: *ev}.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		unsigned := *ev
+		unsigned.ID, unsigned.Sig = "", ""
+		if got := eventSize(&unsigned); got != len(b) {
+			t.Errorf("size of %q is %d, but it is sent as %d bytes", content, got, len(b))
+		}
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	c, _ := newTestClient(t)
+	c.MaxEventSize = 1000
+	small := testEvent(t, c, "a.txt", "small\n", 1700000000)
+	if err := c.checkSize(small, "a.txt"); err != nil {
+		t.Errorf("small event: %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		opts []orbi.EventOption
+		hint string
+	}{
+		{"public", nil, "set storage"},
+		{"private", []orbi.EventOption{orbi.WithEncryption(c.sk, c.pk)}, "private files are always published inline"},
+	} {
+		ev := testEvent(t, c, "a.txt", strings.Repeat("large\n", 500), 1700000000, tt.opts...)
+		err := c.checkSize(ev, "a.txt")
+		if !errors.Is(err, ErrTooLarge) || !strings.Contains(err.Error(), tt.hint) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.hint)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// changedSince reports whether the working copy of rel differs from the
+// version entry records, or was never committed.
+func (r *Repo) changedSince(entry *orbi.IndexEntry, rel string) (bool, error) {
+	if entry == nil || entry.EventID == "" {
+		return true, nil
+	}
+	content, err := ioutil.ReadFile(r.Abs(rel))
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]) != entry.Hash, nil
+}
+
+// Stage tracks paths and marks those that changed for the next commit. It
+// returns the paths staged.
+func (r *Repo) Stage(paths []string) ([]string, error) {
+	var staged []string
+	err := r.UpdateIndex(func(idx *orbi.Index) error {
+		for _, rel := range paths {
+			entry, ok := idx.Files[rel]
+			if !ok {
+				entry = &orbi.IndexEntry{Path: rel}
+				idx.Files[rel] = entry
+			}
+			changed, err := r.changedSince(entry, rel)
+			if err != nil {
+				return err
+			}
+			if changed && !contains(idx.Staged, rel) {
+				idx.Staged = append(idx.Staged, rel)
+				staged = append(staged, rel)
+			}
+		}
+		sort.Strings(idx.Staged)
+		return nil
+	})
+	return staged, err
+}
+
+// unstage removes rel from the staged paths.
+func (r *Repo) unstage(rel string) error {
+	return r.UpdateIndex(func(idx *orbi.Index) error {
+		for i, p := range idx.Staged {
+			if p == rel {
+				idx.Staged = append(idx.Staged[:i], idx.Staged[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// commitStaged signs a version of every staged file and queues it in the
+// outbox for orbi push, moving each file's head to its queued version.
+func (c *Client) commitStaged(message string) ([]*nostr.Event, error) {
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return nil, err
+	}
+	var queued []*nostr.Event
+	for _, rel := range idx.Staged {
+		fc, err := c.commitFile(cfg, c.Repo.Abs(rel), message)
+		if err != nil {
+			return queued, fmt.Errorf("%s: %w", rel, err)
+		}
+		if err := c.Repo.AddToOutbox(fc.ev); err != nil {
+			return queued, err
+		}
+		c.recordCommit(fc, message)
+		if err := c.Repo.unstage(rel); err != nil {
+			return queued, err
+		}
+		queued = append(queued, fc.ev)
+	}
+	return queued, nil
+}
+
+// push publishes the outbox, oldest first, and returns the events sent.
+// Each event leaves the outbox once a relay has accepted it.
+func (c *Client) push(cfg *Config) ([]*nostr.Event, error) {
+	events, err := c.Repo.Outbox()
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	var claim *headClaim
+	if cfg.MergeQueue != nil {
+		if claim, err = c.claimHead(cfg); err != nil {
+			return nil, err
+		}
+	}
+	var pushed []*nostr.Event
+	for i, ev := range events {
+		fileDone(c.Observer, c.filePath(ev), i, len(events))
+		if err := c.publish(ev); err != nil {
+			return pushed, fmt.Errorf("%s: %w", ev.ID, err)
+		}
+		if err := c.Repo.RemoveFromOutbox(ev.ID); err != nil {
+			return pushed, err
+		}
+		pushed = append(pushed, ev)
+	}
+	fileDone(c.Observer, "", len(events), len(events))
+	if claim != nil {
+		if err := c.confirmHead(cfg, claim, pushed[len(pushed)-1].ID); err != nil {
+			slog.Warn(err.Error())
+		}
+	}
+	return pushed, nil
+}
+
+// flush publishes the deferred events, oldest first, and returns the events
+// sent. It stops at the first event that still finds no relay.
+func (c *Client) flush() ([]*nostr.Event, error) {
+	events, err := c.Repo.Deferred()
+	if err != nil {
+		return nil, err
+	}
+	var flushed []*nostr.Event
+	for _, ev := range events {
+		if err := c.publish(ev); err != nil {
+			return flushed, fmt.Errorf("%s: %w", ev.ID, err)
+		}
+		if err := c.Repo.RemoveFromOutbox(ev.ID); err != nil {
+			return flushed, err
+		}
+		flushed = append(flushed, ev)
+	}
+	return flushed, nil
+}
+
+// skipAutoFlush keeps newCLIClient from flushing, for commands that flush
+// themselves or must not touch the network.
+var skipAutoFlush bool
+
+// autoFlush tries once to publish events deferred by an earlier command, so
+// they go out as soon as a relay is reachable again. Failures leave them
+// queued.
+func (c *Client) autoFlush() {
+	if skipAutoFlush {
+		return
+	}
+	if events, err := c.Repo.Deferred(); err != nil || len(events) == 0 {
+		return
+	}
+	retry := c.Retry
+	c.Retry = &RetryConfig{Attempts: 1}
+	defer func() { c.Retry = retry }()
+	flushed, err := c.flush()
+	if len(flushed) > 0 {
+		slog.Info("Published queued events", "count", len(flushed))
+	}
+	if errors.Is(err, ErrOffline) {
+		slog.Debug("Relays are still unreachable; keeping events queued")
+	} else if err != nil {
+		slog.Warn("Failed to publish queued events", "err", err)
+	}
+}
+
+func cmdFlush(args []string) error {
+	fs := flag.NewFlagSet("flush", flag.ContinueOnError)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("usage: orbi flush")
+	}
+	skipAutoFlush = true
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	flushed, err := client.flush()
+	for _, ev := range flushed {
+		fmt.Printf("  %s %s\n", short(ev.ID), client.filePath(ev))
+	}
+	if err != nil {
+		return err
+	}
+	if len(flushed) == 0 {
+		fmt.Println("No queued events")
+		return nil
+	}
+	fmt.Printf("Published %d queued event(s)\n", len(flushed))
+	return nil
+}
+
+func cmdCommit(args []string) error {
+	fs := flag.NewFlagSet("commit", flag.ContinueOnError)
+	getMessage := messageFlags(fs)
+	force := fs.Bool("force", false, "commit even if the relays have a newer version of a file")
+	pow := fs.Int("pow", 0, "mine each event to this NIP-13 proof-of-work difficulty")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("usage: orbi commit -m <message> [--force] [--pow <n>]")
+	}
+	if err := validPoW(*pow); err != nil {
+		return err
+	}
+	message, err := getMessage()
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		return fmt.Errorf("a commit message is required; pass it with -m or --file")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	client.Force = *force
+	if *pow > 0 {
+		client.PoW = *pow
+	}
+	idx, err := client.Repo.Index()
+	if err != nil {
+		return err
+	}
+	if len(idx.Staged) == 0 {
+		return fmt.Errorf("nothing staged; use orbi add first")
+	}
+	queued, err := client.commitStaged(message)
+	for _, ev := range queued {
+		fmt.Printf("  %s %s\n", short(ev.ID), eventPath(ev))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Committed %d file(s); run orbi push to publish\n", len(queued))
+	return nil
+}
+
+// stageModified stages every tracked file whose content changed since it was
+// last committed, leaving out deleted and ignored files.
+func (r *Repo) stageModified() ([]string, error) {
+	idx, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := r.ignorePatterns()
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, rel := range idx.Paths() {
+		if _, err := os.Stat(r.Abs(rel)); err == nil && !ignored(patterns, rel) {
+			paths = append(paths, rel)
+		}
+	}
+	return r.Stage(paths)
+}
+
+func cmdPush(args []string) error {
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	all := fs.Bool("all", false, "commit every modified tracked file first")
+	minRelays := fs.Int("min-relays", 0, "fail unless at least this many relays accept each event")
+	pow := fs.Int("pow", 0, "mine events committed by --all to this NIP-13 proof-of-work difficulty")
+	dryRun := fs.Bool("dry-run", false, "show the events that would be pushed without sending or committing anything")
+	getMessage := messageFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return fmt.Errorf("usage: orbi push [--all -m <message>] [--min-relays <n>] [--pow <n>] [--dry-run]")
+	}
+	if err := validPoW(*pow); err != nil {
+		return err
+	}
+	message, err := getMessage()
+	if err != nil {
+		return err
+	}
+	if *all && message == "" {
+		return fmt.Errorf("--all needs a commit message; pass it with -m or --file")
+	} else if !*all && message != "" {
+		return fmt.Errorf("a message is only used with --all; commit staged files with orbi commit")
+	}
+	if *dryRun {
+		skipAutoFlush = true
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	if *minRelays > 0 {
+		client.MinRelays = *minRelays
+	}
+	if *pow > 0 {
+		client.PoW = *pow
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		client.DryRun = true
+		n, err := client.dryRunPush(cfg, *all)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Dry run: %d event(s) would be pushed; nothing was sent\n", n)
+		return nil
+	}
+	if *all {
+		staged, err := client.Repo.stageModified()
+		if err != nil {
+			return err
+		}
+		if len(staged) > 0 {
+			if _, err := client.commitStaged(message); err != nil {
+				return err
+			}
+		}
+	}
+	pushed, err := client.push(cfg)
+	for _, ev := range pushed {
+		fmt.Printf("  %s %s\n", short(ev.ID), client.filePath(ev))
+	}
+	if err != nil {
+		return err
+	}
+	if len(pushed) == 0 {
+		fmt.Println("Nothing to push")
+		return nil
+	}
+	fmt.Printf("Pushed %d commit(s)\n", len(pushed))
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+func TestCommitStagedKeepsPrivateFilesEncrypted(t *testing.T) {
+	for _, hidden := range []bool{false, true} {
+		c, _ := newTestClient(t)
+		other := nostr.GeneratePrivateKey()
+		otherPK, _ := nostr.GetPublicKey(other)
+		path := c.Repo.Abs("secret.txt")
+		if err := ioutil.WriteFile(path, []byte("one\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := []orbi.EventOption{orbi.WithEncryption(c.sk, c.pk, otherPK)}
+		if hidden {
+			opts = append(opts, orbi.WithHiddenPath())
+		}
+		if _, err := c.PublishFile(path, "one", opts...); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte("two\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Repo.Stage([]string{"secret.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		queued, err := c.commitStaged("two")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(queued) != 1 {
+			t.Fatalf("committed %d versions, want 1", len(queued))
+		}
+		ev := queued[0]
+		if ev.Tags.Find("encrypted") == nil {
+			t.Fatalf("hidden=%v: staged commit of a private file is not encrypted: %v", hidden, ev.Tags)
+		}
+		if strings.Contains(ev.String(), "two\\n") {
+			t.Errorf("hidden=%v: staged commit contains the plaintext", hidden)
+		}
+		content, err := orbi.DecodeContent(ev, other)
+		if err != nil || string(content) != "two\n" {
+			t.Errorf("hidden=%v: recipient decodes %q, %v", hidden, content, err)
+		}
+		want := "secret.txt"
+		if hidden {
+			if want, err = orbi.HiddenPathTag(c.sk, "secret.txt"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if f := ev.Tags.Find("f"); f == nil || f[1] != want {
+			t.Errorf("hidden=%v: f tag is %v, want %s", hidden, f, want)
+		}
+		if hidden && (ev.Tags.Find("m") != nil || c.fileMessage(ev) != "two") {
+			t.Errorf("message is not sealed: %v", ev.Tags)
+		}
+	}
+}
+
+func TestPushAllKeepsPrivateFilesEncrypted(t *testing.T) {
+	c, _ := newTestClient(t)
+	files := map[string][]orbi.EventOption{
+		"public.txt":  nil,
+		"private.txt": {orbi.WithEncryption(c.sk, c.pk)},
+		"hidden.txt":  {orbi.WithEncryption(c.sk, c.pk), orbi.WithHiddenPath()},
+	}
+	for rel, opts := range files {
+		if err := ioutil.WriteFile(c.Repo.Abs(rel), []byte("one\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.PublishFile(c.Repo.Abs(rel), "one", opts...); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(c.Repo.Abs(rel), []byte("two\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// What orbi push --all does.
+	if staged, err := c.Repo.stageModified(); err != nil || len(staged) != len(files) {
+		t.Fatalf("staged %v, %v", staged, err)
+	}
+	if _, err := c.commitStaged("two"); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := c.push(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pushed) != len(files) {
+		t.Fatalf("pushed %d versions, want %d", len(pushed), len(files))
+	}
+	for _, ev := range pushed {
+		rel := c.filePath(ev)
+		if encrypted := ev.Tags.Find("encrypted") != nil; encrypted != (files[rel] != nil) {
+			t.Errorf("%s: pushed version encrypted=%v", rel, encrypted)
+		}
+		if hidden := eventPath(ev) != rel; hidden != (rel == "hidden.txt") {
+			t.Errorf("%s: pushed under %q", rel, eventPath(ev))
+		}
+	}
+}
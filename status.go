@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// Working copy states reported by orbi status.
+const (
+	statusInSync      = "in sync"
+	statusModified    = "modified locally"
+	statusDeleted     = "deleted locally"
+	statusUnpublished = "never published"
+	statusBehind      = "behind remote"
+	statusUnpushed    = "committed, not pushed"
+	statusConflict    = "conflicted"
+	// statusEOL is added to files whose CRLF line endings publishing would
+	// rewrite to LF under the eol policy.
+	statusEOL = "line endings will be normalized"
+)
+
+// fileStatus describes one tracked file.
+type fileStatus struct {
+	Path   string
+	Local  string
+	Behind bool
+	// EOL is set when publishing would normalize the file's line endings.
+	EOL bool
+}
+
+// Untracked returns the files under the repository root that aren't tracked,
+// skipping hidden directories and ignored files.
+func (r *Repo) Untracked() ([]string, error) {
+	idx, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+	patterns, err := r.ignorePatterns()
+	if err != nil {
+		return nil, err
+	}
+	var untracked []string
+	err = r.walkFiles(r.root, func(rel string) error {
+		if _, ok := idx.Files[rel]; !ok && !ignored(patterns, rel) {
+			untracked = append(untracked, rel)
+		}
+		return nil
+	})
+	return untracked, err
+}
+
+// localStatus compares the working copy of a tracked file with what was last
+// committed from it. queued holds the ids of commits not yet pushed.
+func (c *Client) localStatus(entry *orbi.IndexEntry, queued map[string]bool) (string, error) {
+	if _, err := os.Stat(c.Repo.Abs(entry.Path)); os.IsNotExist(err) {
+		return statusDeleted, nil
+	}
+	if entry.EventID == "" {
+		return statusUnpublished, nil
+	}
+	if entry.Conflict {
+		return statusConflict, nil
+	}
+	modified, err := c.modifiedLocally(entry)
+	if err != nil {
+		return "", err
+	}
+	if modified {
+		return statusModified, nil
+	}
+	if queued[entry.EventID] {
+		return statusUnpushed, nil
+	}
+	return statusInSync, nil
+}
+
+// status reports every tracked file. Unless offline, each is also compared
+// with the newest remote version, fetched in a single query.
+func (c *Client) status(cfg *Config, offline bool) ([]fileStatus, error) {
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return nil, err
+	}
+	paths := idx.Paths()
+	queued, err := c.Repo.outboxIDs()
+	if err != nil {
+		return nil, err
+	}
+	// Versions are looked up by their "f" tag, which is not the path for
+	// files with hidden paths.
+	tagOf := func(rel string) string {
+		if t := idx.Files[rel].PathTag; t != "" {
+			return t
+		}
+		return rel
+	}
+	var latest map[string]*nostr.Event
+	known := make(map[string]*nostr.Event)
+	if !offline && len(paths) > 0 {
+		authors := c.pullAuthors(cfg)
+		tags := make([]string, len(paths))
+		for i, rel := range paths {
+			tags[i] = tagOf(rel)
+		}
+		var valid []*nostr.Event
+		for _, ev := range c.query(nostr.Filter{Kinds: c.fileKinds(authors), Authors: c.withSubkeys(authors), Tags: nostr.TagMap{"f": tags}}) {
+			if ok, _ := ev.CheckSignature(); ok && c.signsFor(ev.PubKey, authors) && c.onBranch(ev) {
+				valid = append(valid, ev)
+				known[ev.ID] = ev
+			}
+		}
+		latest = latestByPath(valid)
+	}
+
+	var result []fileStatus
+	for _, rel := range paths {
+		entry := idx.Files[rel]
+		local, err := c.localStatus(entry, queued)
+		if err != nil {
+			return nil, err
+		}
+		s := fileStatus{Path: rel, Local: local}
+		if content, err := ioutil.ReadFile(c.Repo.Abs(rel)); err == nil {
+			s.EOL = wouldNormalize(content, cfg.EOL)
+		}
+		if head, ok := latest[tagOf(rel)]; ok && head.ID != entry.EventID && !queued[entry.EventID] {
+			mine, ok := known[entry.EventID]
+			s.Behind = !ok || before(mine, head)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func cmdStatus(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	offline := flags.Bool("offline", false, "don't compare with the relays")
+	jsonOut := flags.Bool("json", false, "print the status as JSON")
+	if _, err := parseArgs(flags, args); err != nil {
+		return err
+	}
+	skipAutoFlush = *offline
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	files, err := client.status(cfg, *offline)
+	if err != nil {
+		return err
+	}
+	idx, err := client.Repo.Index()
+	if err != nil {
+		return err
+	}
+	untracked, err := client.Repo.Untracked()
+	if err != nil {
+		return err
+	}
+	if *jsonOut {
+		type fileJSON struct {
+			Path    string `json:"path"`
+			State   string `json:"state"`
+			Behind  bool   `json:"behind"`
+			EOL     bool   `json:"eol_normalized,omitempty"`
+			EventID string `json:"event_id,omitempty"`
+			Hash    string `json:"sha256,omitempty"`
+		}
+		out := struct {
+			Branch    string     `json:"branch"`
+			Files     []fileJSON `json:"files"`
+			Staged    []string   `json:"staged"`
+			Untracked []string   `json:"untracked"`
+		}{Branch: client.branch, Files: []fileJSON{}, Staged: idx.Staged, Untracked: untracked}
+		for _, s := range files {
+			e := idx.Files[s.Path]
+			out.Files = append(out.Files, fileJSON{s.Path, s.Local, s.Behind, s.EOL, e.EventID, e.Hash})
+		}
+		if out.Staged == nil {
+			out.Staged = []string{}
+		}
+		if out.Untracked == nil {
+			out.Untracked = []string{}
+		}
+		return printJSON(out)
+	}
+	fmt.Printf("On branch %s\n\n", client.branch)
+	if len(idx.Staged) > 0 {
+		fmt.Println("Staged for commit:")
+		for _, rel := range idx.Staged {
+			fmt.Printf("  %s\n", rel)
+		}
+		fmt.Println()
+	}
+	for _, s := range files {
+		state := s.Local
+		if s.Behind {
+			if s.Local == statusInSync {
+				state = statusBehind
+			} else {
+				state += ", " + statusBehind
+			}
+		}
+		if s.EOL {
+			state += ", " + statusEOL
+		}
+		fmt.Printf("  %-32s %s\n", state, s.Path)
+	}
+
+	if len(untracked) > 0 {
+		fmt.Println("\nUntracked files:")
+		for _, rel := range untracked {
+			fmt.Printf("  %s\n", rel)
+		}
+	}
+	return nil
+}
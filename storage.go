@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// defaultBlobThreshold is the file size above which files are stored
+// off-relay when a storage backend is configured.
+const defaultBlobThreshold = 64 * 1024
+
+// storage returns the configured backend for large files.
+func (cfg *Config) storage() string {
+	switch {
+	case cfg.Storage != "":
+		return cfg.Storage
+	case len(cfg.BlossomServers) > 0:
+		return orbi.StorageBlossom
+	}
+	return orbi.StorageInline
+}
+
+// blobThreshold returns the size above which files are stored off-relay.
+func (cfg *Config) blobThreshold() int {
+	if cfg.BlobThreshold > 0 {
+		return cfg.BlobThreshold
+	}
+	return defaultBlobThreshold
+}
+
+// storeBlob uploads the content of a file event that points to a blob. NIP-96
+// servers choose the URL, so for them the event is rebuilt with build and
+// signed again.
+func (c *Client) storeBlob(cfg *Config, rel string, content []byte, ev nostr.Event, build func(...orbi.EventOption) (nostr.Event, error)) (nostr.Event, error) {
+	switch storage := ev.Tags.Find("storage")[1]; storage {
+	case orbi.StorageBlossom:
+		return ev, c.uploadFileBlob(cfg.BlossomServers, content, rel)
+	case orbi.StorageNIP96:
+		url, err := c.nip96Upload(cfg.NIP96Server, content, rel)
+		if err != nil {
+			return ev, err
+		}
+		if ev, err = build(orbi.WithBlobURL(url)); err != nil {
+			return ev, err
+		}
+		return ev, c.sign(&ev)
+	default:
+		return ev, fmt.Errorf("unknown storage %q", storage)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// eventKindSubkey is published by an identity to authorize another key to
+// sign on its behalf. Deleting the authorization (NIP-09) revokes it.
+const eventKindSubkey = 4450
+
+// loadSigningKey returns the key that signs for this repository: the
+// configured subkey along with the identity that authorized it, or the main
+// key and an empty identity.
+func loadSigningKey(cfg *Config) (sk, pk, identity string, err error) {
+	if cfg.SigningKey == "" {
+		sk, pk, err = loadNostrSecretKey()
+		return sk, pk, "", err
+	}
+	sk, pk, err = readSecretKey(expandPath(cfg.SigningKey))
+	if err != nil {
+		return "", "", "", err
+	}
+	identity, err = parsePubkey(cfg.Identity)
+	return sk, pk, identity, err
+}
+
+// Identity returns the identity the client acts for: the authorizing key when
+// signing with a subkey, otherwise the signing key itself.
+func (c *Client) Identity() string {
+	if c.identity != "" {
+		return c.identity
+	}
+	return c.pk
+}
+
+// authorizeSubkey publishes an authorization of subkey for the named
+// repository, signed by the client's key.
+func (c *Client) authorizeSubkey(subkey, repo string) (*nostr.Event, error) {
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      eventKindSubkey,
+		Tags:      nostr.Tags{{"ver", eventFormatVersion}, {"p", subkey}, {"repo", repo}},
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// authorizersOf returns the identities that have authorized signer as a
+// subkey for this repository and not revoked it. Authorizations are per
+// repository, so a subkey for one repository can't sign for another.
+func (c *Client) authorizersOf(signer string) []string {
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		return nil
+	}
+	repo := c.repoName(cfg)
+	key := signer + ":" + repo
+	c.mu.RLock()
+	ids, ok := c.subkeyCache[key]
+	c.mu.RUnlock()
+	if ok {
+		return ids
+	}
+
+	auths := c.query(nostr.Filter{Kinds: []int{eventKindSubkey}, Tags: nostr.TagMap{"p": []string{signer}, "repo": []string{repo}}})
+	var valid []*nostr.Event
+	var authIDs []string
+	for _, a := range auths {
+		// Relays may ignore the repo filter, so check it again.
+		if a.Tags.FindWithValue("repo", repo) == nil {
+			continue
+		}
+		if ok, _ := a.CheckSignature(); ok && a.PubKey != signer {
+			valid = append(valid, a)
+			authIDs = append(authIDs, a.ID)
+		}
+	}
+	revoked := make(map[string]bool)
+	if len(authIDs) > 0 {
+		for _, d := range c.query(nostr.Filter{Kinds: []int{nostr.KindDeletion}, Tags: nostr.TagMap{"e": authIDs}}) {
+			if ok, _ := d.CheckSignature(); !ok {
+				continue
+			}
+			for tag := range d.Tags.FindAll("e") {
+				revoked[d.PubKey+":"+tag[1]] = true
+			}
+		}
+	}
+	ids = nil
+	for _, a := range valid {
+		if !revoked[a.PubKey+":"+a.ID] && !contains(ids, a.PubKey) {
+			ids = append(ids, a.PubKey)
+		}
+	}
+
+	c.mu.Lock()
+	if c.subkeyCache == nil {
+		c.subkeyCache = make(map[string][]string)
+	}
+	c.subkeyCache[key] = ids
+	c.mu.Unlock()
+	return ids
+}
+
+// withSubkeys returns identities followed by the subkeys they have
+// authorized for this repository, for relay filters that can only name
+// authors. Revocations aren't checked here; events by the subkeys must
+// still pass signsFor.
+func (c *Client) withSubkeys(identities []string) []string {
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		return identities
+	}
+	repo := c.repoName(cfg)
+	keys := append([]string(nil), identities...)
+	for _, a := range c.query(nostr.Filter{Kinds: []int{eventKindSubkey}, Authors: identities, Tags: nostr.TagMap{"repo": []string{repo}}}) {
+		if a.Tags.FindWithValue("repo", repo) == nil || !contains(identities, a.PubKey) {
+			continue
+		}
+		if p := a.Tags.Find("p"); p != nil && nostr.IsValidPublicKey(p[1]) && !contains(keys, p[1]) {
+			keys = append(keys, p[1])
+		}
+	}
+	return keys
+}
+
+// signsFor reports whether signer is one of identities or a subkey
+// authorized by one of them.
+func (c *Client) signsFor(signer string, identities []string) bool {
+	if contains(identities, signer) {
+		return true
+	}
+	for _, id := range c.authorizersOf(signer) {
+		if contains(identities, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// identityOf returns the identity that signer acts for: the first identity
+// that authorized it as a subkey, or signer itself.
+func (c *Client) identityOf(signer string) string {
+	if ids := c.authorizersOf(signer); len(ids) > 0 {
+		return ids[0]
+	}
+	return signer
+}
+
+func cmdKeySubkey(args []string) error {
+	fs := flag.NewFlagSet("key subkey", flag.ContinueOnError)
+	out := fs.String("o", "", "file to store the subkey in (default ~/.nostr/subkeys/<repo>)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return fmt.Errorf("usage: orbi key subkey [-o <file>]")
+	}
+
+	repo := openRepo(".")
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	sk, pk, err := loadNostrSecretKey()
+	if err != nil {
+		return err
+	}
+	client, err := newConfiguredClient(repo, cfg, sk, pk)
+	if err != nil {
+		return err
+	}
+	name := client.repoName(cfg)
+	path := *out
+	if path == "" {
+		path = filepath.Join(nostrSecretDir(), "subkeys", name)
+	}
+	path = expandPath(path)
+
+	subSK := nostr.GeneratePrivateKey()
+	subPK, err := nostr.GetPublicKey(subSK)
+	if err != nil {
+		return err
+	}
+	nsec, _ := nip19.EncodePrivateKey(subSK)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, []byte(nsec+"\n"), 0600); err != nil {
+		return err
+	}
+	ev, err := client.authorizeSubkey(subPK, name)
+	if err != nil {
+		return fmt.Errorf("subkey saved to %s but authorization failed: %w", path, err)
+	}
+	cfg.SigningKey = path
+	cfg.Identity, _ = nip19.EncodePublicKey(pk)
+	if err := repo.SaveConfig(cfg); err != nil {
+		return err
+	}
+	npub, _ := nip19.EncodePublicKey(subPK)
+	fmt.Printf("Created signing subkey %s in %s\nAuthorization event ID: %s\n", npub, path, ev.ID)
+	fmt.Println("Commits in this repository are now signed with the subkey; the main key can be kept offline.")
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// subkeyTestClients returns a client whose repository was cloned from owner,
+// owner itself, a subkey owner authorized for the repository, a subkey
+// owner authorized and then revoked, and a stranger, all on the same relay.
+func subkeyTestClients(t *testing.T) (c, owner, sub, revoked, stranger *Client) {
+	t.Helper()
+	c, transport := newTestClient(t)
+	owner, _ = newTestClient(t)
+	sub, _ = newTestClient(t)
+	revoked, _ = newTestClient(t)
+	stranger, _ = newTestClient(t)
+	for _, o := range []*Client{owner, sub, revoked, stranger} {
+		o.Transport = transport
+	}
+
+	ownerCfg, err := owner.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerCfg.Name = "project"
+	if err := owner.Repo.SaveConfig(ownerCfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owner.announce(); err != nil {
+		t.Fatal(err)
+	}
+	// The clone's directory has another name, so the subkey authorizations
+	// only match if it goes by the origin's name.
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Origin, _ = nip19.EncodePublicKey(owner.pk)
+	if err := c.Repo.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := owner.authorizeSubkey(sub.pk, "project"); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := owner.authorizeSubkey(revoked.pk, "project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletion := nostr.Event{PubKey: owner.pk, CreatedAt: nostr.Now(), Kind: nostr.KindDeletion, Tags: nostr.Tags{{"e", auth.ID}}}
+	if err := owner.sign(&deletion); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.publish(&deletion); err != nil {
+		t.Fatal(err)
+	}
+	return c, owner, sub, revoked, stranger
+}
+
+func TestRepoNameInClone(t *testing.T) {
+	c, _, _, _, _ := subkeyTestClients(t)
+	cfg, err := c.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := c.repoName(cfg); name != "project" {
+		t.Errorf("clone is named %q, want the origin's name", name)
+	}
+}
+
+func TestVersionsBySubkeys(t *testing.T) {
+	c, owner, sub, revoked, stranger := subkeyTestClients(t)
+	want := make(map[string]bool)
+	for i, signer := range []*Client{owner, sub, revoked, stranger} {
+		ev := testEvent(t, signer, "a.txt", "version\n", 1700000000+int64(i))
+		if err := c.Transport.Publish(context.Background(), testRelayURL, *ev); err != nil {
+			t.Fatal(err)
+		}
+		want[ev.ID] = signer == owner || signer == sub
+	}
+	authors := []string{owner.pk}
+
+	got := make(map[string]bool)
+	for _, ev := range c.versions("a.txt", authors) {
+		got[ev.ID] = true
+	}
+	for id, ok := range want {
+		if got[id] != ok {
+			t.Errorf("versions: event %s included=%v, want %v", short(id), got[id], ok)
+		}
+		if _, _, err := c.resolveVersion(id, authors); (err == nil) != ok {
+			t.Errorf("resolveVersion %s: got error %v, want ok=%v", short(id), err, ok)
+		}
+	}
+	if _, ev, err := c.resolveVersion(c.Repo.Abs("a.txt"), authors); err != nil || ev.PubKey != sub.pk {
+		t.Errorf("newest version is %v, %v; want the subkey's", ev, err)
+	}
+}
+
+func TestCloneBySubkeys(t *testing.T) {
+	c, owner, sub, _, stranger := subkeyTestClients(t)
+	for i, f := range []struct {
+		signer *Client
+		rel    string
+	}{{owner, "owner.txt"}, {sub, "sub.txt"}, {stranger, "stranger.txt"}} {
+		ev := testEvent(t, f.signer, f.rel, f.rel+"\n", 1700000000+int64(i))
+		if err := c.Transport.Publish(context.Background(), testRelayURL, *ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	written, skipped, err := c.clone(owner.pk, nil, nil, false)
+	if err != nil || written != 2 || skipped != 0 {
+		t.Fatalf("cloned %d, skipped %d: %v", written, skipped, err)
+	}
+	for rel, want := range map[string]bool{"owner.txt": true, "sub.txt": true, "stranger.txt": false} {
+		_, err := ioutil.ReadFile(c.Repo.Abs(rel))
+		if (err == nil) != want {
+			t.Errorf("%s: cloned=%v, want %v", rel, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// eventKindTag marks a snapshot of the whole working set under a name.
+const eventKindTag = 4445
+
+// manifestEntry pins one file to the event holding its content.
+type manifestEntry struct {
+	Path    string
+	EventID string
+	Hash    string
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// manifest lists the last published event of every tracked file. With clean
+// set it fails if any file was never published or has changed since.
+func (c *Client) manifest(clean bool) ([]manifestEntry, error) {
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return nil, err
+	}
+	var entries []manifestEntry
+	for _, p := range idx.Paths() {
+		e := idx.Files[p]
+		if e.EventID == "" {
+			if clean {
+				return nil, fmt.Errorf("%w: %s has never been published", ErrConflict, p)
+			}
+			continue
+		}
+		if clean {
+			hash, err := hashFile(c.Repo.Abs(p))
+			if err != nil {
+				return nil, err
+			}
+			if hash != e.Hash {
+				return nil, fmt.Errorf("%w: %s has unpublished changes", ErrConflict, p)
+			}
+		}
+		entries = append(entries, manifestEntry{Path: p, EventID: e.EventID, Hash: e.Hash})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("nothing has been published yet")
+	}
+	return entries, nil
+}
+
+// manifestTags encodes entries as "file" tags: path, event id, hash.
+func manifestTags(entries []manifestEntry) nostr.Tags {
+	var tags nostr.Tags
+	for _, e := range entries {
+		tags = append(tags, nostr.Tag{"file", e.Path, e.EventID, e.Hash})
+	}
+	return tags
+}
+
+// parseManifest reverses manifestTags. Tags in a format this orbi can't read
+// have no usable manifest.
+func parseManifest(ev *nostr.Event) []manifestEntry {
+	if checkEventFormat(ev) != nil {
+		return nil
+	}
+	var entries []manifestEntry
+	for tag := range ev.Tags.FindAll("file") {
+		if len(tag) < 3 {
+			continue
+		}
+		e := manifestEntry{Path: tag[1], EventID: tag[2]}
+		if len(tag) > 3 {
+			e.Hash = tag[3]
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// publishTag publishes a tag event named name pinning entries, with content
+// as its body and any extra tags appended.
+func (c *Client) publishTag(name, content string, entries []manifestEntry, extra ...nostr.Tag) (*nostr.Event, error) {
+	ev := nostr.Event{
+		PubKey:    c.pk,
+		CreatedAt: nostr.Now(),
+		Kind:      c.kinds("").Tag,
+		Content:   content,
+		Tags:      nostr.Tags{{"ver", eventFormatVersion}, {"name", name}},
+	}
+	ev.Tags = append(ev.Tags, manifestTags(entries)...)
+	ev.Tags = append(ev.Tags, extra...)
+	if err := c.checkSize(&ev, name); err != nil {
+		return nil, err
+	}
+	if err := c.sign(&ev); err != nil {
+		return nil, err
+	}
+	if err := c.publish(&ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// TagEvents returns the tag events published by author, newest first. An
+// empty author means the client's own key.
+func (c *Client) TagEvents(author string) []*nostr.Event {
+	if author == "" {
+		author = c.pk
+	}
+	events := c.query(nostr.Filter{Kinds: []int{c.kinds(author).Tag}, Authors: []string{author}})
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt > events[j].CreatedAt })
+	return events
+}
+
+// tagName returns the name of a tag event.
+func tagName(ev *nostr.Event) string {
+	if tag := ev.Tags.Find("name"); tag != nil {
+		return tag[1]
+	}
+	return ""
+}
+
+// findTag returns the newest tag event named name published by any of
+// authors.
+func (c *Client) findTag(name string, authors []string) (*nostr.Event, error) {
+	var found *nostr.Event
+	for _, a := range authors {
+		for _, ev := range c.TagEvents(a) {
+			if tagName(ev) != name || ev.PubKey != a || !validSignature(ev) {
+				continue
+			}
+			if found == nil || before(found, ev) {
+				found = ev
+			}
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("tag %s: %w", name, ErrNotFound)
+	}
+	return found, nil
+}
+
+// checkoutTag restores every file pinned by the tag event to the version it
+// pins, which must be published by one of authors. Files with local changes
+// are refused unless force is set, before anything is written.
+func (c *Client) checkoutTag(tag *nostr.Event, authors []string, force bool) (int, error) {
+	entries := parseManifest(tag)
+	if len(entries) == 0 {
+		return 0, fmt.Errorf("tag %s pins no files", tagName(tag))
+	}
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if err := checkRel(e.Path); err != nil {
+			return 0, fmt.Errorf("tag %s: %w", tagName(tag), err)
+		}
+		if entry, ok := idx.Files[e.Path]; ok && !force {
+			if modified, err := c.modifiedLocally(entry); err != nil {
+				return 0, err
+			} else if modified {
+				return 0, fmt.Errorf("%w: %s has local changes; use --force to discard them", ErrConflict, e.Path)
+			}
+		}
+	}
+	restored := 0
+	for _, e := range entries {
+		if entry, ok := idx.Files[e.Path]; ok && entry.EventID == e.EventID {
+			continue
+		}
+		ev, err := c.eventByID(e.EventID)
+		if err != nil {
+			return restored, fmt.Errorf("%s: %w", e.Path, err)
+		}
+		if !contains(authors, ev.PubKey) {
+			return restored, fmt.Errorf("%w: %s in tag %s points to an event by another author", ErrUntrusted, e.Path, tagName(tag))
+		}
+		previous, err := c.restoreVersion(e.Path, ev)
+		if err != nil {
+			return restored, fmt.Errorf("%s: %w", e.Path, err)
+		}
+		if err := c.Repo.LogHead("checkout", e.Path, previous, ev.ID, c.fileMessage(ev)); err != nil {
+			return restored, err
+		}
+		fmt.Printf("  %s\n", e.Path)
+		restored++
+	}
+	return restored, nil
+}
+
+func cmdTag(args []string) error {
+	fs := flag.NewFlagSet("tag", flag.ContinueOnError)
+	allowDirty := fs.Bool("allow-dirty", false, "tag the last published versions even if files have changed since")
+	message := fs.String("m", "", "describe the snapshot")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 1 {
+		return fmt.Errorf("usage: orbi tag [--allow-dirty] [-m <message>] [<name>]")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+
+	if len(positional) == 0 {
+		for _, ev := range client.TagEvents("") {
+			fmt.Printf("%-20s %s  %s\n", tagName(ev), ev.CreatedAt.Time().Format("2006-01-02 15:04"), short(ev.ID))
+		}
+		return nil
+	}
+	name := positional[0]
+	if _, err := client.findTag(name, client.headAuthors()); err == nil {
+		return fmt.Errorf("%w: tag %s already exists", ErrConflict, name)
+	} else if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	entries, err := client.manifest(!*allowDirty)
+	if err != nil {
+		return err
+	}
+	ev, err := client.publishTag(name, *message, entries)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Tagged %d file(s) as %s\nEvent ID: %s\n", len(entries), name, ev.ID)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RelayTLS holds the TLS settings for one relay in .orbi/config.
+type RelayTLS struct {
+	// PinSHA256 lists hex SHA-256 fingerprints of acceptable leaf
+	// certificate public keys (SubjectPublicKeyInfo). When set, the relay
+	// must present one of them in addition to passing normal verification.
+	PinSHA256 []string `json:"pin_sha256,omitempty"`
+
+	// CAFile is a PEM bundle of certificate authorities trusted for this
+	// relay instead of the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+
+	// InsecureSkipVerify disables chain and hostname verification. Pins are
+	// still enforced. Only meant for development relays.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+func (rt *RelayTLS) validate(url string) error {
+	for _, pin := range rt.PinSHA256 {
+		if b, err := hex.DecodeString(pin); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("relay_tls %s: invalid pin %q: must be a hex SHA-256", url, pin)
+		}
+	}
+	return nil
+}
+
+// tlsConfig builds the tls.Config for rt.
+func (rt *RelayTLS) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: rt.InsecureSkipVerify}
+	if rt.CAFile != "" {
+		pem, err := ioutil.ReadFile(expandPath(rt.CAFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", rt.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(rt.PinSHA256) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range rt.PinSHA256 {
+			pins[strings.ToLower(pin)] = true
+		}
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return fmt.Errorf("relay presented no certificate")
+			}
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+			if got := hex.EncodeToString(sum[:]); !pins[got] {
+				return fmt.Errorf("certificate key %s does not match any pinned fingerprint", got)
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// relayTLSConfigs builds the per-relay TLS configurations from cfg, keyed by
+// normalized relay URL.
+func relayTLSConfigs(cfg *Config) (map[string]*tls.Config, error) {
+	if len(cfg.RelayTLS) == 0 {
+		return nil, nil
+	}
+	configs := make(map[string]*tls.Config, len(cfg.RelayTLS))
+	for url, rt := range cfg.RelayTLS {
+		tc, err := rt.tlsConfig()
+		if err != nil {
+			return nil, fmt.Errorf("relay_tls %s: %w", url, err)
+		}
+		configs[nostr.NormalizeURL(url)] = tc
+	}
+	return configs, nil
+}
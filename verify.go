@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+// verifyFile checks the newest version of rel on the relays against the
+// local copy and returns every problem found: events whose ID or signature
+// doesn't hold up, events signed by anyone but authors, content that can't
+// be read or doesn't match its hash tag, a working copy that differs, and an index recording a
+// different version. It also returns the version it checked, if any.
+func (c *Client) verifyFile(rel string, authors []string, cfg *Config) (*nostr.Event, []string, error) {
+	var problems []string
+	var latest *nostr.Event
+	for _, ev := range c.query(nostr.Filter{
+		Kinds:   c.fileKinds(authors),
+		Authors: authors,
+		Tags:    nostr.TagMap{"f": []string{c.pathTag(rel)}},
+	}) {
+		switch {
+		case !ev.CheckID():
+			problems = append(problems, fmt.Sprintf("event %s does not match its ID", ev.ID))
+		case !validSignature(ev):
+			problems = append(problems, fmt.Sprintf("event %s has an invalid signature", ev.ID))
+		case !contains(authors, ev.PubKey):
+			npub, _ := nip19.EncodePublicKey(ev.PubKey)
+			problems = append(problems, fmt.Sprintf("event %s is signed by %s, not the repository owner", ev.ID, npub))
+		case latest == nil || before(latest, ev):
+			latest = ev
+		}
+	}
+	if latest == nil {
+		return nil, append(problems, "no valid version on the relays"), nil
+	}
+
+	content, err := c.eventContent(latest)
+	if err != nil {
+		return latest, append(problems, fmt.Sprintf("event %s: %v", latest.ID, err)), nil
+	}
+	remote := orbi.ContentHash(content)
+
+	local, err := ioutil.ReadFile(c.Repo.Abs(rel))
+	if os.IsNotExist(err) {
+		problems = append(problems, "missing from the working copy")
+	} else if err != nil {
+		return latest, nil, err
+	} else if orbi.ContentHash(normalizeEOL(local, cfg.EOL)) != remote {
+		problems = append(problems, fmt.Sprintf("working copy differs from %s", latest.ID))
+	}
+
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return latest, nil, err
+	}
+	if entry, ok := idx.Files[rel]; ok && entry.EventID != "" && entry.EventID != latest.ID {
+		problems = append(problems, fmt.Sprintf("index records %s but the relays' newest version is %s", entry.EventID, latest.ID))
+	}
+	return latest, problems, nil
+}
+
+// validSignature reports whether ev carries a valid Schnorr signature.
+func validSignature(ev *nostr.Event) bool {
+	ok, err := ev.CheckSignature()
+	return err == nil && ok
+}
+
+func cmdVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	var owners stringList
+	fs.Var(&owners, "author", "npub expected to have signed the files (repeatable; defaults to you and the origin)")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: orbi verify [--author <npub>]... <file>...")
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	authors := client.pullAuthors(cfg)
+	if len(owners) > 0 {
+		authors = nil
+		for _, o := range owners {
+			pk, err := parsePubkey(o)
+			if err != nil {
+				return err
+			}
+			authors = append(authors, pk)
+		}
+	}
+
+	failed := 0
+	for _, arg := range positional {
+		rel, err := client.Repo.Rel(arg)
+		if err != nil {
+			return err
+		}
+		ev, problems, err := client.verifyFile(rel, authors, cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("ok      %s (%s)\n", rel, ev.ID)
+			continue
+		}
+		failed++
+		fmt.Printf("FAILED  %s\n", rel)
+		for _, p := range problems {
+			fmt.Printf("        %s\n", p)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d file(s) failed verification", ErrUntrusted, failed, len(positional))
+	}
+	return nil
+}
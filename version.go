@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Event format versions are "major" or "major.minor". A newer minor version
+// only adds tags that older readers can safely ignore; a newer major version
+// changes how content is stored, so older readers must not interpret it.
+
+var (
+	warnedVersionsMu sync.Mutex
+	warnedVersions   = make(map[string]bool)
+)
+
+// parseFormatVersion splits a "ver" tag value into major and minor.
+func parseFormatVersion(v string) (major, minor int, err error) {
+	parts := strings.SplitN(v, ".", 2)
+	if major, err = strconv.Atoi(parts[0]); err != nil || major < 0 {
+		return 0, 0, fmt.Errorf("invalid format version %q", v)
+	}
+	if len(parts) == 2 {
+		if minor, err = strconv.Atoi(parts[1]); err != nil || minor < 0 {
+			return 0, 0, fmt.Errorf("invalid format version %q", v)
+		}
+	}
+	return major, minor, nil
+}
+
+// checkEventFormat returns an ErrUnsupported error if ev was written in a
+// format this orbi can't read. Events without a "ver" tag predate it and are
+// read as legacy content. The first event seen from each unknown major
+// version is also logged as a warning.
+func checkEventFormat(ev *nostr.Event) error {
+	tag := ev.Tags.Find("ver")
+	if tag == nil {
+		return nil
+	}
+	major, _, err := parseFormatVersion(tag[1])
+	if err != nil {
+		return fmt.Errorf("%w: event %s: %v", ErrUnsupported, ev.ID, err)
+	}
+	ours, _, _ := parseFormatVersion(eventFormatVersion)
+	if major <= ours {
+		return nil
+	}
+	warnedVersionsMu.Lock()
+	if !warnedVersions[tag[1]] {
+		warnedVersions[tag[1]] = true
+		slog.Warn("Some events use a newer format than this orbi understands; please upgrade", "version", tag[1], "supported", eventFormatVersion)
+	}
+	warnedVersionsMu.Unlock()
+	return fmt.Errorf("%w: event %s uses format version %s", ErrUnsupported, ev.ID, tag[1])
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultWatchDebounce = 500 * time.Millisecond
+	watchTickInterval    = 100 * time.Millisecond
+)
+
+// defaultWatchIgnore matches the scratch files editors create while saving,
+// which must never be published on their own.
+var defaultWatchIgnore = []string{"*.swp", "*.swx", "*~", ".#*", "#*#", "*.tmp", "4913", ".*.kate-swp"}
+
+// WatchConfig tunes orbi watch.
+type WatchConfig struct {
+	// Debounce is how long a file must stay unchanged before it is
+	// published, as a Go duration such as "2s".
+	Debounce string `json:"debounce,omitempty"`
+	// Ignore lists extra gitignore-style patterns of files to leave alone.
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+func (w *WatchConfig) validate() error {
+	if w.Debounce != "" {
+		if _, err := time.ParseDuration(w.Debounce); err != nil {
+			return fmt.Errorf("watch debounce: %w", err)
+		}
+	}
+	for _, p := range w.Ignore {
+		if _, err := globRegexp(p); err != nil {
+			return fmt.Errorf("watch ignore %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// watchSettings returns the debounce period and the ignore patterns from cfg
+// added to the repository's.
+func watchSettings(cfg *Config, ignore []string) (time.Duration, []string) {
+	debounce := defaultWatchDebounce
+	if cfg.Watch != nil {
+		if d, err := time.ParseDuration(cfg.Watch.Debounce); err == nil {
+			debounce = d
+		}
+		ignore = append(append([]string(nil), ignore...), cfg.Watch.Ignore...)
+	}
+	return debounce, ignore
+}
+
+// debouncer coalesces bursts of changes: a path becomes ready once it has
+// been quiet for the delay, however many times it changed before that.
+type debouncer struct {
+	delay   time.Duration
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func newDebouncer(delay time.Duration) *debouncer {
+	return &debouncer{delay: delay, pending: make(map[string]time.Time)}
+}
+
+// touch records a change to path at now.
+func (d *debouncer) touch(path string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending[path] = now
+}
+
+// ready removes and returns, sorted, the paths quiet since before now-delay.
+func (d *debouncer) ready(now time.Time) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var paths []string
+	for p, t := range d.pending {
+		if now.Sub(t) >= d.delay {
+			paths = append(paths, p)
+			delete(d.pending, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// watchDirs adds the directories holding tracked files to w, so that
+// editors replacing a file on save are noticed too, and returns how many
+// files are watched. watched remembers directories already added.
+func (c *Client) watchDirs(w *fsnotify.Watcher, ignore []string, watched map[string]bool) (int, error) {
+	paths, err := c.Repo.TrackedFiles()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, rel := range paths {
+		if ignored(ignore, rel) {
+			continue
+		}
+		n++
+		dir := filepath.Dir(c.Repo.Abs(rel))
+		if watched[dir] {
+			continue
+		}
+		if err := w.Add(dir); err != nil {
+			// The directory may not exist until the file is restored.
+			continue
+		}
+		watched[dir] = true
+	}
+	return n, nil
+}
+
+// trackedChange returns the repo-relative path of the tracked, non-ignored
+// file an fsnotify event is about, or "".
+func (c *Client) trackedChange(ev fsnotify.Event, ignore []string) string {
+	if !ev.Op.Has(fsnotify.Write) && !ev.Op.Has(fsnotify.Create) && !ev.Op.Has(fsnotify.Rename) {
+		return ""
+	}
+	rel, err := c.Repo.Rel(ev.Name)
+	if err != nil || ignored(ignore, rel) {
+		return ""
+	}
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return ""
+	}
+	if _, ok := idx.Files[rel]; !ok {
+		return ""
+	}
+	return rel
+}
+
+// publishIfChanged publishes rel unless its content matches what the index
+// says was last published. Private files stay encrypted to their recipients,
+// since commitFile re-applies them.
+func (c *Client) publishIfChanged(rel string) error {
+	content, err := ioutil.ReadFile(c.Repo.Abs(rel))
+	if err != nil {
+		return err
+	}
+	idx, err := c.Repo.Index()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	if entry, ok := idx.Files[rel]; ok && entry.Hash == hex.EncodeToString(sum[:]) {
+		return nil
+	}
+	ev, err := c.PublishFile(c.Repo.Abs(rel), "Update "+rel)
+	if err != nil {
+		return err
+	}
+	slog.Info("Published", "file", rel, "id", ev.ID)
+	return nil
+}
+
+func cmdWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	debounceFlag := fs.Duration("debounce", 0, "quiet period before publishing a change (default from config, else 500ms)")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	client, err := newCLIClient()
+	if err != nil {
+		return err
+	}
+	cfg, err := client.Repo.Config()
+	if err != nil {
+		return err
+	}
+	patterns, err := client.Repo.ignorePatterns()
+	if err != nil {
+		return err
+	}
+	debounce, ignore := watchSettings(cfg, patterns)
+	if *debounceFlag > 0 {
+		debounce = *debounceFlag
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	watched := make(map[string]bool)
+	n, err := client.watchDirs(w, ignore, watched)
+	if err != nil {
+		return err
+	}
+	d := newDebouncer(debounce)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(watchTickInterval)
+	defer ticker.Stop()
+	slog.Info("Watching tracked files; press Ctrl-C to stop", "files", n, "debounce", debounce)
+	for {
+		select {
+		case <-interrupt:
+			return nil
+		case ev := <-w.Events:
+			if rel := client.trackedChange(ev, ignore); rel != "" {
+				d.touch(rel, time.Now())
+			}
+		case err := <-w.Errors:
+			slog.Warn("Watch error", "err", err)
+		case now := <-ticker.C:
+			for _, rel := range d.ready(now) {
+				if err := client.publishIfChanged(rel); err != nil {
+					slog.Error("Failed to publish", "file", rel, "err", err)
+				}
+			}
+			// Pick up files tracked since the watch started.
+			if _, err := client.watchDirs(w, ignore, watched); err != nil {
+				return err
+			}
+		}
+	}
+}
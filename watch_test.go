@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+
+	"github.com/bquast/orbi/pkg/orbi"
+)
+
+func TestPublishIfChanged(t *testing.T) {
+	c, transport := newTestClient(t)
+	for _, name := range []string{"public.txt", "private.txt"} {
+		if err := ioutil.WriteFile(c.Repo.Abs(name), []byte("one\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.PublishFile(c.Repo.Abs("public.txt"), "one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PublishFile(c.Repo.Abs("private.txt"), "one", orbi.WithEncryption(c.sk, c.pk), orbi.WithHiddenPath()); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		events, err := transport.Fetch(context.Background(), testRelayURL, nostr.Filter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(events)
+	}
+	published := count()
+
+	// Unchanged files are not published again.
+	for _, rel := range []string{"public.txt", "private.txt"} {
+		if err := c.publishIfChanged(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(); n != published {
+		t.Fatalf("unchanged files were published: %d events, want %d", n, published)
+	}
+
+	for _, rel := range []string{"public.txt", "private.txt"} {
+		if err := ioutil.WriteFile(c.Repo.Abs(rel), []byte("two\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.publishIfChanged(rel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx, err := c.Repo.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rel, private := range map[string]bool{"public.txt": false, "private.txt": true} {
+		entry := idx.Files[rel]
+		ev, err := c.eventByID(entry.EventID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted := ev.Tags.Find("encrypted") != nil; encrypted != private || entry.Encrypted != private {
+			t.Errorf("%s: new version encrypted=%v, want %v", rel, encrypted, private)
+		}
+		if private && (entry.PathTag == "" || eventPath(ev) != entry.PathTag) {
+			t.Errorf("%s: new version is published under %q, want the hidden path %q", rel, eventPath(ev), entry.PathTag)
+		}
+		if content, err := c.eventContent(ev); err != nil || string(content) != "two\n" {
+			t.Errorf("%s: new version has %q, %v", rel, content, err)
+		}
+	}
+}
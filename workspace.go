@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const registryFileName = "repos"
+
+var registryMu sync.Mutex
+
+// userConfigPath returns the path of name inside orbi's per-user
+// configuration directory.
+func userConfigPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "orbi", name), nil
+}
+
+// registeredRepos returns the repository roots in the workspace registry.
+func registeredRepos() ([]string, error) {
+	path, err := userConfigPath(registryFileName)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var repos []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			repos = append(repos, line)
+		}
+	}
+	return repos, nil
+}
+
+func writeRegistry(repos []string) error {
+	path, err := userConfigPath(registryFileName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	sort.Strings(repos)
+	return ioutil.WriteFile(path, []byte(strings.Join(repos, "\n")+"\n"), 0644)
+}
+
+// register adds the repository to the workspace registry.
+func (r *Repo) register() error {
+	root, err := filepath.Abs(r.root)
+	if err != nil {
+		return err
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	repos, err := registeredRepos()
+	if err != nil {
+		return err
+	}
+	if contains(repos, root) {
+		return nil
+	}
+	return writeRegistry(append(repos, root))
+}
+
+// foreachResult is the outcome of running a command in one repository.
+type foreachResult struct {
+	repo   string
+	output string
+	err    error
+}
+
+// runForeach runs argv in every registered repository, or when shell is set
+// the single shell command line argv[0] as given, and collects the results
+// in registry order.
+func runForeach(repos []string, argv []string, shell bool) []foreachResult {
+	self, err := os.Executable()
+	if err != nil {
+		self = "orbi"
+	}
+	results := make([]foreachResult, 0, len(repos))
+	for _, repo := range repos {
+		var cmd *exec.Cmd
+		if shell {
+			cmd = exec.Command("sh", "-c", argv[0])
+		} else {
+			cmd = exec.Command(self, argv...)
+		}
+		cmd.Dir = repo
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		err := cmd.Run()
+		results = append(results, foreachResult{repo: repo, output: out.String(), err: err})
+	}
+	return results
+}
+
+func cmdForeach(args []string) error {
+	fs := flag.NewFlagSet("foreach", flag.ContinueOnError)
+	shell := fs.Bool("shell", false, "run a shell command instead of an orbi subcommand")
+	list := fs.Bool("list", false, "list registered repositories and exit")
+	prune := fs.Bool("prune", false, "drop registered repositories that no longer exist")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	repos, err := registeredRepos()
+	if err != nil {
+		return err
+	}
+	var live []string
+	for _, repo := range repos {
+		if _, err := os.Stat(filepath.Join(repo, localOrbiDirName)); err == nil {
+			live = append(live, repo)
+		} else if !*prune {
+			fmt.Fprintf(os.Stderr, "skipping %s: no %s directory (use --prune to forget it)\n", repo, localOrbiDirName)
+		}
+	}
+	if *prune {
+		registryMu.Lock()
+		err := writeRegistry(live)
+		registryMu.Unlock()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pruned %d repositories\n", len(repos)-len(live))
+	}
+	if *list {
+		for _, repo := range live {
+			fmt.Println(repo)
+		}
+		return nil
+	}
+	if fs.NArg() == 0 {
+		if *prune {
+			return nil
+		}
+		return fmt.Errorf("usage: orbi foreach [--list] [--prune] [--] <command> [args...] | --shell '<command line>'")
+	}
+	if *shell && fs.NArg() != 1 {
+		// Joining words would lose their quoting.
+		return fmt.Errorf("--shell takes the command line as a single argument; quote it")
+	}
+
+	failed := 0
+	for _, res := range runForeach(live, fs.Args(), *shell) {
+		status := "ok"
+		if res.err != nil {
+			status = "FAILED: " + res.err.Error()
+			failed++
+		}
+		fmt.Printf("== %s (%s)\n%s", res.repo, status, res.output)
+		if res.output != "" && !strings.HasSuffix(res.output, "\n") {
+			fmt.Println()
+		}
+	}
+	fmt.Printf("\n%d repositories, %d succeeded, %d failed\n", len(live), len(live)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("command failed in %d repositories", failed)
+	}
+	return nil
+}